@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
+)
+
+func main() {
+	// Define flags
+	var dir string
+	flag.StringVar(&dir, "dir", os.Getenv("JWT_KEYRING_DIR"), "Keyring directory (defaults to $JWT_KEYRING_DIR)")
+
+	var algorithm string
+	flag.StringVar(&algorithm, "alg", "RS256", "Algorithm of newly generated keys (HS256, RS256, ES256 or EdDSA)")
+
+	var publish time.Duration
+	flag.DurationVar(&publish, "publish", 10*time.Minute, "How long a new key only verifies tokens before it signs; use at least the servers' JWT_KEYRING_CHECK_INTERVAL plus the 5m JWKS cache lifetime")
+
+	var retain time.Duration
+	flag.DurationVar(&retain, "retain", 7*24*time.Hour, "How long a retired key keeps verifying tokens; use the longest token lifetime plus leeway")
+
+	flag.Usage = func() {
+		fmt.Println("Usage: keyring [flags] <init|rotate|prune|list>")
+		fmt.Println()
+		fmt.Println("  init    create a keyring with a single active key")
+		fmt.Println("  rotate  stage a new key that becomes active after -publish and retire the current one")
+		fmt.Println("  prune   remove retired keys that can no longer verify tokens")
+		fmt.Println("  list    show the keys in the keyring")
+		fmt.Println()
+		flag.PrintDefaults()
+	}
+
+	// Parse flags
+	flag.Parse()
+
+	if dir == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch flag.Arg(0) {
+	case "init":
+		err = initKeyring(dir, algorithm)
+	case "rotate":
+		err = rotateKeyring(dir, algorithm, publish, retain)
+	case "prune":
+		err = pruneKeyring(dir)
+	case "list":
+		err = listKeyring(dir)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func initKeyring(dir, algorithm string) error {
+	if _, err := auth.LoadKeyring(dir); err == nil {
+		return errors.New("keyring already exists, use rotate instead")
+	}
+
+	key, err := auth.GenerateSigningKey(algorithm, auth.SystemClock)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.NewKeyring(key).Save(dir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created keyring in %s with active key %s (%s)\n", dir, key.ID, key.Algorithm())
+	return nil
+}
+
+func rotateKeyring(dir, algorithm string, publish, retain time.Duration) error {
+	unlock, err := auth.LockKeyringDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keyring, err := auth.LoadKeyring(dir)
+	if err != nil {
+		return err
+	}
+
+	previous := keyring.Active()
+	key, err := keyring.Rotate(algorithm, publish, retain)
+	if err != nil {
+		return err
+	}
+	keyring.Prune()
+
+	if err := keyring.Save(dir); err != nil {
+		return err
+	}
+
+	if _, promoteAt, staged := keyring.Staged(); staged {
+		fmt.Printf("New key %s (%s) verifies tokens now and signs from %s\n", key.ID, key.Algorithm(), promoteAt.Format(time.RFC3339))
+	} else {
+		fmt.Printf("New active key %s (%s)\n", key.ID, key.Algorithm())
+	}
+	if retired, ok := keyring.Get(previous.ID); ok {
+		fmt.Printf("Retired key %s verifies tokens until %s\n", retired.ID, retired.VerifyUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func pruneKeyring(dir string) error {
+	unlock, err := auth.LockKeyringDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keyring, err := auth.LoadKeyring(dir)
+	if err != nil {
+		return err
+	}
+
+	pruned := keyring.Prune()
+	if err := keyring.Save(dir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Pruned %d retired keys\n", pruned)
+	return nil
+}
+
+func listKeyring(dir string) error {
+	keyring, err := auth.LoadKeyring(dir)
+	if err != nil {
+		return err
+	}
+
+	active := keyring.Active()
+	staged, promoteAt, _ := keyring.Staged()
+	fmt.Println("=============================================")
+	for _, key := range keyring.Keys() {
+		status := "active"
+		switch {
+		case staged != nil && key.ID == staged.ID:
+			status = "staged, signs from " + promoteAt.Format(time.RFC3339)
+		case key.ID != active.ID:
+			status = "retired, verifies until " + key.VerifyUntil.Format(time.RFC3339)
+		}
+		fmt.Printf("%s  %-5s  created %s  %s\n", key.ID, key.Algorithm(), key.CreatedAt.Format(time.RFC3339), status)
+	}
+	fmt.Println("=============================================")
+	return nil
+}
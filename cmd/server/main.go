@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"log"
 	"net/http"
 	"os"
@@ -42,6 +43,9 @@ import (
 // @in header
 // @name Authorization
 // @description Type "Bearer" followed by a space and the JWT token.
+
+// @securityDefinitions.basic BasicAuth
+// @description OAuth client ID and secret, for token introspection and revocation.
 func main() {
 	// Load configuration
 	cfg := config.NewConfig()
@@ -81,6 +85,19 @@ func main() {
 	// Create user service
 	userService := models.NewUserService(userRepo)
 
+	// Roles and permissions live next to the users
+	var roleStore auth.RoleStore
+	if postgres != nil {
+		roleStore = auth.NewPostgresRoleStore(postgres.DB)
+	} else {
+		userRoles := make(map[int][]string)
+		for _, user := range models.DefaultUsers {
+			userRoles[user.ID] = []string{user.Role}
+		}
+		roleStore = auth.NewMemoryRoleStore(auth.DefaultRoles, userRoles)
+	}
+	authorizer := auth.NewAuthorizer(roleStore, cfg.RBACCacheTTL)
+
 	// Initialize Redis client
 	redisClient := redis.NewClient(&redis.Options{
 		Addr:     cfg.RedisAddr,
@@ -90,28 +107,228 @@ func main() {
 
 	// Test Redis connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	_, err = redisClient.Ping(ctx).Result()
-	if err != nil {
-		log.Printf("Warning: Redis connection failed: %v", err)
-		log.Println("Continuing without Redis (token blacklisting will not work)")
-	} else {
+	cancel()
+
+	redisAvailable := err == nil
+	if redisAvailable {
 		log.Println("Connected to Redis successfully")
+	} else {
+		log.Printf("Warning: Redis connection failed: %v", err)
+	}
+
+	// Initialize revocation store. Falling back to process memory silently
+	// would forget revocations on restart and not share them between
+	// instances, so it has to be asked for.
+	var revocationStore auth.RevocationStore
+
+	switch cfg.Revocation.Store {
+	case "redis":
+		if redisAvailable {
+			revocationStore = auth.NewRedisRevocationStore(redisClient)
+			log.Println("Using Redis revocation store")
+		} else if !cfg.Revocation.MemoryFallback {
+			log.Fatal("Redis revocation store is unavailable, set REVOCATION_MEMORY_FALLBACK=true to continue with the in-memory store")
+		} else {
+			log.Println("Warning: Redis revocation store is unavailable, continuing with in-memory revocation store")
+		}
+	case "postgres":
+		if postgres != nil {
+			postgresStore := auth.NewPostgresRevocationStore(postgres.DB, cfg.Revocation.CleanupInterval)
+			defer postgresStore.Close()
+
+			revocationStore = postgresStore
+			log.Println("Using PostgreSQL revocation store")
+		} else if !cfg.Revocation.MemoryFallback {
+			log.Fatal("PostgreSQL revocation store selected but no database is available, set REVOCATION_MEMORY_FALLBACK=true to continue with the in-memory store")
+		} else {
+			log.Println("Warning: PostgreSQL revocation store selected but no database is available, continuing with in-memory revocation store")
+		}
+	case "memory":
+	default:
+		log.Fatalf("Unknown revocation store %q, expected redis, postgres or memory", cfg.Revocation.Store)
+	}
+
+	// Revocations are announced to other instances over Redis pub/sub when possible
+	var invalidator auth.RevocationInvalidator
+	if redisAvailable {
+		invalidator = auth.NewRedisRevocationInvalidator(redisClient)
+	}
+
+	if revocationStore != nil && cfg.Revocation.BreakerFailures > 0 {
+		// Stop hammering a failing remote store and fail fast instead
+		breakerStore := auth.NewBreakerRevocationStore(revocationStore, cfg.Revocation.BreakerFailures, cfg.Revocation.BreakerCooldown)
+
+		expvar.Publish("revocation_breaker", expvar.Func(func() interface{} {
+			return breakerStore.Stats()
+		}))
+
+		revocationStore = breakerStore
+		log.Printf("Opening the revocation store circuit after %d failures for %s", cfg.Revocation.BreakerFailures, cfg.Revocation.BreakerCooldown)
+	}
+
+	if revocationStore != nil && cfg.Revocation.CacheSize > 0 {
+		// Put a local cache in front of the remote store
+		cachedStore := auth.NewCachedRevocationStore(revocationStore, cfg.Revocation.CacheSize, cfg.Revocation.CacheTTL, invalidator)
+		defer cachedStore.Close()
+
+		revocationStore = cachedStore
+		log.Printf("Caching revocation lookups locally for up to %s", cfg.Revocation.CacheTTL)
+	}
+
+	if revocationStore != nil && cfg.Revocation.FilterCapacity > 0 {
+		// Without announcements the filter would miss revocations made by
+		// other instances and wave their tokens through
+		if invalidator == nil {
+			log.Fatal("REVOCATION_FILTER_CAPACITY requires Redis for announcing revocations to other instances")
+		}
+
+		// Skip the store entirely for token IDs the Bloom filter has never seen
+		filteredStore := auth.NewFilteredRevocationStore(
+			revocationStore,
+			cfg.Revocation.FilterCapacity,
+			cfg.Revocation.FilterFPRate,
+			cfg.Revocation.FilterRotation,
+			invalidator,
+		)
+		defer filteredStore.Close()
+
+		expvar.Publish("revocation_filter", expvar.Func(func() interface{} {
+			return filteredStore.Stats()
+		}))
+
+		revocationStore = filteredStore
+		log.Printf("Using Bloom prefilter for revocation lookups, rotated every %s", cfg.Revocation.FilterRotation)
+	}
+
+	// In-memory revocation store when selected, or as the allowed fallback
+	if revocationStore == nil {
+		memoryStore := auth.NewMemoryRevocationStore(cfg.Revocation.MaxEntries, cfg.Revocation.CleanupInterval)
+		defer memoryStore.Close()
+
+		revocationStore = memoryStore
+		log.Println("Using in-memory revocation store")
 	}
 
 	// Initialize JWT manager
-	jwtManager := auth.NewJWTManager(cfg, redisClient)
+	jwtManager, err := auth.NewJWTManager(cfg, revocationStore)
+	if err != nil {
+		log.Fatalf("Failed to initialize JWT manager: %v", err)
+	}
+	// Role claims and scopes follow the assigned roles, not users.role
+	jwtManager.SetAuthorizer(authorizer)
+	expvar.Publish("revocation_availability", expvar.Func(func() interface{} {
+		return jwtManager.AvailabilityStats()
+	}))
+	log.Printf("Revocation checks are %s while the store is unavailable", jwtManager.AvailabilityStats().Policy)
+
+	// Record every login as a session users can list and sign out
+	if redisAvailable {
+		jwtManager.SetSessionStore(auth.NewRedisSessionStore(redisClient))
+		log.Println("Using Redis session store")
+	} else {
+		jwtManager.SetSessionStore(auth.NewMemorySessionStore())
+		log.Println("Using in-memory session store")
+	}
+
+	err = jwtManager.SetSessionLimits(auth.SessionLimits{
+		Default: cfg.Sessions.Limit,
+		Roles:   cfg.Sessions.RoleLimits,
+		Users:   cfg.Sessions.UserLimits,
+		Policy:  cfg.Sessions.LimitPolicy,
+	})
+	if err != nil {
+		log.Fatalf("Invalid session limits: %v", err)
+	}
+
+	activeKey := jwtManager.Keyring().Active()
+	log.Printf("Signing tokens with %s key %s", activeKey.Algorithm(), activeKey.ID)
+
+	// Background jobs stop when the server shuts down
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Keep the keyring in sync with its directory and rotate it on schedule
+	if cfg.JWTKeyringDir != "" {
+		go jwtManager.Keyring().RunSchedule(backgroundCtx, auth.KeyringSchedule{
+			Dir:              cfg.JWTKeyringDir,
+			Algorithm:        activeKey.Algorithm(),
+			RotationInterval: cfg.JWTKeyRotationInterval,
+			Publish:          2*cfg.JWTKeyCheckInterval + auth.JWKSMaxAge,
+			Retain:           cfg.MaxTokenLifetime() + cfg.JWTLeeway,
+			CheckInterval:    cfg.JWTKeyCheckInterval,
+		})
+	} else if cfg.JWTKeyRotationInterval > 0 {
+		log.Println("Warning: scheduled key rotation requires JWT_KEYRING_DIR, rotation disabled")
+	}
 
 	// Initialize auth middleware
-	authMiddleware := middleware.NewAuthMiddleware(jwtManager)
+	authMiddleware := middleware.NewAuthMiddleware(jwtManager, authorizer)
+
+	// Attribute-based rules on top of roles, applied after authentication
+	authorize := func(c *gin.Context) { c.Next() }
+	if cfg.PolicyFile != "" {
+		policy, err := auth.LoadPolicyFile(cfg.PolicyFile)
+		if err != nil {
+			log.Fatalf("Failed to load access policy: %v", err)
+		}
+		policy.DryRun = policy.DryRun || cfg.PolicyDryRun
+
+		engine, err := auth.NewPolicyEngine(*policy)
+		if err != nil {
+			log.Fatalf("Failed to load access policy: %v", err)
+		}
+		authorize = authMiddleware.RequirePolicy(engine)
+
+		if engine.DryRun() {
+			log.Printf("Access policy %s loaded in dry-run mode, denials are only logged", cfg.PolicyFile)
+		} else {
+			log.Printf("Enforcing access policy %s", cfg.PolicyFile)
+		}
+	}
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(jwtManager, userService)
+	roleHandler := handlers.NewRoleHandler(authorizer)
+
+	// Slow down and lock out password guessing, shared across instances through Redis
+	var loginAttempts auth.LoginAttemptStore
+	if redisAvailable {
+		loginAttempts = auth.NewRedisLoginAttemptStore(redisClient)
+	} else {
+		loginAttempts = auth.NewMemoryLoginAttemptStore()
+	}
+	loginLimiter := auth.NewLoginLimiter(loginAttempts, auth.LoginLimits{
+		MaxFailures:     cfg.Login.MaxFailures,
+		IPMaxFailures:   cfg.Login.IPMaxFailures,
+		Window:          cfg.Login.FailureWindow,
+		LockoutDuration: cfg.Login.LockoutDuration,
+		DelayAfter:      cfg.Login.DelayAfter,
+		BaseDelay:       cfg.Login.BaseDelay,
+		MaxDelay:        cfg.Login.MaxDelay,
+	})
+	authHandler.SetLoginLimiter(loginLimiter)
+	lockoutHandler := handlers.NewLockoutHandler(loginLimiter)
+	log.Printf("Locking usernames after %d and IPs after %d failed logins within %s", cfg.Login.MaxFailures, cfg.Login.IPMaxFailures, cfg.Login.FailureWindow)
+	wellKnownHandler := handlers.NewWellKnownHandler(jwtManager, cfg.Issuer)
+	wellKnownHandler.SetPublicClients(cfg.OAuthPublicClients)
+
+	clients := auth.NewClientRegistry(cfg.OAuthClients)
+	if clients.Len() == 0 {
+		log.Println("Warning: no OAUTH_CLIENTS configured, token introspection will reject every caller")
+	}
+	oauthHandler := handlers.NewOAuthHandler(jwtManager, clients)
+	oauthHandler.SetPublicClients(cfg.OAuthPublicClients)
 
 	// Initialize Gin instead of Echo
 	r := gin.Default() // This includes Logger and Recovery middleware
 
+	// Client IPs feed the login limiter and IP policies, so X-Forwarded-For
+	// is only believed from configured proxies
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -134,21 +351,39 @@ func main() {
 		c.String(http.StatusOK, "JWT Blacklisting Demo API")
 	})
 
+	// Key discovery for third-party JWT validators (public)
+	r.GET("/.well-known/jwks.json", wellKnownHandler.JWKS)
+	r.GET("/.well-known/openid-configuration", wellKnownHandler.OpenIDConfiguration)
+
 	// Auth routes (public)
 	r.POST("/api/auth/login", authHandler.Login)
 	r.POST("/api/auth/refresh", authHandler.RefreshToken)
 	r.POST("/api/auth/logout", authHandler.Logout)
 
+	// OAuth endpoints for other services (client credentials checked by the handler)
+	r.POST("/api/oauth/introspect", oauthHandler.Introspect)
+	r.POST("/api/oauth/revoke", oauthHandler.Revoke)
+
 	// Protected routes group
 	protected := r.Group("/api")
-	protected.Use(authMiddleware.Authenticate())
+	protected.Use(authMiddleware.Authenticate(), authorize)
 
 	protected.GET("/protected", authHandler.Protected)
+	protected.POST("/auth/logout-all", authHandler.LogoutAll)
+	protected.GET("/auth/sessions", authHandler.Sessions)
+	protected.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
 
-	// Admin-only routes
-	admin := protected.Group("/admin")
-	admin.Use(authMiddleware.RequireRole("admin"))
+	// Admin-only routes stay fail-closed whatever the availability policy of the rest
+	admin := r.Group("/api/admin")
+	admin.Use(authMiddleware.AuthenticateWithPolicy(auth.FailClosed), authorize, authMiddleware.RequireRole("admin"))
 	admin.GET("/dashboard", authHandler.AdminOnly)
+	admin.GET("/users/:id/roles", authMiddleware.RequirePermission("users:manage"), roleHandler.GetUserRoles)
+	admin.PUT("/users/:id/roles", authMiddleware.RequirePermission("users:manage"), roleHandler.SetUserRoles)
+	admin.DELETE("/lockouts/users/:username", authMiddleware.RequirePermission("users:manage"), lockoutHandler.UnlockUser)
+	admin.DELETE("/lockouts/ips/:ip", authMiddleware.RequirePermission("users:manage"), lockoutHandler.UnlockIP)
+
+	// Runtime stats (revocation filter and friends), which also expose the command line
+	admin.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 
 	// Create http.Server
 	srv := &http.Server{
@@ -3,18 +3,81 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // Config holds all configuration for the application
 type Config struct {
+	Issuer                 string        // public base URL of this server, stamped as "iss" and required by VerifyToken
+	JWTAudience            []string      // stamped as "aud"; VerifyToken requires one of them when set
+	JWTLeeway              time.Duration // clock skew tolerated when checking "exp", "nbf" and "iat"
 	JWTSecret              string
+	JWTAlgorithm           string // HS256, RS256, ES256 or EdDSA
+	JWTPrivateKey          string // inline PEM, takes precedence over JWTPrivateKeyFile
+	JWTPrivateKeyFile      string
+	JWTPublicKey           string // inline PEM, takes precedence over JWTPublicKeyFile
+	JWTPublicKeyFile       string
+	JWTKeyringDir          string        // directory of rotated keys, replaces the single key settings
+	JWTKeyRotationInterval time.Duration // maximum age of the active key, 0 disables scheduled rotation
+	JWTKeyCheckInterval    time.Duration // how often the keyring directory is reloaded and the key age checked
+	JWTIDFormat            string        // token ID format: uuidv7, uuidv4 or ulid
+	JWTIDPrefix            string        // prepended to every token ID, e.g. to identify the issuing instance
 	AccessTokenExpiration  time.Duration
 	RefreshTokenExpiration time.Duration
+	RoleScopes             map[string][]string // scopes granted to each role at login
+	RBACCacheTTL           time.Duration       // how long role lookups are cached, bounding how late role changes apply
+	PolicyFile             string              // YAML or JSON access policy applied to authenticated routes, empty disables it
+	PolicyDryRun           bool                // log policy denials instead of enforcing them
+	OAuthClients           map[string]string   // client ID to secret, for the introspection and revocation endpoints
+	OAuthPublicClients     bool                // let callers without client credentials revoke tokens
+	TrustedProxies         []string            // IPs or CIDRs whose X-Forwarded-For is believed; empty trusts none
 	RedisAddr              string
 	RedisPassword          string
 	RedisDB                int
 	DB                     *DBConfig
+	Revocation             *RevocationConfig
+	Sessions               *SessionConfig
+	Login                  *LoginConfig
+}
+
+// LoginConfig holds login brute-force protection configuration
+type LoginConfig struct {
+	MaxFailures     int           // failed logins that lock a username, 0 disables the lockout
+	IPMaxFailures   int           // failed logins that lock a client IP, 0 disables the lockout
+	FailureWindow   time.Duration // how long a failure counts after the last one
+	LockoutDuration time.Duration // how long a locked username or IP stays locked
+	DelayAfter      int           // failed logins of a username before delays start
+	BaseDelay       time.Duration // first delay, doubled with each further failure; 0 disables delays
+	MaxDelay        time.Duration // cap of the delay
+}
+
+// SessionConfig holds login session configuration
+type SessionConfig struct {
+	Limit       int            // maximum simultaneous sessions per account, 0 means unlimited
+	RoleLimits  map[string]int // per-role overrides of Limit
+	UserLimits  map[string]int // per-username overrides, taking precedence over RoleLimits
+	LimitPolicy string         // "evict-oldest" or "reject" for logins beyond the limit
+	IdleTimeout time.Duration  // sessions not refreshed for this long can't be refreshed, 0 disables it
+	MaxLifetime time.Duration  // sessions can't be refreshed this long after login, 0 disables it
+}
+
+// RevocationConfig holds token revocation store configuration
+type RevocationConfig struct {
+	Store           string        // "redis", "postgres" or "memory"
+	MemoryFallback  bool          // use the memory store when the configured one is unreachable
+	MaxEntries      int           // size cap for the memory store, tokens and user epochs together, 0 means unlimited
+	CleanupInterval time.Duration // how often expired entries are purged
+	CacheSize       int           // local cache entries in front of a remote store, 0 disables it
+	CacheTTL        time.Duration // maximum staleness of a cached lookup
+	FilterCapacity  int           // expected revoked entries for the Bloom prefilter, 0 disables it
+	FilterFPRate    float64       // target false-positive rate of the Bloom prefilter
+	FilterRotation  time.Duration // how often the Bloom prefilter is rebuilt from the store
+	LookupTimeout   time.Duration // deadline of a revocation check, 0 means none
+	RevokeTimeout   time.Duration // deadline of a revocation write, 0 means none
+	Availability    string        // "fail-closed", "fail-open" or "degrade" while the store is failing
+	BreakerFailures int           // consecutive store failures that open the circuit breaker, 0 disables it
+	BreakerCooldown time.Duration // how long an open circuit breaker skips the store
 }
 
 // DBConfig holds database configuration
@@ -33,11 +96,18 @@ type DBConfig struct {
 func NewConfig() *Config {
 	jwtSecret := getEnv("JWT_SECRET", "your-super-secret-key-change-in-production")
 	accessExpStr := getEnv("ACCESS_TOKEN_EXPIRATION", "15m")
-	refreshExpStr := getEnv("REFRESH_TOKEN_EXPIRATION", "7d")
+	refreshExpStr := getEnv("REFRESH_TOKEN_EXPIRATION", "168h")
 
 	accessExp, _ := time.ParseDuration(accessExpStr)
 	refreshExp, _ := time.ParseDuration(refreshExpStr)
 
+	leeway, _ := time.ParseDuration(getEnv("JWT_LEEWAY", "0s"))
+	keyRotationInterval, _ := time.ParseDuration(getEnv("JWT_KEY_ROTATION_INTERVAL", "0"))
+	keyringCheckInterval, _ := time.ParseDuration(getEnv("JWT_KEYRING_CHECK_INTERVAL", "1m"))
+	rbacCacheTTL, _ := time.ParseDuration(getEnv("RBAC_CACHE_TTL", "5s"))
+	policyDryRun, _ := strconv.ParseBool(getEnv("POLICY_DRY_RUN", "false"))
+	oauthPublicClients, _ := strconv.ParseBool(getEnv("OAUTH_PUBLIC_CLIENTS", "false"))
+
 	// Parse database configuration
 	dbPort, _ := strconv.Atoi(getEnv("DB_PORT", "5432"))
 	maxOpenConns, _ := strconv.Atoi(getEnv("DB_MAX_OPEN_CONNS", "10"))
@@ -55,15 +125,160 @@ func NewConfig() *Config {
 		ConnMaxLifetime: connMaxLifetime,
 	}
 
+	// Parse revocation store configuration
+	revocationMaxEntries, _ := strconv.Atoi(getEnv("REVOCATION_MAX_ENTRIES", "100000"))
+	revocationCleanupInterval, _ := time.ParseDuration(getEnv("REVOCATION_CLEANUP_INTERVAL", "1m"))
+	revocationCacheSize, _ := strconv.Atoi(getEnv("REVOCATION_CACHE_SIZE", "10000"))
+	revocationCacheTTL, _ := time.ParseDuration(getEnv("REVOCATION_CACHE_TTL", "5s"))
+	revocationFilterCapacity, _ := strconv.Atoi(getEnv("REVOCATION_FILTER_CAPACITY", "0"))
+	revocationFilterFPRate, _ := strconv.ParseFloat(getEnv("REVOCATION_FILTER_FP_RATE", "0.01"), 64)
+	revocationFilterRotation, _ := time.ParseDuration(getEnv("REVOCATION_FILTER_ROTATION", "15m"))
+	revocationLookupTimeout, _ := time.ParseDuration(getEnv("REVOCATION_LOOKUP_TIMEOUT", "250ms"))
+	revocationRevokeTimeout, _ := time.ParseDuration(getEnv("REVOCATION_REVOKE_TIMEOUT", "2s"))
+	revocationBreakerFailures, _ := strconv.Atoi(getEnv("REVOCATION_BREAKER_FAILURES", "5"))
+	revocationBreakerCooldown, _ := time.ParseDuration(getEnv("REVOCATION_BREAKER_COOLDOWN", "10s"))
+	revocationMemoryFallback, _ := strconv.ParseBool(getEnv("REVOCATION_MEMORY_FALLBACK", "false"))
+
+	revocationConfig := &RevocationConfig{
+		Store:           getEnv("REVOCATION_STORE", "redis"),
+		MemoryFallback:  revocationMemoryFallback,
+		MaxEntries:      revocationMaxEntries,
+		CleanupInterval: revocationCleanupInterval,
+		CacheSize:       revocationCacheSize,
+		CacheTTL:        revocationCacheTTL,
+		FilterCapacity:  revocationFilterCapacity,
+		FilterFPRate:    revocationFilterFPRate,
+		FilterRotation:  revocationFilterRotation,
+		LookupTimeout:   revocationLookupTimeout,
+		RevokeTimeout:   revocationRevokeTimeout,
+		Availability:    getEnv("REVOCATION_AVAILABILITY", "fail-closed"),
+		BreakerFailures: revocationBreakerFailures,
+		BreakerCooldown: revocationBreakerCooldown,
+	}
+
+	// Parse session configuration
+	sessionLimit, _ := strconv.Atoi(getEnv("SESSION_LIMIT", "0"))
+	sessionIdleTimeout, _ := time.ParseDuration(getEnv("SESSION_IDLE_TIMEOUT", "0"))
+	sessionMaxLifetime, _ := time.ParseDuration(getEnv("SESSION_MAX_LIFETIME", "0"))
+
+	sessionConfig := &SessionConfig{
+		Limit:       sessionLimit,
+		RoleLimits:  parseLimits(getEnv("SESSION_ROLE_LIMITS", "")),
+		UserLimits:  parseLimits(getEnv("SESSION_USER_LIMITS", "")),
+		LimitPolicy: getEnv("SESSION_LIMIT_POLICY", "evict-oldest"),
+		IdleTimeout: sessionIdleTimeout,
+		MaxLifetime: sessionMaxLifetime,
+	}
+
+	// Parse login protection configuration
+	loginMaxFailures, _ := strconv.Atoi(getEnv("LOGIN_MAX_FAILURES", "5"))
+	loginIPMaxFailures, _ := strconv.Atoi(getEnv("LOGIN_IP_MAX_FAILURES", "50"))
+	loginFailureWindow, _ := time.ParseDuration(getEnv("LOGIN_FAILURE_WINDOW", "15m"))
+	loginLockoutDuration, _ := time.ParseDuration(getEnv("LOGIN_LOCKOUT_DURATION", "15m"))
+	loginDelayAfter, _ := strconv.Atoi(getEnv("LOGIN_DELAY_AFTER", "3"))
+	loginBaseDelay, _ := time.ParseDuration(getEnv("LOGIN_BASE_DELAY", "1s"))
+	loginMaxDelay, _ := time.ParseDuration(getEnv("LOGIN_MAX_DELAY", "30s"))
+
+	loginConfig := &LoginConfig{
+		MaxFailures:     loginMaxFailures,
+		IPMaxFailures:   loginIPMaxFailures,
+		FailureWindow:   loginFailureWindow,
+		LockoutDuration: loginLockoutDuration,
+		DelayAfter:      loginDelayAfter,
+		BaseDelay:       loginBaseDelay,
+		MaxDelay:        loginMaxDelay,
+	}
+
 	return &Config{
+		Issuer:                 getEnv("JWT_ISSUER", "http://localhost:8080"),
+		JWTAudience:            splitList(getEnv("JWT_AUDIENCE", "")),
+		JWTLeeway:              leeway,
 		JWTSecret:              jwtSecret,
+		JWTAlgorithm:           getEnv("JWT_ALGORITHM", "HS256"),
+		JWTPrivateKey:          getEnv("JWT_PRIVATE_KEY", ""),
+		JWTPrivateKeyFile:      getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTPublicKey:           getEnv("JWT_PUBLIC_KEY", ""),
+		JWTPublicKeyFile:       getEnv("JWT_PUBLIC_KEY_FILE", ""),
+		JWTKeyringDir:          getEnv("JWT_KEYRING_DIR", ""),
+		JWTKeyRotationInterval: keyRotationInterval,
+		JWTKeyCheckInterval:    keyringCheckInterval,
+		JWTIDFormat:            getEnv("JWT_ID_FORMAT", "uuidv7"),
+		JWTIDPrefix:            getEnv("JWT_ID_PREFIX", ""),
 		AccessTokenExpiration:  accessExp,
 		RefreshTokenExpiration: refreshExp,
+		RoleScopes:             parseRoleScopes(getEnv("JWT_ROLE_SCOPES", "admin=profile read write admin;user=profile read")),
+		RBACCacheTTL:           rbacCacheTTL,
+		PolicyFile:             getEnv("POLICY_FILE", ""),
+		PolicyDryRun:           policyDryRun,
+		OAuthClients:           parseClientCredentials(getEnv("OAUTH_CLIENTS", "")),
+		OAuthPublicClients:     oauthPublicClients,
+		TrustedProxies:         splitList(getEnv("TRUSTED_PROXIES", "")),
 		RedisAddr:              getEnv("REDIS_ADDR", "localhost:6379"),
 		RedisPassword:          getEnv("REDIS_PASSWORD", ""),
 		RedisDB:                0,
 		DB:                     dbConfig,
+		Revocation:             revocationConfig,
+		Sessions:               sessionConfig,
+		Login:                  loginConfig,
+	}
+}
+
+// MaxTokenLifetime returns the lifetime of the longest-lived token type
+func (c *Config) MaxTokenLifetime() time.Duration {
+	if c.AccessTokenExpiration > c.RefreshTokenExpiration {
+		return c.AccessTokenExpiration
+	}
+	return c.RefreshTokenExpiration
+}
+
+// splitList parses a comma-separated list, skipping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseClientCredentials parses a comma-separated list of "id:secret" pairs
+func parseClientCredentials(value string) map[string]string {
+	clients := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		clientID, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && clientID != "" && secret != "" {
+			clients[clientID] = secret
+		}
+	}
+	return clients
+}
+
+// parseRoleScopes parses a semicolon-separated list of "role=scope scope" entries
+func parseRoleScopes(value string) map[string][]string {
+	roleScopes := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		role, scopes, ok := strings.Cut(entry, "=")
+		if role = strings.TrimSpace(role); ok && role != "" {
+			roleScopes[role] = strings.Fields(scopes)
+		}
+	}
+	return roleScopes
+}
+
+// parseLimits parses a comma-separated list of "name=limit" entries
+func parseLimits(value string) map[string]int {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		name, limit, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(limit)); err == nil && strings.TrimSpace(name) != "" {
+			limits[strings.TrimSpace(name)] = n
+		}
 	}
+	return limits
 }
 
 // Helper function to get environment variable with a default value
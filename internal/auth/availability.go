@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRevocationStoreUnavailable is returned when a revocation check could not
+// be answered and the availability policy rejects the token
+var ErrRevocationStoreUnavailable = errors.New("revocation store unavailable")
+
+// AvailabilityPolicy decides how VerifyToken treats signature-valid tokens
+// while the revocation store is failing
+type AvailabilityPolicy string
+
+const (
+	// FailClosed rejects every token whose revocation status is unknown
+	FailClosed AvailabilityPolicy = "fail-closed"
+	// FailOpen accepts signature-valid tokens without a revocation check
+	FailOpen AvailabilityPolicy = "fail-open"
+	// DegradeToCache answers from the last known local cache, rejecting
+	// tokens it has no answer for
+	DegradeToCache AvailabilityPolicy = "degrade"
+)
+
+// ParseAvailabilityPolicy parses a policy name, defaulting to FailClosed
+func ParseAvailabilityPolicy(name string) (AvailabilityPolicy, error) {
+	switch policy := AvailabilityPolicy(name); policy {
+	case FailClosed, FailOpen, DegradeToCache:
+		return policy, nil
+	case "":
+		return FailClosed, nil
+	default:
+		return "", fmt.Errorf("unsupported availability policy %q", name)
+	}
+}
+
+type availabilityPolicyKey struct{}
+
+// WithAvailabilityPolicy overrides the JWTManager's availability policy for
+// revocation checks made with the returned context, e.g. for a single route
+func WithAvailabilityPolicy(ctx context.Context, policy AvailabilityPolicy) context.Context {
+	return context.WithValue(ctx, availabilityPolicyKey{}, policy)
+}
+
+// availabilityPolicyFrom returns the context's policy override or fallback
+func availabilityPolicyFrom(ctx context.Context, fallback AvailabilityPolicy) AvailabilityPolicy {
+	if policy, ok := ctx.Value(availabilityPolicyKey{}).(AvailabilityPolicy); ok {
+		return policy
+	}
+	return fallback
+}
+
+// LastKnownRevocations is implemented by stores that keep earlier answers
+// locally and can replay them, however old, while their backend is down
+type LastKnownRevocations interface {
+	// LastKnownRevoked returns the last known status of every token ID, or
+	// false if any of them was never answered
+	LastKnownRevoked(tokenIDs []string) (map[string]bool, bool)
+	// LastKnownUserRevokedBefore returns the last known revocation epoch of the user
+	LastKnownUserRevokedBefore(userID int) (time.Time, bool)
+}
+
+// AvailabilityStats counts the revocation checks decided by the availability
+// policy instead of the revocation store
+type AvailabilityStats struct {
+	Policy          AvailabilityPolicy `json:"policy"`
+	FailedOpen      uint64             `json:"failed_open"`
+	ServedFromCache uint64             `json:"served_from_cache"`
+	Rejected        uint64             `json:"rejected"`
+}
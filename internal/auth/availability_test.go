@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvailabilityPolicies(t *testing.T) {
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+	storeErr := errors.New("connection refused")
+
+	newManager := func(t *testing.T, policy AvailabilityPolicy, store RevocationStore) *JWTManager {
+		jwtManager, err := NewJWTManager(&config.Config{
+			JWTSecret:              "test-secret-key",
+			AccessTokenExpiration:  15 * time.Minute,
+			RefreshTokenExpiration: time.Hour,
+			Revocation:             &config.RevocationConfig{Availability: string(policy)},
+		}, store)
+		require.NoError(t, err)
+		return jwtManager
+	}
+
+	t.Run("fail-closed", func(t *testing.T) {
+		ctx := context.Background()
+		store := newStubRevocationStore()
+		jwtManager := newManager(t, FailClosed, store)
+		accessToken, _, err := jwtManager.GenerateTokens(ctx, user)
+		require.NoError(t, err)
+
+		store.err = storeErr
+		_, err = jwtManager.VerifyToken(ctx, accessToken)
+		assert.ErrorIs(t, err, ErrRevocationStoreUnavailable)
+
+		// Routes can relax the policy for themselves
+		_, err = jwtManager.VerifyToken(WithAvailabilityPolicy(ctx, FailOpen), accessToken)
+		assert.NoError(t, err)
+
+		stats := jwtManager.AvailabilityStats()
+		assert.Equal(t, FailClosed, stats.Policy)
+		assert.Equal(t, uint64(1), stats.Rejected)
+		assert.Equal(t, uint64(1), stats.FailedOpen)
+	})
+
+	t.Run("fail-open", func(t *testing.T) {
+		ctx := context.Background()
+		store := newStubRevocationStore()
+		jwtManager := newManager(t, FailOpen, store)
+		accessToken, _, err := jwtManager.GenerateTokens(ctx, user)
+		require.NoError(t, err)
+
+		store.err = storeErr
+		claims, err := jwtManager.VerifyToken(ctx, accessToken)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, claims.UserID)
+
+		// Signatures are still checked
+		_, err = jwtManager.VerifyToken(ctx, accessToken+"x")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		// Routes can insist on failing closed
+		_, err = jwtManager.VerifyToken(WithAvailabilityPolicy(ctx, FailClosed), accessToken)
+		assert.ErrorIs(t, err, ErrRevocationStoreUnavailable)
+
+		assert.Equal(t, uint64(1), jwtManager.AvailabilityStats().FailedOpen)
+	})
+
+	t.Run("degrade", func(t *testing.T) {
+		ctx := context.Background()
+		remote := newStubRevocationStore()
+		store := NewCachedRevocationStore(remote, 100, time.Minute, nil)
+		t.Cleanup(store.Close)
+		clock := NewFakeClock(time.Now())
+		store.SetClock(clock)
+		jwtManager := newManager(t, DegradeToCache, store)
+
+		knownToken, _, err := jwtManager.GenerateTokens(ctx, user)
+		require.NoError(t, err)
+		revokedToken, _, err := jwtManager.GenerateTokens(ctx, user)
+		require.NoError(t, err)
+		unknownToken, _, err := jwtManager.GenerateTokens(ctx, user)
+		require.NoError(t, err)
+
+		// Populate the cache, then let every answer go stale during an outage
+		_, err = jwtManager.VerifyToken(ctx, knownToken)
+		require.NoError(t, err)
+		require.NoError(t, jwtManager.BlacklistToken(ctx, revokedToken))
+		_, err = jwtManager.VerifyToken(ctx, revokedToken)
+		require.ErrorIs(t, err, ErrTokenBlacklisted)
+
+		remote.err = storeErr
+		clock.Advance(time.Minute)
+
+		// Last known answers are replayed, tokens never seen are rejected
+		_, err = jwtManager.VerifyToken(ctx, knownToken)
+		assert.NoError(t, err)
+		_, err = jwtManager.VerifyToken(ctx, revokedToken)
+		assert.ErrorIs(t, err, ErrTokenBlacklisted)
+		_, err = jwtManager.VerifyToken(ctx, unknownToken)
+		assert.ErrorIs(t, err, ErrRevocationStoreUnavailable)
+
+		stats := jwtManager.AvailabilityStats()
+		assert.Equal(t, uint64(2), stats.ServedFromCache)
+		assert.Equal(t, uint64(1), stats.Rejected)
+	})
+
+	_, err := ParseAvailabilityPolicy("best-effort")
+	assert.Error(t, err)
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFilter is a fixed-size Bloom filter over strings. It is not safe for
+// concurrent use; callers synchronize access.
+type bloomFilter struct {
+	bits    []uint64
+	m       uint64 // number of bits
+	k       uint64 // number of hash functions
+	entries uint64
+}
+
+// newBloomFilter sizes a filter for n entries at false-positive rate p
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// add inserts a key into the filter
+func (f *bloomFilter) add(key string) {
+	h1, h2 := bloomHashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.entries++
+}
+
+// mayContain reports false only if the key was definitely never added
+func (f *bloomFilter) mayContain(key string) bool {
+	h1, h2 := bloomHashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// estimatedFalsePositiveRate uses the standard (1 - e^(-kn/m))^k approximation
+func (f *bloomFilter) estimatedFalsePositiveRate() float64 {
+	return math.Pow(1-math.Exp(-float64(f.k)*float64(f.entries)/float64(f.m)), float64(f.k))
+}
+
+// sizeBytes returns the memory used by the bit array
+func (f *bloomFilter) sizeBytes() int {
+	return len(f.bits) * 8
+}
+
+// bloomHashes derives two hashes for double hashing from a single FNV-1a pass
+func bloomHashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	return sum & 0xffffffff, (sum >> 32) | 1
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerStats describes the state of a BreakerRevocationStore
+type BreakerStats struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Trips               uint64 `json:"trips"`
+	Rejected            uint64 `json:"rejected"`
+}
+
+// BreakerRevocationStore is a circuit breaker around a remote store. After
+// threshold consecutive failures it stops calling the store for the cooldown
+// and fails fast with ErrRevocationStoreUnavailable, so an outage costs
+// requests nothing but the availability policy's decision. After the
+// cooldown a single trial call decides whether the circuit closes again.
+type BreakerRevocationStore struct {
+	remote    RevocationStore
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	clock    Clock
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trial    bool // the half-open trial call is in flight
+
+	trips    atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// NewBreakerRevocationStore creates a circuit breaker around remote that
+// opens after threshold consecutive failures for cooldown
+func NewBreakerRevocationStore(remote RevocationStore, threshold int, cooldown time.Duration) *BreakerRevocationStore {
+	return &BreakerRevocationStore{
+		remote:    remote,
+		threshold: threshold,
+		cooldown:  cooldown,
+		clock:     SystemClock,
+	}
+}
+
+// SetClock replaces the clock the cooldown is measured with, e.g. with a
+// FakeClock in tests
+func (s *BreakerRevocationStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Revoke is passed through while the circuit is closed
+func (s *BreakerRevocationStore) Revoke(ctx context.Context, token RevokedToken) error {
+	return s.call(func() error {
+		return s.remote.Revoke(ctx, token)
+	})
+}
+
+// RevokeIfAbsent is passed through while the circuit is closed
+func (s *BreakerRevocationStore) RevokeIfAbsent(ctx context.Context, token RevokedToken) (revoked bool, err error) {
+	err = s.call(func() error {
+		revoked, err = s.remote.RevokeIfAbsent(ctx, token)
+		return err
+	})
+	return revoked, err
+}
+
+// IsRevoked is passed through while the circuit is closed
+func (s *BreakerRevocationStore) IsRevoked(ctx context.Context, tokenID string) (revoked bool, err error) {
+	err = s.call(func() error {
+		revoked, err = s.remote.IsRevoked(ctx, tokenID)
+		return err
+	})
+	return revoked, err
+}
+
+// AreRevoked is passed through while the circuit is closed
+func (s *BreakerRevocationStore) AreRevoked(ctx context.Context, tokenIDs []string) (revoked map[string]bool, err error) {
+	err = s.call(func() error {
+		revoked, err = s.remote.AreRevoked(ctx, tokenIDs)
+		return err
+	})
+	return revoked, err
+}
+
+// List is passed through while the circuit is closed
+func (s *BreakerRevocationStore) List(ctx context.Context) (tokenIDs []string, err error) {
+	err = s.call(func() error {
+		tokenIDs, err = s.remote.List(ctx)
+		return err
+	})
+	return tokenIDs, err
+}
+
+// Count is passed through while the circuit is closed
+func (s *BreakerRevocationStore) Count(ctx context.Context) (count int64, err error) {
+	err = s.call(func() error {
+		count, err = s.remote.Count(ctx)
+		return err
+	})
+	return count, err
+}
+
+// RevokeUser is passed through while the circuit is closed
+func (s *BreakerRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error {
+	return s.call(func() error {
+		return s.remote.RevokeUser(ctx, userID, before, ttl)
+	})
+}
+
+// UserRevokedBefore is passed through while the circuit is closed
+func (s *BreakerRevocationStore) UserRevokedBefore(ctx context.Context, userID int) (before time.Time, err error) {
+	err = s.call(func() error {
+		before, err = s.remote.UserRevokedBefore(ctx, userID)
+		return err
+	})
+	return before, err
+}
+
+// RevokedUsers is passed through while the circuit is closed
+func (s *BreakerRevocationStore) RevokedUsers(ctx context.Context) (userIDs []int, err error) {
+	err = s.call(func() error {
+		userIDs, err = s.remote.RevokedUsers(ctx)
+		return err
+	})
+	return userIDs, err
+}
+
+// Stats returns the breaker's current state and counters
+func (s *BreakerRevocationStore) Stats() BreakerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return BreakerStats{
+		State:               s.state.String(),
+		ConsecutiveFailures: s.failures,
+		Trips:               s.trips.Load(),
+		Rejected:            s.rejected.Load(),
+	}
+}
+
+// call runs a store operation if the circuit lets it through and records its outcome
+func (s *BreakerRevocationStore) call(operation func() error) error {
+	if !s.allow() {
+		s.rejected.Add(1)
+		return ErrRevocationStoreUnavailable
+	}
+
+	err := operation()
+	s.record(err)
+	return err
+}
+
+// allow reports whether a call may reach the store, moving an open circuit
+// whose cooldown has passed to half-open for a single trial call
+func (s *BreakerRevocationStore) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case breakerOpen:
+		if s.clock.Now().Sub(s.openedAt) < s.cooldown {
+			return false
+		}
+		s.state = breakerHalfOpen
+		s.trial = true
+		return true
+	case breakerHalfOpen:
+		if s.trial {
+			return false
+		}
+		s.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the circuit with the outcome of a call
+func (s *BreakerRevocationStore) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == breakerHalfOpen {
+		s.trial = false
+	}
+
+	switch {
+	case err == nil:
+		s.state = breakerClosed
+		s.failures = 0
+	case errors.Is(err, context.Canceled):
+		// The caller gave up, which says nothing about the store
+	default:
+		s.failures++
+		if s.state == breakerHalfOpen || (s.state == breakerClosed && s.failures >= s.threshold) {
+			s.state = breakerOpen
+			s.openedAt = s.clock.Now()
+			s.trips.Add(1)
+		}
+	}
+}
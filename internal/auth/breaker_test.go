@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakerRevocationStore(t *testing.T) {
+	ctx := context.Background()
+	remote := newStubRevocationStore()
+	store := NewBreakerRevocationStore(remote, 3, time.Minute)
+	clock := NewFakeClock(time.Now())
+	store.SetClock(clock)
+
+	storeErr := errors.New("connection refused")
+	remote.err = storeErr
+
+	// Failures below the threshold reach the store
+	for i := 0; i < 3; i++ {
+		_, err := store.IsRevoked(ctx, "token")
+		assert.ErrorIs(t, err, storeErr)
+	}
+	assert.Equal(t, "open", store.Stats().State)
+	assert.Equal(t, 3, remote.lookups)
+
+	// An open circuit fails fast without calling the store
+	_, err := store.AreRevoked(ctx, []string{"token"})
+	assert.ErrorIs(t, err, ErrRevocationStoreUnavailable)
+	assert.Equal(t, 3, remote.lookups)
+
+	// After the cooldown a failed trial call reopens the circuit at once
+	clock.Advance(time.Minute)
+	_, err = store.IsRevoked(ctx, "token")
+	assert.ErrorIs(t, err, storeErr)
+	assert.Equal(t, "open", store.Stats().State)
+
+	// A successful trial call closes it again
+	clock.Advance(time.Minute)
+	remote.err = nil
+	_, err = store.IsRevoked(ctx, "token")
+	require.NoError(t, err)
+
+	stats := store.Stats()
+	assert.Equal(t, "closed", stats.State)
+	assert.Equal(t, 0, stats.ConsecutiveFailures)
+	assert.Equal(t, uint64(2), stats.Trips)
+	assert.Equal(t, uint64(1), stats.Rejected)
+}
+
+func TestBreakerIgnoresCanceledCalls(t *testing.T) {
+	remote := newStubRevocationStore()
+	remote.hang = true
+	store := NewBreakerRevocationStore(remote, 1, time.Minute)
+
+	// Callers giving up say nothing about the store's health
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := store.AreRevoked(ctx, []string{"token"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "closed", store.Stats().State)
+}
@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// cacheGenerationStripes is the number of removal generation counters an
+// lruCache spreads its keys over
+const cacheGenerationStripes = 256
+
+// CachedRevocationStore is a two-tier RevocationStore: an in-process LRU cache
+// of both positive and negative lookups in front of a remote store. Cached
+// answers are trusted for at most the staleness window, and revocations on
+// other instances evict cached entries through a RevocationInvalidator.
+type CachedRevocationStore struct {
+	remote      RevocationStore
+	cache       *lruCache[bool]
+	epochs      *lruCache[time.Time]
+	staleness   time.Duration
+	invalidator RevocationInvalidator
+	cancel      context.CancelFunc
+}
+
+// NewCachedRevocationStore creates a cached store in front of remote.
+// The invalidator is optional; without it other instances only observe a
+// revocation once their cached answer becomes older than staleness.
+func NewCachedRevocationStore(remote RevocationStore, size int, staleness time.Duration, invalidator RevocationInvalidator) *CachedRevocationStore {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &CachedRevocationStore{
+		remote:      remote,
+		cache:       newLRUCache[bool](size),
+		epochs:      newLRUCache[time.Time](size),
+		staleness:   staleness,
+		invalidator: invalidator,
+		cancel:      cancel,
+	}
+
+	if invalidator != nil {
+		lost := func(err error) {
+			if ctx.Err() == nil {
+				log.Printf("Warning: revocation cache invalidation stopped, resubscribing: %v", err)
+			}
+		}
+		go keepSubscribed(ctx, invalidator, s.Invalidate, nil, lost)
+	}
+
+	return s
+}
+
+// SetClock replaces the clock cached answers age by, e.g. with a FakeClock in tests
+func (s *CachedRevocationStore) SetClock(clock Clock) {
+	s.cache.setClock(clock)
+	s.epochs.setClock(clock)
+}
+
+// Revoke writes through to the remote store and announces the revocation
+func (s *CachedRevocationStore) Revoke(ctx context.Context, token RevokedToken) error {
+	if err := s.remote.Revoke(ctx, token); err != nil {
+		return err
+	}
+
+	// The revocation is authoritative for its whole TTL
+	s.cache.set(token.TokenID, true, token.TTL)
+
+	if s.invalidator != nil {
+		if err := s.invalidator.Publish(ctx, tokenInvalidationKey(token.TokenID)); err != nil {
+			log.Printf("Warning: failed to publish revocation of %s: %v", token.TokenID, err)
+		}
+	}
+
+	return nil
+}
+
+// RevokeIfAbsent writes through to the remote store, which decides whether
+// the token ID was already revoked, and announces a new revocation
+func (s *CachedRevocationStore) RevokeIfAbsent(ctx context.Context, token RevokedToken) (bool, error) {
+	revoked, err := s.remote.RevokeIfAbsent(ctx, token)
+	if err != nil || !revoked {
+		return revoked, err
+	}
+
+	s.cache.set(token.TokenID, true, token.TTL)
+
+	if s.invalidator != nil {
+		if err := s.invalidator.Publish(ctx, tokenInvalidationKey(token.TokenID)); err != nil {
+			log.Printf("Warning: failed to publish revocation of %s: %v", token.TokenID, err)
+		}
+	}
+
+	return true, nil
+}
+
+// announcesRevocations reports whether the store publishes its revocations itself
+func (s *CachedRevocationStore) announcesRevocations() bool {
+	return s.invalidator != nil
+}
+
+// IsRevoked answers from the cache when possible and falls back to the remote store
+func (s *CachedRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	if revoked, ok := s.cache.get(tokenID); ok {
+		return revoked, nil
+	}
+
+	// An invalidation arriving during the read may postdate the answer
+	gen := s.cache.generation(tokenID)
+	revoked, err := s.remote.IsRevoked(ctx, tokenID)
+	if err != nil {
+		return false, err
+	}
+
+	s.cache.setIfUnchanged(tokenID, revoked, s.staleness, gen)
+	return revoked, nil
+}
+
+// AreRevoked only asks the remote store about token IDs missing from the cache
+func (s *CachedRevocationStore) AreRevoked(ctx context.Context, tokenIDs []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(tokenIDs))
+
+	var misses []string
+	for _, tokenID := range tokenIDs {
+		if revoked, ok := s.cache.get(tokenID); ok {
+			result[tokenID] = revoked
+		} else {
+			misses = append(misses, tokenID)
+		}
+	}
+
+	if len(misses) == 0 {
+		return result, nil
+	}
+
+	gens := make([]uint64, len(misses))
+	for i, tokenID := range misses {
+		gens[i] = s.cache.generation(tokenID)
+	}
+
+	remoteResult, err := s.remote.AreRevoked(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, tokenID := range misses {
+		revoked := remoteResult[tokenID]
+		s.cache.setIfUnchanged(tokenID, revoked, s.staleness, gens[i])
+		result[tokenID] = revoked
+	}
+
+	return result, nil
+}
+
+// List is always answered by the remote store
+func (s *CachedRevocationStore) List(ctx context.Context) ([]string, error) {
+	return s.remote.List(ctx)
+}
+
+// Count is always answered by the remote store
+func (s *CachedRevocationStore) Count(ctx context.Context) (int64, error) {
+	return s.remote.Count(ctx)
+}
+
+// RevokeUser writes through to the remote store and announces the new epoch
+func (s *CachedRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error {
+	if err := s.remote.RevokeUser(ctx, userID, before, ttl); err != nil {
+		return err
+	}
+
+	key := userInvalidationKey(userID)
+	s.epochs.set(key, before, s.staleness)
+
+	if s.invalidator != nil {
+		if err := s.invalidator.Publish(ctx, key); err != nil {
+			log.Printf("Warning: failed to publish revocation epoch of user %d: %v", userID, err)
+		}
+	}
+
+	return nil
+}
+
+// UserRevokedBefore answers from the cache when possible and falls back to the remote store
+func (s *CachedRevocationStore) UserRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	key := userInvalidationKey(userID)
+	if before, ok := s.epochs.get(key); ok {
+		return before, nil
+	}
+
+	gen := s.epochs.generation(key)
+	before, err := s.remote.UserRevokedBefore(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.epochs.setIfUnchanged(key, before, s.staleness, gen)
+	return before, nil
+}
+
+// RevokedUsers is always answered by the remote store
+func (s *CachedRevocationStore) RevokedUsers(ctx context.Context) ([]int, error) {
+	return s.remote.RevokedUsers(ctx)
+}
+
+// LastKnownRevoked replays cached answers regardless of their age, for use
+// while the remote store is unavailable
+func (s *CachedRevocationStore) LastKnownRevoked(tokenIDs []string) (map[string]bool, bool) {
+	result := make(map[string]bool, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		revoked, ok := s.cache.peek(tokenID)
+		if !ok {
+			return nil, false
+		}
+		result[tokenID] = revoked
+	}
+
+	return result, true
+}
+
+// LastKnownUserRevokedBefore replays the cached epoch of the user regardless of its age
+func (s *CachedRevocationStore) LastKnownUserRevokedBefore(userID int) (time.Time, bool) {
+	return s.epochs.peek(userInvalidationKey(userID))
+}
+
+// Invalidate drops any cached answer for a token or user epoch invalidation
+// message. Messages of neither type are ignored.
+func (s *CachedRevocationStore) Invalidate(key string) {
+	if tokenID, ok := parseTokenInvalidationKey(key); ok {
+		s.cache.remove(tokenID)
+		return
+	}
+
+	if _, ok := parseUserInvalidationKey(key); ok {
+		s.epochs.remove(key)
+	}
+}
+
+// Close stops listening for invalidations
+func (s *CachedRevocationStore) Close() {
+	s.cancel()
+}
+
+// lruCache is a size-bounded LRU cache whose entries also expire after a TTL.
+// Removing a key moves it to a new generation, so a value loaded while it was
+// being removed can be kept out with setIfUnchanged.
+type lruCache[V any] struct {
+	mu          sync.Mutex
+	capacity    int
+	order       *list.List // front is most recently used
+	items       map[string]*list.Element
+	clock       Clock
+	generations [cacheGenerationStripes]uint64 // bumped by remove, shared by keys hashing alike
+}
+
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+func newLRUCache[V any](capacity int) *lruCache[V] {
+	return &lruCache[V]{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		clock:    SystemClock,
+	}
+}
+
+// setClock replaces the clock entries expire by
+func (c *lruCache[V]) setClock(clock Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clock
+}
+
+// get returns the cached value and whether it was present and fresh
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	// Expired entries stay until evicted, so peek can still replay them
+	entry := elem.Value.(*lruEntry[V])
+	if !c.clock.Now().Before(entry.expiresAt) {
+		return zero, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// peek returns the cached value however old it is, without touching its recency
+func (c *lruCache[V]) peek(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	return elem.Value.(*lruEntry[V]).value, true
+}
+
+// set stores a value for ttl, evicting the least recently used entry when full
+func (c *lruCache[V]) set(key string, value V, ttl time.Duration) {
+	if ttl <= 0 || c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(key, value, ttl)
+}
+
+// store stores a value for ttl, with c.mu held
+func (c *lruCache[V]) store(key string, value V, ttl time.Duration) {
+	expiresAt := c.clock.Now().Add(ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry[V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[V]).key)
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: expiresAt})
+}
+
+// generation returns the removal generation of key, to be passed to
+// setIfUnchanged once its value has been loaded
+func (c *lruCache[V]) generation(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generations[generationStripe(key)]
+}
+
+// setIfUnchanged stores a value like set, unless key was removed since its
+// generation was taken
+func (c *lruCache[V]) setIfUnchanged(key string, value V, ttl time.Duration, generation uint64) {
+	if ttl <= 0 || c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[generationStripe(key)] == generation {
+		c.store(key, value, ttl)
+	}
+}
+
+// remove drops the entry for key if present
+func (c *lruCache[V]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generations[generationStripe(key)]++
+	if elem, ok := c.items[key]; ok {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+// generationStripe returns the index of the generation counter of a cache key
+func generationStripe(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % cacheGenerationStripes)
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// localInvalidator fans revocations out to in-process subscribers, standing in for Redis pub/sub
+type localInvalidator struct {
+	mu        sync.Mutex
+	handlers  []func(tokenID string)
+	published []string
+}
+
+func (i *localInvalidator) Publish(ctx context.Context, tokenID string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.published = append(i.published, tokenID)
+	for _, handler := range i.handlers {
+		handler(tokenID)
+	}
+	return nil
+}
+
+func (i *localInvalidator) Subscribe(ctx context.Context, handler func(tokenID string), ready func()) error {
+	i.mu.Lock()
+	i.handlers = append(i.handlers, handler)
+	i.mu.Unlock()
+	if ready != nil {
+		ready()
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (i *localInvalidator) subscribers() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.handlers)
+}
+
+// flakyInvalidator fails its first subscription, like Redis being down at startup
+type flakyInvalidator struct {
+	localInvalidator
+	failed atomic.Bool
+}
+
+func (i *flakyInvalidator) Subscribe(ctx context.Context, handler func(tokenID string), ready func()) error {
+	if !i.failed.Swap(true) {
+		return errors.New("connection refused")
+	}
+	return i.localInvalidator.Subscribe(ctx, handler, ready)
+}
+
+// racingRevocationStore runs during after answering a lookup but before the
+// answer reaches the caller
+type racingRevocationStore struct {
+	*stubRevocationStore
+	during func()
+}
+
+func (s *racingRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	revoked, err := s.stubRevocationStore.IsRevoked(ctx, tokenID)
+	if s.during != nil {
+		s.during()
+	}
+	return revoked, err
+}
+
+func TestCachedRevocationStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("CachesNegativeAndPositiveLookups", func(t *testing.T) {
+		remote := newStubRevocationStore()
+		remote.revoked["revoked"] = true
+		store := NewCachedRevocationStore(remote, 10, time.Minute, nil)
+		defer store.Close()
+
+		for i := 0; i < 3; i++ {
+			revoked, err := store.IsRevoked(ctx, "active")
+			require.NoError(t, err)
+			assert.False(t, revoked)
+
+			revoked, err = store.IsRevoked(ctx, "revoked")
+			require.NoError(t, err)
+			assert.True(t, revoked)
+		}
+		assert.Equal(t, 2, remote.lookups)
+
+		// Bulk lookups only ask the remote about misses
+		statuses, err := store.AreRevoked(ctx, []string{"active", "revoked", "unknown"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"active": false, "revoked": true, "unknown": false}, statuses)
+		assert.Equal(t, 3, remote.lookups)
+	})
+
+	t.Run("StalenessWindow", func(t *testing.T) {
+		remote := newStubRevocationStore()
+		store := NewCachedRevocationStore(remote, 10, time.Minute, nil)
+		defer store.Close()
+		clock := NewFakeClock(time.Now())
+		store.SetClock(clock)
+
+		revoked, err := store.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		// Revoked behind the cache's back
+		remote.revoked["jti"] = true
+
+		revoked, err = store.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.False(t, revoked, "cached answer is served within the staleness window")
+
+		clock.Advance(time.Minute)
+
+		revoked, err = store.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.True(t, revoked, "stale answer must be refreshed from the remote store")
+	})
+
+	t.Run("LeastRecentlyUsedEviction", func(t *testing.T) {
+		remote := newStubRevocationStore()
+		store := NewCachedRevocationStore(remote, 2, time.Minute, nil)
+		defer store.Close()
+
+		for _, tokenID := range []string{"a", "b", "a", "c"} {
+			_, err := store.IsRevoked(ctx, tokenID)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 3, remote.lookups)
+
+		// "b" was the least recently used entry and has been evicted
+		_, err := store.IsRevoked(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, 3, remote.lookups)
+		_, err = store.IsRevoked(ctx, "b")
+		require.NoError(t, err)
+		assert.Equal(t, 4, remote.lookups)
+	})
+
+	t.Run("InvalidationAcrossInstances", func(t *testing.T) {
+		remote := newStubRevocationStore()
+		invalidator := &localInvalidator{}
+
+		instanceA := NewCachedRevocationStore(remote, 10, time.Hour, invalidator)
+		defer instanceA.Close()
+		instanceB := NewCachedRevocationStore(remote, 10, time.Hour, invalidator)
+		defer instanceB.Close()
+
+		require.Eventually(t, func() bool { return invalidator.subscribers() == 2 }, time.Second, time.Millisecond)
+
+		// Instance B caches a "not revoked" answer
+		revoked, err := instanceB.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		// Instance A revokes the token, which must evict B's cached answer
+		require.NoError(t, instanceA.Revoke(ctx, RevokedToken{TokenID: "jti", TTL: time.Minute}))
+
+		revoked, err = instanceB.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("UserEpochInvalidation", func(t *testing.T) {
+		remote := newStubRevocationStore()
+		invalidator := &localInvalidator{}
+
+		instanceA := NewCachedRevocationStore(remote, 10, time.Hour, invalidator)
+		defer instanceA.Close()
+		instanceB := NewCachedRevocationStore(remote, 10, time.Hour, invalidator)
+		defer instanceB.Close()
+
+		require.Eventually(t, func() bool { return invalidator.subscribers() == 2 }, time.Second, time.Millisecond)
+
+		// Instance B caches "no epoch" for the user
+		before, err := instanceB.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, before.IsZero())
+
+		epoch := time.Now().Truncate(time.Second)
+		require.NoError(t, instanceA.RevokeUser(ctx, 1, epoch, time.Hour))
+
+		before, err = instanceB.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, epoch.Equal(before))
+	})
+
+	t.Run("ResubscribesAfterFailure", func(t *testing.T) {
+		remote := newStubRevocationStore()
+		invalidator := &flakyInvalidator{}
+
+		store := NewCachedRevocationStore(remote, 10, time.Hour, invalidator)
+		defer store.Close()
+
+		require.Eventually(t, func() bool { return invalidator.subscribers() == 1 }, 3*time.Second, 10*time.Millisecond)
+
+		revoked, err := store.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		remote.revoked["jti"] = true
+		require.NoError(t, invalidator.Publish(ctx, tokenInvalidationKey("jti")))
+
+		revoked, err = store.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("InvalidationDuringReadIsNotCached", func(t *testing.T) {
+		remote := &racingRevocationStore{stubRevocationStore: newStubRevocationStore()}
+		store := NewCachedRevocationStore(remote, 10, time.Hour, nil)
+		defer store.Close()
+
+		// Another instance revokes the token after the remote store answered
+		remote.during = func() {
+			remote.during = nil
+			remote.revoked["jti"] = true
+			store.Invalidate(tokenInvalidationKey("jti"))
+		}
+
+		revoked, err := store.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		revoked, err = store.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.True(t, revoked, "the answer predating the invalidation must not be cached")
+	})
+
+	t.Run("TokenIDsLookingLikeUserKeys", func(t *testing.T) {
+		remote := newStubRevocationStore()
+		store := NewCachedRevocationStore(remote, 10, time.Hour, nil)
+		defer store.Close()
+
+		// A token ID with a "user:" prefix is invalidated as a token
+		revoked, err := store.IsRevoked(ctx, "user:5")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		remote.revoked["user:5"] = true
+		store.Invalidate(tokenInvalidationKey("user:5"))
+
+		revoked, err = store.IsRevoked(ctx, "user:5")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+}
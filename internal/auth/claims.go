@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+)
+
+// ErrReservedClaim is returned when a claims enricher tries to set a claim
+// that the JWTManager manages itself
+var ErrReservedClaim = errors.New("reserved claim")
+
+// ClaimsEnricher returns extra claims to serialize into the tokens issued to
+// a user, such as a tenant ID or feature flags
+type ClaimsEnricher func(ctx context.Context, user *models.User) (map[string]interface{}, error)
+
+// reservedClaims are the claim names of JWTClaims' own fields, which custom
+// claims may never overwrite
+var reservedClaims = func() map[string]bool {
+	names := make(map[string]bool)
+	collectClaimNames(reflect.TypeOf(JWTClaims{}), names)
+	return names
+}()
+
+// collectClaimNames adds the JSON names of the struct's fields, including embedded ones
+func collectClaimNames(t reflect.Type, names map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			collectClaimNames(field.Type, names)
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+}
+
+// IsReservedClaim reports whether the claim name is managed by the JWTManager
+func IsReservedClaim(name string) bool {
+	return reservedClaims[name]
+}
+
+// jwtClaimsFields has the fields of JWTClaims without its JSON methods
+type jwtClaimsFields JWTClaims
+
+// MarshalJSON serializes the custom claims next to the standard ones
+func (c JWTClaims) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(jwtClaimsFields(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	for name := range c.Extra {
+		if IsReservedClaim(name) {
+			return nil, fmt.Errorf("%w: %q", ErrReservedClaim, name)
+		}
+	}
+
+	extra, err := json.Marshal(c.Extra)
+	if err != nil {
+		return nil, err
+	}
+
+	// Splice the two objects together: {"user_id":1,...} + {"tenant":"acme"}
+	data = bytes.TrimSuffix(data, []byte("}"))
+	if len(data) > 1 {
+		data = append(data, ',')
+	}
+	return append(data, extra[1:]...), nil
+}
+
+// UnmarshalJSON reads the standard claims into their fields and keeps every
+// other claim in Extra
+func (c *JWTClaims) UnmarshalJSON(data []byte) error {
+	var fields jwtClaimsFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for name := range all {
+		if IsReservedClaim(name) {
+			delete(all, name)
+		}
+	}
+
+	fields.Extra = nil
+	if len(all) > 0 {
+		fields.Extra = all
+	}
+
+	*c = JWTClaims(fields)
+	return nil
+}
+
+// Claim returns the custom claim with the given name
+func (c *JWTClaims) Claim(name string) (interface{}, bool) {
+	value, ok := c.Extra[name]
+	return value, ok
+}
+
+// StringClaim returns the custom claim with the given name if it is a string
+func (c *JWTClaims) StringClaim(name string) (string, bool) {
+	value, ok := c.Extra[name].(string)
+	return value, ok
+}
+
+// BoolClaim returns the custom claim with the given name if it is a boolean
+func (c *JWTClaims) BoolClaim(name string) (bool, bool) {
+	value, ok := c.Extra[name].(bool)
+	return value, ok
+}
+
+// IntClaim returns the custom claim with the given name if it is a whole number
+func (c *JWTClaims) IntClaim(name string) (int, bool) {
+	switch value := c.Extra[name].(type) {
+	case int:
+		return value, true
+	case int64:
+		return int(value), true
+	case float64:
+		// JSON numbers are decoded as float64, which holds integers exactly up to 2^53
+		if value == math.Trunc(value) && math.Abs(value) <= 1<<53 {
+			return int(value), true
+		}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return int(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// StringSliceClaim returns the custom claim with the given name if it is a list of strings
+func (c *JWTClaims) StringSliceClaim(name string) ([]string, bool) {
+	switch value := c.Extra[name].(type) {
+	case []string:
+		return value, true
+	case []interface{}:
+		// JSON arrays are decoded as []interface{}
+		strs := make([]string, 0, len(value))
+		for _, item := range value {
+			str, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			strs = append(strs, str)
+		}
+		return strs, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tenantKey struct{}
+
+func TestClaimsEnricher(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+
+	jwtManager.SetClaimsEnricher(func(ctx context.Context, user *models.User) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"tenant_id":  ctx.Value(tenantKey{}),
+			"department": "finance",
+			"features":   []string{"reports", "exports"},
+			"beta":       true,
+		}, nil
+	})
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, 7)
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	_, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+
+	// Refreshed tokens carry the custom claims of their refresh token
+	accessToken, _, err := jwtManager.RefreshToken(ctx, refreshToken)
+	require.NoError(t, err)
+
+	claims, err := jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+
+	tenantID, ok := claims.IntClaim("tenant_id")
+	assert.True(t, ok)
+	assert.Equal(t, 7, tenantID)
+
+	department, ok := claims.StringClaim("department")
+	assert.True(t, ok)
+	assert.Equal(t, "finance", department)
+
+	features, ok := claims.StringSliceClaim("features")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"reports", "exports"}, features)
+
+	beta, ok := claims.BoolClaim("beta")
+	assert.True(t, ok)
+	assert.True(t, beta)
+
+	// Accessors don't convert between types
+	_, ok = claims.IntClaim("department")
+	assert.False(t, ok)
+	_, ok = claims.StringClaim("missing")
+	assert.False(t, ok)
+
+	// Standard claims are untouched and never show up as custom claims
+	assert.Equal(t, user.Username, claims.Username)
+	assert.Len(t, claims.Extra, 4)
+}
+
+func TestClaimsEnricherCannotOverwriteReservedClaims(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	for _, name := range []string{"role", "user_id", "jti", "sub", "exp", "iss"} {
+		jwtManager.SetClaimsEnricher(func(ctx context.Context, user *models.User) (map[string]interface{}, error) {
+			return map[string]interface{}{name: "admin"}, nil
+		})
+
+		_, _, err := jwtManager.GenerateTokens(ctx, user)
+		assert.ErrorIs(t, err, ErrReservedClaim, name)
+	}
+
+	// Enricher failures abort the login
+	enricherErr := errors.New("directory unavailable")
+	jwtManager.SetClaimsEnricher(func(ctx context.Context, user *models.User) (map[string]interface{}, error) {
+		return nil, enricherErr
+	})
+	_, _, err = jwtManager.GenerateTokens(ctx, user)
+	assert.ErrorIs(t, err, enricherErr)
+}
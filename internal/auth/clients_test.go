@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRegistryAuthenticate(t *testing.T) {
+	clients := NewClientRegistry(map[string]string{
+		"gateway": "gateway-secret",
+		"billing": "billing-secret",
+	})
+
+	assert.Equal(t, 2, clients.Len())
+	assert.True(t, clients.Authenticate("gateway", "gateway-secret"))
+	assert.True(t, clients.Authenticate("billing", "billing-secret"))
+
+	// Secrets are bound to their own client
+	assert.False(t, clients.Authenticate("gateway", "billing-secret"))
+	assert.False(t, clients.Authenticate("gateway", "gateway-secret-and-more"))
+	assert.False(t, clients.Authenticate("gateway", ""))
+	assert.False(t, clients.Authenticate("unknown", "gateway-secret"))
+	assert.False(t, clients.Authenticate("", ""))
+}
@@ -0,0 +1,466 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FilterStats describes the state of a FilteredRevocationStore's Bloom filter
+type FilterStats struct {
+	Ready                      bool      `json:"ready"`
+	Subscribed                 bool      `json:"subscribed"`
+	Entries                    uint64    `json:"entries"`
+	SizeBits                   uint64    `json:"size_bits"`
+	SizeBytes                  int       `json:"size_bytes"`
+	HashFunctions              uint64    `json:"hash_functions"`
+	EstimatedFalsePositiveRate float64   `json:"estimated_false_positive_rate"`
+	ObservedFalsePositiveRate  float64   `json:"observed_false_positive_rate"`
+	Lookups                    uint64    `json:"lookups"`
+	StoreLookups               uint64    `json:"store_lookups"`
+	LastRebuild                time.Time `json:"last_rebuild"`
+}
+
+// FilteredRevocationStore keeps a Bloom filter of revoked token IDs and of
+// users with a revocation epoch in front of a backing store. Since almost
+// every token checked is not revoked, most lookups are answered locally and
+// only possible matches reach the store.
+//
+// The filter is built from the store's List and RevokedUsers on startup and rebuilt on every
+// rotation so expired entries age out. Revocations made on other instances
+// are added through the RevocationInvalidator. While its subscription is
+// down every lookup goes to the backing store, and resubscribing rebuilds the
+// filter to catch up on the announcements missed meanwhile. Without an
+// invalidator the filter only learns of revocations made through it, so the
+// backing store must not be shared with other instances.
+type FilteredRevocationStore struct {
+	backing     RevocationStore
+	invalidator RevocationInvalidator
+	publish     bool // false when the backing store already announces revocations
+	capacity    int
+	fpRate      float64
+	cancel      context.CancelFunc
+	live        atomic.Bool // subscribed to announcements from other instances
+	stale       atomic.Bool // announcements were missed since the last rebuild
+	rebuildMu   sync.Mutex  // serializes rotations and catch-up rebuilds
+
+	mu          sync.RWMutex
+	clock       Clock
+	filter      *bloomFilter // nil until the first build completes
+	rebuilding  bool
+	pending     []string // token IDs revoked while a rebuild is in flight
+	lastRebuild time.Time
+
+	lookups        atomic.Uint64
+	storeLookups   atomic.Uint64
+	falsePositives atomic.Uint64
+}
+
+// NewFilteredRevocationStore creates a filtered store sized for capacity
+// entries at the given false-positive rate, rebuilt every rotation interval.
+// Until the initial build finishes every lookup goes to the backing store.
+func NewFilteredRevocationStore(backing RevocationStore, capacity int, fpRate float64, rotation time.Duration, invalidator RevocationInvalidator) *FilteredRevocationStore {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &FilteredRevocationStore{
+		backing:     backing,
+		invalidator: invalidator,
+		publish:     invalidator != nil && !announcesRevocations(backing),
+		capacity:    capacity,
+		fpRate:      fpRate,
+		cancel:      cancel,
+		clock:       SystemClock,
+	}
+
+	if invalidator != nil {
+		s.stale.Store(true)
+		go s.subscribe(ctx)
+	}
+
+	go s.rotate(ctx, rotation)
+
+	return s
+}
+
+// SetClock replaces the clock rebuilds are stamped with, e.g. with a FakeClock in tests
+func (s *FilteredRevocationStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Revoke writes to the backing store and records the token ID in the filter
+func (s *FilteredRevocationStore) Revoke(ctx context.Context, token RevokedToken) error {
+	if err := s.backing.Revoke(ctx, token); err != nil {
+		return err
+	}
+
+	key := tokenInvalidationKey(token.TokenID)
+	s.add(key)
+
+	s.announce(ctx, key)
+
+	return nil
+}
+
+// RevokeIfAbsent writes to the backing store and records a new revocation in the filter
+func (s *FilteredRevocationStore) RevokeIfAbsent(ctx context.Context, token RevokedToken) (bool, error) {
+	revoked, err := s.backing.RevokeIfAbsent(ctx, token)
+	if err != nil || !revoked {
+		return revoked, err
+	}
+
+	key := tokenInvalidationKey(token.TokenID)
+	s.add(key)
+
+	s.announce(ctx, key)
+
+	return true, nil
+}
+
+// IsRevoked only consults the backing store when the filter reports a possible match
+func (s *FilteredRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	s.lookups.Add(1)
+	if !s.mayContain(tokenInvalidationKey(tokenID)) {
+		return false, nil
+	}
+
+	s.storeLookups.Add(1)
+	revoked, err := s.backing.IsRevoked(ctx, tokenID)
+	if err != nil {
+		return false, err
+	}
+	if !revoked {
+		s.falsePositives.Add(1)
+	}
+
+	return revoked, nil
+}
+
+// AreRevoked only sends possible matches to the backing store
+func (s *FilteredRevocationStore) AreRevoked(ctx context.Context, tokenIDs []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(tokenIDs))
+
+	var candidates []string
+	for _, tokenID := range tokenIDs {
+		s.lookups.Add(1)
+		if s.mayContain(tokenInvalidationKey(tokenID)) {
+			candidates = append(candidates, tokenID)
+		} else {
+			result[tokenID] = false
+		}
+	}
+
+	if len(candidates) == 0 {
+		return result, nil
+	}
+
+	s.storeLookups.Add(uint64(len(candidates)))
+	backingResult, err := s.backing.AreRevoked(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tokenID := range candidates {
+		revoked := backingResult[tokenID]
+		if !revoked {
+			s.falsePositives.Add(1)
+		}
+		result[tokenID] = revoked
+	}
+
+	return result, nil
+}
+
+// List is always answered by the backing store
+func (s *FilteredRevocationStore) List(ctx context.Context) ([]string, error) {
+	return s.backing.List(ctx)
+}
+
+// Count is always answered by the backing store
+func (s *FilteredRevocationStore) Count(ctx context.Context) (int64, error) {
+	return s.backing.Count(ctx)
+}
+
+// RevokeUser is passed through to the backing store and announced to other instances
+func (s *FilteredRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error {
+	if err := s.backing.RevokeUser(ctx, userID, before, ttl); err != nil {
+		return err
+	}
+
+	key := userInvalidationKey(userID)
+	s.add(key)
+
+	s.announce(ctx, key)
+
+	return nil
+}
+
+// UserRevokedBefore only consults the backing store for users the filter
+// reports as possibly holding an epoch
+func (s *FilteredRevocationStore) UserRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	s.lookups.Add(1)
+	if !s.mayContain(userInvalidationKey(userID)) {
+		return time.Time{}, nil
+	}
+
+	s.storeLookups.Add(1)
+	before, err := s.backing.UserRevokedBefore(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if before.IsZero() {
+		s.falsePositives.Add(1)
+	}
+
+	return before, nil
+}
+
+// RevokedUsers is always answered by the backing store
+func (s *FilteredRevocationStore) RevokedUsers(ctx context.Context) ([]int, error) {
+	return s.backing.RevokedUsers(ctx)
+}
+
+// LastKnownRevoked answers token IDs the filter has never seen as not
+// revoked and replays the backing store's last known answers for the rest
+func (s *FilteredRevocationStore) LastKnownRevoked(tokenIDs []string) (map[string]bool, bool) {
+	result := make(map[string]bool, len(tokenIDs))
+
+	var candidates []string
+	for _, tokenID := range tokenIDs {
+		if s.mayContain(tokenInvalidationKey(tokenID)) {
+			candidates = append(candidates, tokenID)
+		} else {
+			result[tokenID] = false
+		}
+	}
+
+	if len(candidates) == 0 {
+		return result, true
+	}
+
+	lastKnown, ok := s.backing.(LastKnownRevocations)
+	if !ok {
+		return nil, false
+	}
+
+	backingResult, ok := lastKnown.LastKnownRevoked(candidates)
+	if !ok {
+		return nil, false
+	}
+	for _, tokenID := range candidates {
+		result[tokenID] = backingResult[tokenID]
+	}
+
+	return result, true
+}
+
+// LastKnownUserRevokedBefore answers users the filter has never seen as
+// without an epoch and replays the backing store's last known epoch of the rest
+func (s *FilteredRevocationStore) LastKnownUserRevokedBefore(userID int) (time.Time, bool) {
+	if !s.mayContain(userInvalidationKey(userID)) {
+		return time.Time{}, true
+	}
+	if lastKnown, ok := s.backing.(LastKnownRevocations); ok {
+		return lastKnown.LastKnownUserRevokedBefore(userID)
+	}
+	return time.Time{}, false
+}
+
+// Rebuild replaces the filter with one built from the backing store's current entries
+func (s *FilteredRevocationStore) Rebuild(ctx context.Context) error {
+	s.rebuildMu.Lock()
+	defer s.rebuildMu.Unlock()
+
+	// Only a rebuild listing the store after subscribing catches up on missed announcements
+	live := s.live.Load()
+
+	s.mu.Lock()
+	s.rebuilding = true
+	s.pending = nil
+	s.mu.Unlock()
+
+	tokenIDs, err := s.backing.List(ctx)
+	var userIDs []int
+	if err == nil {
+		userIDs, err = s.backing.RevokedUsers(ctx)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.rebuilding = false
+		s.pending = nil
+		s.mu.Unlock()
+		return err
+	}
+	keys := make([]string, 0, len(tokenIDs)+len(userIDs))
+	for _, tokenID := range tokenIDs {
+		keys = append(keys, tokenInvalidationKey(tokenID))
+	}
+	for _, userID := range userIDs {
+		keys = append(keys, userInvalidationKey(userID))
+	}
+
+	// Leave headroom so the filter stays accurate until the next rotation
+	size := s.capacity
+	if 2*len(keys) > size {
+		size = 2 * len(keys)
+	}
+
+	filter := newBloomFilter(size, s.fpRate)
+	for _, key := range keys {
+		filter.add(key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Revocations that raced with List may be missing from it
+	for _, key := range s.pending {
+		filter.add(key)
+	}
+
+	s.filter = filter
+	s.rebuilding = false
+	s.pending = nil
+	s.lastRebuild = s.clock.Now()
+	if live && s.live.Load() {
+		s.stale.Store(false)
+	}
+
+	return nil
+}
+
+// Stats returns the filter's size and false-positive figures
+func (s *FilteredRevocationStore) Stats() FilterStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := FilterStats{
+		Lookups:      s.lookups.Load(),
+		StoreLookups: s.storeLookups.Load(),
+		LastRebuild:  s.lastRebuild,
+		Subscribed:   s.invalidator != nil && s.trusted(),
+	}
+
+	if s.filter != nil {
+		stats.Ready = true
+		stats.Entries = s.filter.entries
+		stats.SizeBits = s.filter.m
+		stats.SizeBytes = s.filter.sizeBytes()
+		stats.HashFunctions = s.filter.k
+		stats.EstimatedFalsePositiveRate = s.filter.estimatedFalsePositiveRate()
+	}
+
+	// Observed rate is the share of truly absent IDs that still reached the store
+	falsePositives := s.falsePositives.Load()
+	negatives := stats.Lookups - (stats.StoreLookups - falsePositives)
+	if negatives > 0 {
+		stats.ObservedFalsePositiveRate = float64(falsePositives) / float64(negatives)
+	}
+
+	return stats
+}
+
+// Close stops rotation and filter updates
+func (s *FilteredRevocationStore) Close() {
+	s.cancel()
+}
+
+// add records a token or user epoch invalidation key in the current filter
+// and any rebuild in flight
+func (s *FilteredRevocationStore) add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.filter != nil {
+		s.filter.add(key)
+	}
+	if s.rebuilding {
+		s.pending = append(s.pending, key)
+	}
+}
+
+// mayContain treats every key as a possible match until the filter is
+// built, and while it misses announcements from other instances
+func (s *FilteredRevocationStore) mayContain(key string) bool {
+	if !s.trusted() {
+		return true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filter == nil || s.filter.mayContain(key)
+}
+
+// announcesRevocations reports whether store publishes its revocations itself
+func (s *FilteredRevocationStore) announcesRevocations() bool {
+	return s.invalidator != nil
+}
+
+// announce publishes a revocation to other instances, unless the backing
+// store already did
+func (s *FilteredRevocationStore) announce(ctx context.Context, key string) {
+	if !s.publish {
+		return
+	}
+	if err := s.invalidator.Publish(ctx, key); err != nil {
+		log.Printf("Warning: failed to publish revocation of %s: %v", key, err)
+	}
+}
+
+// subscribe keeps the filter subscribed to revocations announced by other
+// instances, resubscribing after a failure
+func (s *FilteredRevocationStore) subscribe(ctx context.Context) {
+	ready := func() {
+		// Announcements made while not subscribed are only in the store
+		s.stale.Store(true)
+		s.live.Store(true)
+		if err := s.Rebuild(ctx); err != nil {
+			log.Printf("Warning: failed to rebuild revocation filter after subscribing: %v", err)
+		}
+	}
+
+	lost := func(err error) {
+		s.live.Store(false)
+		s.stale.Store(true)
+		if ctx.Err() == nil {
+			log.Printf("Warning: revocation filter updates stopped, checking every token with the store: %v", err)
+		}
+	}
+
+	keepSubscribed(ctx, s.invalidator, s.add, ready, lost)
+}
+
+// trusted reports whether the filter knows of every revocation made on other
+// instances, so its misses can be believed
+func (s *FilteredRevocationStore) trusted() bool {
+	return s.invalidator == nil || (s.live.Load() && !s.stale.Load())
+}
+
+// rotate builds the filter immediately and then once per interval
+func (s *FilteredRevocationStore) rotate(ctx context.Context, interval time.Duration) {
+	if err := s.Rebuild(ctx); err != nil {
+		log.Printf("Warning: failed to build revocation filter: %v", err)
+	}
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Rebuild(ctx); err != nil {
+				log.Printf("Warning: failed to rotate revocation filter: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
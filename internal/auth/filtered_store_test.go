@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		filter.add(fmt.Sprintf("revoked-%d", i))
+	}
+
+	// No false negatives
+	for i := 0; i < 1000; i++ {
+		assert.True(t, filter.mayContain(fmt.Sprintf("revoked-%d", i)))
+	}
+
+	// False positives stay close to the target rate
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.mayContain(fmt.Sprintf("active-%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, float64(falsePositives)/10000, 0.03)
+	assert.InDelta(t, 0.01, filter.estimatedFalsePositiveRate(), 0.005)
+}
+
+func TestFilteredRevocationStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("SkipsStoreForUnknownTokens", func(t *testing.T) {
+		backing := newStubRevocationStore()
+		backing.revoked["existing"] = true
+
+		store := NewFilteredRevocationStore(backing, 100, 0.01, 0, nil)
+		defer store.Close()
+		require.NoError(t, store.Rebuild(ctx))
+
+		lookups := backing.lookups
+		revoked, err := store.IsRevoked(ctx, "never-revoked")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+		assert.Equal(t, lookups, backing.lookups, "filter miss must not reach the store")
+
+		// Entries loaded from the store at build time are still checked
+		revoked, err = store.IsRevoked(ctx, "existing")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		// New revocations are added to the filter
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "new", TTL: time.Minute}))
+		statuses, err := store.AreRevoked(ctx, []string{"new", "never-revoked"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"new": true, "never-revoked": false}, statuses)
+
+		stats := store.Stats()
+		assert.True(t, stats.Ready)
+		assert.Equal(t, uint64(2), stats.Entries)
+		assert.Positive(t, stats.SizeBytes)
+		assert.Equal(t, uint64(4), stats.Lookups)
+		assert.Equal(t, uint64(2), stats.StoreLookups)
+	})
+
+	t.Run("SkipsStoreForUsersWithoutEpoch", func(t *testing.T) {
+		backing := newStubRevocationStore()
+		epoch := time.Now()
+		backing.epochs[1] = epoch
+
+		store := NewFilteredRevocationStore(backing, 100, 0.01, 0, nil)
+		defer store.Close()
+		require.NoError(t, store.Rebuild(ctx))
+
+		before, err := store.UserRevokedBefore(ctx, 2)
+		require.NoError(t, err)
+		assert.True(t, before.IsZero())
+		assert.Zero(t, backing.epochLookups, "filter miss must not reach the store")
+
+		lastKnown, ok := store.LastKnownUserRevokedBefore(2)
+		assert.True(t, ok, "a filter miss is known even without a cache")
+		assert.True(t, lastKnown.IsZero())
+
+		// Epochs loaded from the store at build time are still checked
+		before, err = store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, epoch, before)
+
+		// New epochs are added to the filter
+		require.NoError(t, store.RevokeUser(ctx, 3, epoch, time.Minute))
+		before, err = store.UserRevokedBefore(ctx, 3)
+		require.NoError(t, err)
+		assert.Equal(t, epoch, before)
+		assert.Equal(t, 2, backing.epochLookups)
+	})
+
+	t.Run("RotationAgesOutExpiredEntries", func(t *testing.T) {
+		backing := newTestRevocationStore(t)
+		clock := NewFakeClock(time.Now())
+		backing.SetClock(clock)
+		require.NoError(t, backing.Revoke(ctx, RevokedToken{TokenID: "short", TTL: time.Minute}))
+
+		store := NewFilteredRevocationStore(backing, 100, 0.01, 0, nil)
+		defer store.Close()
+		require.NoError(t, store.Rebuild(ctx))
+		assert.Equal(t, uint64(1), store.Stats().Entries)
+
+		clock.Advance(time.Minute)
+		require.NoError(t, store.Rebuild(ctx))
+		assert.Equal(t, uint64(0), store.Stats().Entries)
+	})
+
+	t.Run("LearnsRevocationsFromOtherInstances", func(t *testing.T) {
+		backing := newTestRevocationStore(t)
+		invalidator := &localInvalidator{}
+
+		instanceA := NewFilteredRevocationStore(backing, 100, 0.01, 0, invalidator)
+		defer instanceA.Close()
+		instanceB := NewFilteredRevocationStore(backing, 100, 0.01, 0, invalidator)
+		defer instanceB.Close()
+
+		require.Eventually(t, func() bool {
+			return invalidator.subscribers() == 2 && instanceA.Stats().Ready && instanceB.Stats().Ready
+		}, time.Second, time.Millisecond)
+
+		require.NoError(t, instanceA.Revoke(ctx, RevokedToken{TokenID: "jti", TTL: time.Minute}))
+
+		revoked, err := instanceB.IsRevoked(ctx, "jti")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("ChecksTheStoreUntilSubscribed", func(t *testing.T) {
+		backing := newTestRevocationStore(t)
+		invalidator := &gatedInvalidator{open: make(chan struct{})}
+
+		store := NewFilteredRevocationStore(backing, 100, 0.01, 0, invalidator)
+		defer store.Close()
+		require.Eventually(t, func() bool { return store.Stats().Ready }, time.Second, time.Millisecond)
+
+		// Another instance revokes a token while this one can't hear about it
+		require.NoError(t, backing.Revoke(ctx, RevokedToken{TokenID: "missed", TTL: time.Minute}))
+
+		revoked, err := store.IsRevoked(ctx, "missed")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+		assert.False(t, store.Stats().Subscribed)
+		assert.Equal(t, uint64(1), store.Stats().StoreLookups)
+
+		// Subscribing catches up on the store before the filter is trusted
+		close(invalidator.open)
+		require.Eventually(t, func() bool { return store.Stats().Subscribed }, time.Second, time.Millisecond)
+
+		statuses, err := store.AreRevoked(ctx, []string{"missed", "never-revoked"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"missed": true, "never-revoked": false}, statuses)
+		assert.Equal(t, uint64(2), store.Stats().StoreLookups, "filter miss must not reach the store")
+	})
+
+	t.Run("AnnouncesEachRevocationOnce", func(t *testing.T) {
+		invalidator := &localInvalidator{}
+		cached := NewCachedRevocationStore(newTestRevocationStore(t), 10, time.Minute, invalidator)
+		defer cached.Close()
+		store := NewFilteredRevocationStore(cached, 100, 0.01, 0, invalidator)
+		defer store.Close()
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "jti", TTL: time.Minute}))
+		require.NoError(t, store.RevokeUser(ctx, 7, time.Now(), time.Minute))
+
+		invalidator.mu.Lock()
+		defer invalidator.mu.Unlock()
+		assert.Equal(t, []string{tokenInvalidationKey("jti"), userInvalidationKey(7)}, invalidator.published)
+	})
+}
+
+// gatedInvalidator only subscribes once open is closed
+type gatedInvalidator struct {
+	localInvalidator
+	open chan struct{}
+}
+
+func (i *gatedInvalidator) Subscribe(ctx context.Context, handler func(tokenID string), ready func()) error {
+	select {
+	case <-i.open:
+	case <-ctx.Done():
+		return nil
+	}
+	return i.localInvalidator.Subscribe(ctx, handler, ready)
+}
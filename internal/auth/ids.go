@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported token ID formats
+const (
+	IDFormatUUIDv4 = "uuidv4"
+	IDFormatUUIDv7 = "uuidv7"
+	IDFormatULID   = "ulid"
+)
+
+// ErrReservedIDPrefix is returned for a token ID prefix that would make token
+// IDs look like family, rotation or user epoch entries of the revocation store
+var ErrReservedIDPrefix = errors.New("token ID prefix starts with a reserved namespace")
+
+// ErrReservedID is returned when a custom generator produces a token ID
+// starting with a reserved namespace
+var ErrReservedID = errors.New("token ID starts with a reserved namespace")
+
+// reservedIDPrefixes are the namespaces token IDs share their keyspace with
+var reservedIDPrefixes = []string{familyRevocationPrefix, rotationRevocationPrefix, userInvalidationPrefix}
+
+// crockfordAlphabet is the Crockford base32 alphabet used by ULIDs
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// IDGenerator creates the unique IDs of tokens and token families
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// IDGeneratorFunc adapts an ordinary function to the IDGenerator interface
+type IDGeneratorFunc func() (string, error)
+
+// NewID calls f()
+func (f IDGeneratorFunc) NewID() (string, error) {
+	return f()
+}
+
+// NewIDGenerator returns a generator for the format, defaulting to UUIDv7,
+// with every ID starting with prefix (e.g. an instance name). Time-ordered
+// formats take their timestamp from clock.
+func NewIDGenerator(format, prefix string, clock Clock) (IDGenerator, error) {
+	if err := ValidateIDPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	var generator IDGenerator
+	switch format {
+	case IDFormatUUIDv7, "":
+		generator = IDGeneratorFunc(func() (string, error) { return newUUIDv7(clock.Now()) })
+	case IDFormatUUIDv4:
+		generator = IDGeneratorFunc(NewUUIDv4)
+	case IDFormatULID:
+		generator = IDGeneratorFunc(func() (string, error) { return newULID(clock.Now()) })
+	default:
+		return nil, fmt.Errorf("unsupported token ID format %q", format)
+	}
+
+	return WithIDPrefix(generator, prefix), nil
+}
+
+// ValidateIDPrefix rejects prefixes starting with a reserved namespace, such
+// as "family:", under which a revoked token ID would be taken for a revoked
+// family. The generated IDs never contain a colon, so no other prefix can
+// produce one.
+func ValidateIDPrefix(prefix string) error {
+	if reserved, ok := reservedNamespace(prefix); ok {
+		return fmt.Errorf("%w: %q", ErrReservedIDPrefix, reserved)
+	}
+	return nil
+}
+
+// reservedNamespace returns the reserved namespace s starts with, if any
+func reservedNamespace(s string) (string, bool) {
+	for _, reserved := range reservedIDPrefixes {
+		if strings.HasPrefix(s, reserved) {
+			return reserved, true
+		}
+	}
+	return "", false
+}
+
+// withIDValidation returns a generator failing instead of returning an ID of
+// generator that starts with a reserved namespace
+func withIDValidation(generator IDGenerator) IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		id, err := generator.NewID()
+		if err != nil {
+			return "", err
+		}
+		if reserved, ok := reservedNamespace(id); ok {
+			return "", fmt.Errorf("%w: %q", ErrReservedID, reserved)
+		}
+		return id, nil
+	})
+}
+
+// WithIDPrefix returns a generator that prepends prefix to every ID of generator
+func WithIDPrefix(generator IDGenerator, prefix string) IDGenerator {
+	if prefix == "" {
+		return generator
+	}
+
+	return IDGeneratorFunc(func() (string, error) {
+		id, err := generator.NewID()
+		if err != nil {
+			return "", err
+		}
+		return prefix + id, nil
+	})
+}
+
+// NewUUIDv4 returns a random RFC 9562 version 4 UUID
+func NewUUIDv4() (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return "", fmt.Errorf("could not generate token ID: %w", err)
+	}
+
+	uuid[6] = uuid[6]&0x0f | 0x40 // version 4
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 9562 variant
+
+	return formatUUID(uuid), nil
+}
+
+// NewUUIDv7 returns a RFC 9562 version 7 UUID: a millisecond timestamp followed
+// by 74 random bits, so IDs sort by creation time and index well
+func NewUUIDv7() (string, error) {
+	return newUUIDv7(time.Now())
+}
+
+// newUUIDv7 returns a version 7 UUID stamped with now
+func newUUIDv7(now time.Time) (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[6:]); err != nil {
+		return "", fmt.Errorf("could not generate token ID: %w", err)
+	}
+
+	putMilliseconds(uuid[:6], now)
+	uuid[6] = uuid[6]&0x0f | 0x70 // version 7
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 9562 variant
+
+	return formatUUID(uuid), nil
+}
+
+// NewULID returns a ULID: a millisecond timestamp followed by 80 random bits,
+// in 26 characters of Crockford base32
+func NewULID() (string, error) {
+	return newULID(time.Now())
+}
+
+// newULID returns a ULID stamped with now
+func newULID(now time.Time) (string, error) {
+	var ulid [16]byte
+	if _, err := rand.Read(ulid[6:]); err != nil {
+		return "", fmt.Errorf("could not generate token ID: %w", err)
+	}
+
+	putMilliseconds(ulid[:6], now)
+
+	// 128 bits are encoded five at a time from the end, the first character carries the top three
+	hi := binary.BigEndian.Uint64(ulid[:8])
+	lo := binary.BigEndian.Uint64(ulid[8:])
+
+	var encoded [26]byte
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(encoded[:]), nil
+}
+
+// putMilliseconds writes the Unix time of t in milliseconds as 48 big-endian bits
+func putMilliseconds(dst []byte, t time.Time) {
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(dst, ms[2:])
+}
+
+// formatUUID renders a UUID in its canonical 8-4-4-4-12 hex form
+func formatUUID(uuid [16]byte) string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+
+	return string(buf[:])
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDFormats(t *testing.T) {
+	tests := []struct {
+		format  string
+		pattern string
+	}{
+		{IDFormatUUIDv4, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{IDFormatUUIDv7, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{IDFormatULID, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			generator, err := NewIDGenerator(tt.format, "", SystemClock)
+			require.NoError(t, err)
+
+			id, err := generator.NewID()
+			require.NoError(t, err)
+			assert.Regexp(t, tt.pattern, id)
+
+			// Instance prefixes are prepended verbatim
+			prefixed, err := NewIDGenerator(tt.format, "eu1-", SystemClock)
+			require.NoError(t, err)
+			id, err = prefixed.NewID()
+			require.NoError(t, err)
+			assert.Regexp(t, regexp.MustCompile(`^eu1-`+tt.pattern[1:]), id)
+		})
+	}
+
+	_, err := NewIDGenerator("snowflake", "", SystemClock)
+	assert.Error(t, err)
+
+	// Prefixes mustn't make token IDs look like other revocation entries
+	for _, prefix := range []string{"family:", "rotated:eu1-", "user:"} {
+		_, err = NewIDGenerator(IDFormatUUIDv7, prefix, SystemClock)
+		assert.ErrorIs(t, err, ErrReservedIDPrefix, prefix)
+	}
+	_, err = NewIDGenerator(IDFormatUUIDv7, "eu1:", SystemClock)
+	assert.NoError(t, err)
+}
+
+func TestTimeOrderedIDsSortByCreation(t *testing.T) {
+	for _, format := range []string{IDFormatUUIDv7, IDFormatULID} {
+		clock := NewFakeClock(time.Now())
+		generator, err := NewIDGenerator(format, "", clock)
+		require.NoError(t, err)
+
+		first, err := generator.NewID()
+		require.NoError(t, err)
+
+		clock.Advance(time.Millisecond)
+
+		second, err := generator.NewID()
+		require.NoError(t, err)
+		assert.Less(t, first, second, format)
+	}
+}
+
+func TestIDUniquenessUnderConcurrency(t *testing.T) {
+	total := 2_000_000
+	if testing.Short() {
+		total = 100_000
+	}
+
+	for _, format := range []string{IDFormatUUIDv4, IDFormatUUIDv7, IDFormatULID} {
+		t.Run(format, func(t *testing.T) {
+			generator, err := NewIDGenerator(format, "", SystemClock)
+			require.NoError(t, err)
+
+			// Every worker fills its own slice, the IDs are only compared afterwards
+			workers := runtime.GOMAXPROCS(0) * 4
+			results := make([][]string, workers)
+
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+
+					ids := make([]string, 0, total/workers+1)
+					for i := w; i < total; i += workers {
+						id, err := generator.NewID()
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						ids = append(ids, id)
+					}
+					results[w] = ids
+				}(w)
+			}
+			wg.Wait()
+
+			seen := make(map[string]struct{}, total)
+			for _, ids := range results {
+				for _, id := range ids {
+					if _, duplicate := seen[id]; duplicate {
+						t.Fatalf("duplicate ID %s", id)
+					}
+					seen[id] = struct{}{}
+				}
+			}
+			assert.Len(t, seen, total)
+		})
+	}
+}
+
+func TestJWTManagerUsesIDGenerator(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		JWTIDFormat:            IDFormatULID,
+		JWTIDPrefix:            "node7-",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+	accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+
+	accessClaims, err := jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+	refreshClaims, err := jwtManager.VerifyToken(ctx, refreshToken)
+	require.NoError(t, err)
+
+	// Token and family IDs are all distinct and come from the configured generator
+	for _, id := range []string{accessClaims.TokenID, refreshClaims.TokenID, accessClaims.FamilyID} {
+		assert.Regexp(t, `^node7-[0-9A-HJKMNP-TV-Z]{26}$`, id)
+	}
+	assert.NotEqual(t, accessClaims.TokenID, refreshClaims.TokenID)
+	assert.NotEqual(t, accessClaims.TokenID, accessClaims.FamilyID)
+
+	// A custom generator replaces the configured one
+	jwtManager.SetIDGenerator(IDGeneratorFunc(func() (string, error) {
+		return NewUUIDv4()
+	}))
+	accessToken, _, err = jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	accessClaims, err = jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Len(t, accessClaims.TokenID, 36)
+}
+
+func TestJWTManagerIDsFollowItsClock(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		JWTIDFormat:            IDFormatULID,
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	jwtManager, clock := newFakeClockManager(t, cfg)
+	clock.Set(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+
+	accessToken, _, err := jwtManager.GenerateTokens(ctx, &models.User{ID: 1, Username: "testuser", Role: "user"})
+	require.NoError(t, err)
+	claims, err := jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+
+	// The first 10 characters of a ULID encode its timestamp
+	expected, err := newULID(clock.Now())
+	require.NoError(t, err)
+	assert.Equal(t, expected[:10], claims.TokenID[:10])
+}
+
+func TestJWTManagerRejectsReservedIDs(t *testing.T) {
+	jwtManager := newSessionTestManager(t)
+	ctx := context.Background()
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	for _, reserved := range reservedIDPrefixes {
+		jwtManager.SetIDGenerator(IDGeneratorFunc(func() (string, error) {
+			id, err := NewUUIDv7()
+			return reserved + id, err
+		}))
+		_, _, err := jwtManager.GenerateTokens(ctx, user)
+		assert.ErrorIs(t, err, ErrReservedID, reserved)
+	}
+
+	// A colon elsewhere in the ID is fine
+	jwtManager.SetIDGenerator(IDGeneratorFunc(func() (string, error) {
+		id, err := NewUUIDv7()
+		return "node7:" + id, err
+	}))
+	_, _, err := jwtManager.GenerateTokens(ctx, user)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisInvalidationChannel is the pub/sub channel revocations are announced on
+const redisInvalidationChannel = "blacklist:invalidations"
+
+// resubscribeDelay is how long to wait before resubscribing to revocation
+// announcements after losing the subscription
+const resubscribeDelay = time.Second
+
+// Invalidation messages are typed by their prefix, so no token ID, whatever
+// its own prefix, is mistaken for a user's revocation epoch
+const (
+	tokenInvalidationPrefix = "token:"
+	userInvalidationPrefix  = "user:"
+)
+
+// tokenInvalidationKey returns the invalidation message announcing the revocation of tokenID
+func tokenInvalidationKey(tokenID string) string {
+	return tokenInvalidationPrefix + tokenID
+}
+
+// parseTokenInvalidationKey extracts the token ID from a token invalidation message
+func parseTokenInvalidationKey(key string) (string, bool) {
+	return strings.CutPrefix(key, tokenInvalidationPrefix)
+}
+
+// userInvalidationKey returns the invalidation message announcing a new epoch for userID
+func userInvalidationKey(userID int) string {
+	return userInvalidationPrefix + strconv.Itoa(userID)
+}
+
+// parseUserInvalidationKey extracts the user ID from a user epoch invalidation message
+func parseUserInvalidationKey(key string) (int, bool) {
+	if !strings.HasPrefix(key, userInvalidationPrefix) {
+		return 0, false
+	}
+
+	userID, err := strconv.Atoi(strings.TrimPrefix(key, userInvalidationPrefix))
+	return userID, err == nil
+}
+
+// RevocationInvalidator broadcasts revocations to every instance sharing a
+// revocation store so their local caches can drop stale "not revoked" answers
+type RevocationInvalidator interface {
+	// Publish announces a token or user epoch invalidation message
+	Publish(ctx context.Context, tokenID string) error
+
+	// Subscribe calls handler for every announced message until ctx is done
+	// or the subscription fails. ready, if not nil, is called whenever the
+	// subscription is established or reestablished; announcements made while
+	// it was down are lost.
+	Subscribe(ctx context.Context, handler func(tokenID string), ready func()) error
+}
+
+// keepSubscribed subscribes handler to the invalidator's announcements until
+// ctx is done, calling lost each time the subscription fails and
+// resubscribing after resubscribeDelay
+func keepSubscribed(ctx context.Context, invalidator RevocationInvalidator, handler func(key string), ready func(), lost func(err error)) {
+	for {
+		err := invalidator.Subscribe(ctx, handler, ready)
+		lost(err)
+		if ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-time.After(resubscribeDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// announcer is implemented by store decorators that announce their own
+// revocations, so decorators stacked on top don't announce them again
+type announcer interface {
+	announcesRevocations() bool
+}
+
+// announcesRevocations reports whether store publishes its revocations itself
+func announcesRevocations(store RevocationStore) bool {
+	a, ok := store.(announcer)
+	return ok && a.announcesRevocations()
+}
+
+// RedisRevocationInvalidator implements RevocationInvalidator with Redis pub/sub
+type RedisRevocationInvalidator struct {
+	client *redis.Client
+}
+
+// NewRedisRevocationInvalidator creates a new Redis pub/sub invalidator
+func NewRedisRevocationInvalidator(client *redis.Client) *RedisRevocationInvalidator {
+	return &RedisRevocationInvalidator{client: client}
+}
+
+// Publish sends the token ID to all subscribed instances
+func (i *RedisRevocationInvalidator) Publish(ctx context.Context, tokenID string) error {
+	return i.client.Publish(ctx, redisInvalidationChannel, tokenID).Err()
+}
+
+// Subscribe listens on the invalidation channel until ctx is done. The
+// client reconnects on its own, calling ready again once it has resubscribed.
+func (i *RedisRevocationInvalidator) Subscribe(ctx context.Context, handler func(tokenID string), ready func()) error {
+	pubsub := i.client.Subscribe(ctx, redisInvalidationChannel)
+	defer pubsub.Close()
+
+	// Wait for the subscription to be confirmed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	if ready != nil {
+		ready()
+	}
+
+	messages := pubsub.ChannelWithSubscriptions(ctx, 100)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			switch msg := msg.(type) {
+			case *redis.Message:
+				handler(msg.Payload)
+			case *redis.Subscription:
+				if msg.Kind == "subscribe" && ready != nil {
+					ready()
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
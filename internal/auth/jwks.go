@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"time"
+)
+
+// JWKSMaxAge is how long JWKS consumers may cache the published keys. A
+// rotated key has to be published for at least this long before it signs,
+// or consumers holding the previous set reject its tokens.
+const JWKSMaxAge = 5 * time.Minute
+
+// JWK is the public half of a verification key in RFC 7517 JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet is a RFC 7517 JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWK returns the key's public half as a JWK. HMAC keys have no public
+// half and are never published, so ok is false for them.
+func (k *SigningKey) PublicJWK() (JWK, bool) {
+	jwk := JWK{
+		Use:       "sig",
+		KeyID:     k.ID,
+		Algorithm: k.Algorithm(),
+	}
+
+	switch key := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// PublicJWKS returns every published verification key of the keyring
+func (k *Keyring) PublicJWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.Keys() {
+		if jwk, ok := key.PublicJWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
+
+// PublicAlgorithms returns the distinct algorithms of the published
+// verification keys, leaving out those of HMAC keys that third parties can't
+// verify with
+func (k *Keyring) PublicAlgorithms() []string {
+	seen := make(map[string]bool)
+	algorithms := []string{}
+	for _, jwk := range k.PublicJWKS().Keys {
+		if !seen[jwk.Algorithm] {
+			seen[jwk.Algorithm] = true
+			algorithms = append(algorithms, jwk.Algorithm)
+		}
+	}
+
+	return algorithms
+}
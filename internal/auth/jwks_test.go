@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicJWKS(t *testing.T) {
+	hmacKey := NewHMACSigningKey([]byte("jwks-test-secret"))
+	keyring := NewKeyring(hmacKey)
+
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA} {
+		_, err := keyring.Rotate(algorithm, 0, time.Hour)
+		require.NoError(t, err)
+	}
+
+	set := keyring.PublicJWKS()
+	require.Len(t, set.Keys, 3, "HMAC keys must never be published")
+	assert.ElementsMatch(t, []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}, keyring.PublicAlgorithms())
+
+	for _, jwk := range set.Keys {
+		key, ok := keyring.Get(jwk.KeyID)
+		require.True(t, ok)
+		assert.Equal(t, key.Algorithm(), jwk.Algorithm)
+		assert.Equal(t, "sig", jwk.Use)
+
+		// The published coordinates must rebuild the exact verification key
+		switch public := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			assert.Equal(t, "RSA", jwk.KeyType)
+			assert.Equal(t, public.N, decodeJWKInt(t, jwk.N))
+			assert.Equal(t, int64(public.E), decodeJWKInt(t, jwk.E).Int64())
+		case *ecdsa.PublicKey:
+			assert.Equal(t, "EC", jwk.KeyType)
+			assert.Equal(t, "P-256", jwk.Curve)
+			assert.Equal(t, public.X, decodeJWKInt(t, jwk.X))
+			assert.Equal(t, public.Y, decodeJWKInt(t, jwk.Y))
+		case ed25519.PublicKey:
+			assert.Equal(t, "OKP", jwk.KeyType)
+			assert.Equal(t, "Ed25519", jwk.Curve)
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			require.NoError(t, err)
+			assert.Equal(t, []byte(public), x)
+		default:
+			t.Fatalf("unexpected key type %T", public)
+		}
+	}
+}
+
+// decodeJWKInt decodes a base64url-encoded big-endian JWK integer
+func decodeJWKInt(t *testing.T, value string) *big.Int {
+	t.Helper()
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	require.NoError(t, err)
+	return new(big.Int).SetBytes(data)
+}
@@ -2,17 +2,17 @@ package auth
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/anhbkpro/jwt-blacklist-go/config"
 	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
-	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -20,13 +20,65 @@ var (
 	ErrInvalidToken     = errors.New("invalid token")
 	ErrTokenExpired     = errors.New("token expired")
 	ErrTokenBlacklisted = errors.New("token blacklisted")
+
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+
+	// errRefreshTokenRotated is returned by VerifyToken for a refresh token
+	// that was already exchanged for a new one
+	errRefreshTokenRotated = fmt.Errorf("%w: refresh token already rotated", ErrTokenBlacklisted)
+
+	// Registered claim failures, each also matching ErrInvalidToken
+	ErrInvalidIssuer    = fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	ErrInvalidAudience  = fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	ErrInvalidSubject   = fmt.Errorf("%w: subject does not match user", ErrInvalidToken)
+	ErrTokenNotYetValid = fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
 )
 
+// StoreTimeoutError reports a revocation store call that missed its deadline
+type StoreTimeoutError struct {
+	Op    string
+	Limit time.Duration
+	Err   error
+}
+
+func (e *StoreTimeoutError) Error() string {
+	return fmt.Sprintf("revocation store %s timed out after %s: %v", e.Op, e.Limit, e.Err)
+}
+
+func (e *StoreTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports true, matching the net.Error convention
+func (e *StoreTimeoutError) Timeout() bool {
+	return true
+}
+
 // JWTManager handles JWT operations
 
 type JWTManager struct {
-	config     *config.Config
-	redisCache *redis.Client
+	config         *config.Config
+	keyring        *Keyring
+	store          RevocationStore
+	ids            IDGenerator
+	securityEvents SecurityEventHandler
+	enrichClaims   ClaimsEnricher
+	authorizer     *Authorizer
+	sessions       SessionStore
+	sessionLimits  SessionLimits
+	idleTimeout    time.Duration
+	maxLifetime    time.Duration
+	lookupTimeout  time.Duration
+	revokeTimeout  time.Duration
+	availability   AvailabilityPolicy
+	clock          Clock
+
+	// loginLocks serialize the logins of a user, striped by user ID
+	loginLocks [64]sync.Mutex
+
+	failedOpen      atomic.Uint64
+	servedFromCache atomic.Uint64
+	rejected        atomic.Uint64
 }
 
 // JWTClaims contains the claims data stored in the JWT
@@ -37,148 +89,689 @@ type JWTClaims struct {
 	Role      string `json:"role"`
 	TokenID   string `json:"jti"`
 	TokenType string `json:"type"` // "access" or "refresh"
+	FamilyID  string `json:"fam,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	Scope     string `json:"scope,omitempty"` // space-delimited, as in OAuth2
+	// AuthTime is when the user logged in, carried unchanged through refreshes
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// IssuedAtMicros is iat in microseconds, so a logout everywhere spares
+	// the tokens issued within the same second after it
+	IssuedAtMicros int64 `json:"iat_us,omitempty"`
 	jwt.RegisteredClaims
+
+	// Extra holds the custom claims added by the ClaimsEnricher, serialized
+	// next to the standard claims; read them with the typed accessors
+	Extra map[string]interface{} `json:"-"`
 }
 
-// NewJWTManager creates a new JWT manager
-func NewJWTManager(config *config.Config, redisCache *redis.Client) *JWTManager {
-	return &JWTManager{
-		config:     config,
-		redisCache: redisCache,
+// NewJWTManager creates a new JWT manager. Keys are loaded from the keyring
+// directory when one is configured, otherwise from the single configured key.
+func NewJWTManager(config *config.Config, store RevocationStore) (*JWTManager, error) {
+	var keyring *Keyring
+	if config.JWTKeyringDir != "" {
+		var err error
+		keyring, err = LoadKeyring(config.JWTKeyringDir)
+		if err != nil {
+			return nil, fmt.Errorf("could not load keyring: %w", err)
+		}
+	} else {
+		key, err := LoadSigningKey(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not load signing key: %w", err)
+		}
+		keyring = NewKeyring(key)
 	}
+
+	return NewJWTManagerWithKeyring(config, keyring, store)
 }
 
-// GenerateTokens creates new access and refresh tokens for a user
-func (m *JWTManager) GenerateTokens(user *models.User) (string, string, error) {
-	// Generate access token
-	accessJti := generateTokenId()
-	accessClaims := JWTClaims{
-		UserID:    user.ID,
-		Username:  user.Username,
-		Role:      user.Role,
-		TokenID:   accessJti,
-		TokenType: "access",
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.config.AccessTokenExpiration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// NewJWTManagerWithKeyring creates a new JWT manager signing with the keyring's active key
+func NewJWTManagerWithKeyring(config *config.Config, keyring *Keyring, store RevocationStore) (*JWTManager, error) {
+	manager := &JWTManager{
+		config:         config,
+		keyring:        keyring,
+		store:          store,
+		securityEvents: logSecurityEvent,
+		availability:   FailClosed,
+		clock:          SystemClock,
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString([]byte(m.config.JWTSecret))
+	// IDs follow the clock set by SetClock
+	var err error
+	manager.ids, err = NewIDGenerator(config.JWTIDFormat, config.JWTIDPrefix, ClockFunc(func() time.Time {
+		return manager.clock.Now()
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Revocation != nil {
+		manager.lookupTimeout = config.Revocation.LookupTimeout
+		manager.revokeTimeout = config.Revocation.RevokeTimeout
+		manager.availability, err = ParseAvailabilityPolicy(config.Revocation.Availability)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Sessions != nil {
+		manager.idleTimeout = config.Sessions.IdleTimeout
+		manager.maxLifetime = config.Sessions.MaxLifetime
+	}
+
+	return manager, nil
+}
+
+// Keyring returns the keys used to sign and verify tokens
+func (m *JWTManager) Keyring() *Keyring {
+	return m.keyring
+}
+
+// SetIDGenerator replaces the generator of token and family IDs, e.g. with
+// one embedding a custom instance identifier. Issuing a token fails with
+// ErrReservedID if the generator produces an ID in a reserved namespace.
+func (m *JWTManager) SetIDGenerator(ids IDGenerator) {
+	m.ids = withIDValidation(ids)
+}
+
+// SetClaimsEnricher registers a hook adding custom claims to the tokens
+// issued at login. Refreshed tokens carry the claims of the refresh token.
+func (m *JWTManager) SetClaimsEnricher(enricher ClaimsEnricher) {
+	m.enrichClaims = enricher
+}
+
+// SetAuthorizer makes the role claim and the scopes of new tokens follow the
+// roles assigned through the authorizer rather than the users table. Refreshes
+// drop the scopes the user's roles no longer grant.
+func (m *JWTManager) SetAuthorizer(authorizer *Authorizer) {
+	m.authorizer = authorizer
+}
+
+// SetClock replaces the clock used to stamp, verify and revoke tokens, to
+// time-order their IDs and to retire and rotate the keyring's keys, e.g. with
+// a FakeClock in tests
+func (m *JWTManager) SetClock(clock Clock) {
+	m.clock = clock
+	m.keyring.SetClock(clock)
+}
+
+// GenerateTokens creates new access and refresh tokens for a user, granting
+// every scope of the user's role
+func (m *JWTManager) GenerateTokens(ctx context.Context, user *models.User) (string, string, error) {
+	return m.GenerateScopedTokens(ctx, user, nil)
+}
+
+// GenerateScopedTokens creates new access and refresh tokens for a user,
+// limited to the requested scopes. No requested scopes grants every scope of
+// the user's role; a scope outside of them fails with ErrInvalidScope.
+func (m *JWTManager) GenerateScopedTokens(ctx context.Context, user *models.User, scopes []string) (string, string, error) {
+	role, roleScopes, err := m.grantedRole(ctx, user.ID, user.Role)
 	if err != nil {
 		return "", "", err
 	}
+	if role != user.Role {
+		// Session limits and the claims enricher see the role of the token
+		withRole := *user
+		withRole.Role = role
+		user = &withRole
+	}
 
-	// Generate refresh token
-	refreshJti := generateTokenId()
-	refreshClaims := JWTClaims{
-		UserID:    user.ID,
-		Username:  user.Username,
-		Role:      user.Role,
-		TokenID:   refreshJti,
-		TokenType: "refresh",
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.config.RefreshTokenExpiration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	granted, err := narrowScope(roleScopes, scopes)
+	if err != nil {
+		return "", "", err
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshTokenString, err := refreshToken.SignedString([]byte(m.config.JWTSecret))
+	// Every login starts a new token family shared by all tokens rotated from it
+	familyID, err := m.ids.NewID()
 	if err != nil {
 		return "", "", err
 	}
 
-	return accessTokenString, refreshTokenString, nil
-}
+	base := JWTClaims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		FamilyID: familyID,
+		Scope:    FormatScope(granted),
+		AuthTime: jwt.NewNumericDate(m.clock.Now()),
+	}
 
-// VerifyToken validates the token and returns the claims
-func (m *JWTManager) VerifyToken(tokenString string) (*JWTClaims, error) {
-	// Parse the token
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+	if m.sessions != nil {
+		// Held until the session is recorded, so concurrent logins can't all
+		// find room under the session limit
+		lock := m.loginLock(user.ID)
+		lock.Lock()
+		defer lock.Unlock()
+
+		if err := m.enforceSessionLimit(ctx, user); err != nil {
+			return "", "", err
+		}
+		if base.SessionID, err = m.ids.NewID(); err != nil {
+			return "", "", err
 		}
+	}
 
-		return []byte(m.config.JWTSecret), nil
-	})
+	if m.enrichClaims != nil {
+		extra, err := m.enrichClaims(ctx, user)
+		if err != nil {
+			return "", "", fmt.Errorf("could not enrich claims: %w", err)
+		}
+		for name := range extra {
+			if IsReservedClaim(name) {
+				return "", "", fmt.Errorf("%w: %q", ErrReservedClaim, name)
+			}
+		}
+		base.Extra = extra
+	}
 
+	accessToken, refreshToken, err := m.issueTokenPair(base, base.Scope)
 	if err != nil {
-		if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, ErrTokenExpired
+		return "", "", err
+	}
+
+	if m.sessions != nil {
+		if err := m.recordSession(ctx, base); err != nil {
+			return "", "", fmt.Errorf("%w: could not record session: %w", ErrSessionStoreUnavailable, err)
 		}
+	}
 
-		return nil, ErrInvalidToken
+	return accessToken, refreshToken, nil
+}
+
+// VerifyToken validates the token and returns the claims
+func (m *JWTManager) VerifyToken(ctx context.Context, tokenString string) (*JWTClaims, error) {
+	// Parse the token
+	claims, err := m.parseToken(tokenString)
+	if err != nil {
+		return nil, err
 	}
 
-	claims, ok := token.Claims.(*JWTClaims)
-	if !ok || !token.Valid {
-		return nil, ErrInvalidToken
+	// Check if the token or its family is blacklisted, or the refresh token spent
+	tokenIDs := []string{claims.TokenID}
+	if claims.FamilyID != "" {
+		tokenIDs = append(tokenIDs, familyRevocationKey(claims.FamilyID))
+	}
+	if claims.TokenType == "refresh" {
+		tokenIDs = append(tokenIDs, rotationRevocationKey(claims.TokenID))
 	}
 
-	// Check if token is blacklisted
-	isBlacklisted, err := m.IsTokenBlacklisted(claims.TokenID)
+	revoked, revokedBefore, err := m.lookupRevocations(ctx, claims.UserID, tokenIDs)
 	if err != nil {
-		return nil, err
+		// The store can't tell, so the availability policy decides
+		revoked, revokedBefore, err = m.applyAvailabilityPolicy(ctx, claims.UserID, tokenIDs, err)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if isBlacklisted {
-		return nil, ErrTokenBlacklisted
+
+	if revoked[claims.TokenID] || (claims.FamilyID != "" && revoked[familyRevocationKey(claims.FamilyID)]) {
+		return nil, m.sessionRevocation(ctx, claims)
+	}
+
+	// Check if all of the user's tokens were revoked after this one was issued
+	if !revokedBefore.IsZero() {
+		issuedAt, ok := claims.issuedAt()
+		if !ok || !issuedAt.After(revokedBefore) {
+			return nil, ErrTokenBlacklisted
+		}
+	}
+
+	// Only a refresh token revoked by nothing but its own rotation is being reused
+	if claims.TokenType == "refresh" && revoked[rotationRevocationKey(claims.TokenID)] {
+		return nil, errRefreshTokenRotated
 	}
 
 	// Return the claims
 	return claims, nil
 }
 
-// RefreshToken creates a new access token from a valid refresh token
-func (m *JWTManager) RefreshToken(refreshTokenString string) (string, error) {
-	claims, err := m.VerifyToken(refreshTokenString)
+// RefreshToken rotates a valid refresh token into a new access and refresh
+// token pair. The presented refresh token is revoked, so it can only be used
+// once; presenting it again revokes its whole family.
+func (m *JWTManager) RefreshToken(ctx context.Context, refreshTokenString string) (string, string, error) {
+	return m.RefreshScopedToken(ctx, refreshTokenString, nil)
+}
+
+// RefreshScopedToken is RefreshToken with the new access token limited to the
+// requested scopes. The refresh token keeps the scopes granted at login, so a
+// narrowed refresh never shrinks, and no refresh ever widens, what later
+// refreshes may ask for.
+func (m *JWTManager) RefreshScopedToken(ctx context.Context, refreshTokenString string, scopes []string) (string, string, error) {
+	claims, err := m.VerifyToken(ctx, refreshTokenString)
 	if err != nil {
-		return "", err
+		// Tokens revoked by a logout, eviction or revoked family are merely
+		// rejected; only a spent refresh token points at a stolen copy
+		if errors.Is(err, errRefreshTokenRotated) {
+			if claims, parseErr := m.parseToken(refreshTokenString); parseErr == nil {
+				return "", "", m.handleRefreshTokenReuse(ctx, claims)
+			}
+		}
+		return "", "", err
 	}
 
 	// Ensure this is a refresh token
 	if claims.TokenType != "refresh" {
-		return "", errors.New("not a refresh token")
+		return "", "", errors.New("not a refresh token")
 	}
 
-	// Create a new access token
-	accessJti := generateTokenId()
-	accessClaims := JWTClaims{
+	// Sessions idle or open for too long end here, however fresh the refresh token
+	authTime, err := m.checkSessionAge(ctx, claims)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Follow role changes since the login, dropping scopes no longer granted
+	role, refreshScope := claims.Role, claims.Scope
+	if m.authorizer != nil {
+		var roleScopes []string
+		if role, roleScopes, err = m.grantedRole(ctx, claims.UserID, claims.Role); err != nil {
+			return "", "", err
+		}
+		refreshScope = FormatScope(intersectScopes(claims.Scopes(), roleScopes))
+	}
+
+	// Check the requested scopes before the refresh token is spent
+	accessScopes, err := narrowScope(ParseScope(refreshScope), scopes)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Spend the presented refresh token before minting its successor. Of
+	// concurrent refreshes with the same token only one wins, the others are
+	// reuse.
+	rotated, err := m.spendRefreshToken(ctx, claims)
+	if err != nil {
+		return "", "", err
+	}
+	if !rotated {
+		return "", "", m.handleRefreshTokenReuse(ctx, claims)
+	}
+
+	// Create a new token pair in the same family
+	base := JWTClaims{
 		UserID:    claims.UserID,
 		Username:  claims.Username,
-		Role:      claims.Role,
-		TokenID:   accessJti,
-		TokenType: "access",
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.config.AccessTokenExpiration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+		Role:      role,
+		FamilyID:  claims.FamilyID,
+		SessionID: claims.SessionID,
+		Scope:     refreshScope,
+		AuthTime:  authTime,
+		Extra:     claims.Extra,
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString([]byte(m.config.JWTSecret))
+	accessToken, refreshToken, err := m.issueTokenPair(base, FormatScope(accessScopes))
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	if m.sessions != nil && claims.SessionID != "" {
+		if err := m.touchSession(ctx, claims.SessionID); err != nil {
+			log.Printf("Warning: failed to update session %s: %v", claims.SessionID, err)
+		}
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// RevokeFamily revokes every access and refresh token rotated from the same login
+func (m *JWTManager) RevokeFamily(ctx context.Context, familyID string, userID int, reason string) error {
+	if familyID == "" {
+		return errors.New("token has no family")
+	}
+
+	err := m.storeCall(ctx, "revoke", m.revokeTimeout, func(ctx context.Context) error {
+		return m.store.Revoke(ctx, RevokedToken{
+			TokenID: familyRevocationKey(familyID),
+			UserID:  userID,
+			Reason:  reason,
+			TTL:     m.config.RefreshTokenExpiration + m.config.JWTLeeway,
+		})
+	})
+	if err != nil {
+		log.Printf("Warning: failed to revoke token family %s of user %d: %v", familyID, userID, err)
+		return err
+	}
+
+	log.Printf("--- Revoked token family %s of user %d (%s)", familyID, userID, reason)
+	return nil
+}
+
+// OnSecurityEvent registers a handler for security events such as refresh
+// token reuse. It replaces the default handler, which logs the event.
+func (m *JWTManager) OnSecurityEvent(handler SecurityEventHandler) {
+	m.securityEvents = handler
+}
+
+// BlacklistToken adds a token to the blacklist. A token of a session ends
+// the session, revoking the refresh token logged in with it as well.
+func (m *JWTManager) BlacklistToken(ctx context.Context, tokenString string) error {
+	// Malformed, badly signed and expired tokens have nothing left to revoke
+	claims, err := m.parseToken(tokenString)
+	if err != nil {
+		return err
+	}
+
+	if err := m.revokeClaims(ctx, claims, "logout"); err != nil {
+		return err
+	}
+
+	if claims.SessionID != "" && claims.FamilyID != "" {
+		if err := m.RevokeFamily(ctx, claims.FamilyID, claims.UserID, SessionSignedOut); err != nil {
+			return err
+		}
+		m.markSessionEnded(ctx, claims.SessionID, SessionSignedOut)
+	}
+	return nil
+}
+
+// RevokeToken revokes a token presented by a client holding it. Revoking a
+// refresh token revokes its whole family, including the access tokens minted
+// from it. Tokens that are malformed, badly signed or already expired are
+// ignored, since they can't be used anyway.
+func (m *JWTManager) RevokeToken(ctx context.Context, tokenString string) error {
+	claims, err := m.parseToken(tokenString)
+	if err != nil {
+		return nil
+	}
+
+	if claims.TokenType == "refresh" && claims.FamilyID != "" {
+		if err := m.RevokeFamily(ctx, claims.FamilyID, claims.UserID, SessionRevokedByClient); err != nil {
+			return err
+		}
+		m.markSessionEnded(ctx, claims.SessionID, SessionRevokedByClient)
+		return nil
+	}
+
+	return m.revokeClaims(ctx, claims, "revoked by client")
+}
+
+// RevokeAllForUser invalidates every access and refresh token issued to the
+// user so far ("logout everywhere") without enumerating them
+func (m *JWTManager) RevokeAllForUser(ctx context.Context, userID int) error {
+	// Tokens carry their issue time in microseconds, as precise as every store keeps the epoch
+	before := m.clock.Now().Truncate(time.Microsecond)
+
+	// Keep the epoch until the longest-lived token issued before it has expired
+	ttl := m.config.MaxTokenLifetime() + m.config.JWTLeeway + time.Second
+
+	err := m.storeCall(ctx, "user revoke", m.revokeTimeout, func(ctx context.Context) error {
+		return m.store.RevokeUser(ctx, userID, before, ttl)
+	})
+	if err != nil {
+		log.Printf("Warning: failed to revoke the tokens of user %d: %v", userID, err)
+		return err
 	}
+	log.Printf("--- Revoked all tokens of user %d issued before %s", userID, before.Format(time.RFC3339Nano))
 
-	return accessTokenString, nil
+	// Every session of the user is over as well
+	sessions, err := m.Sessions(ctx, userID)
+	if err != nil {
+		log.Printf("Warning: failed to list sessions of user %d: %v", userID, err)
+		return nil
+	}
+	for _, session := range sessions {
+		m.markSessionEnded(ctx, session.ID, SessionSignedOutEverywhere)
+	}
+	return nil
 }
 
-// BlacklistToken adds a token to the blacklist
-func (m *JWTManager) BlacklistToken(tokenString string) error {
-	// Parse the token (without full verification)
-	token, _ := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(m.config.JWTSecret), nil
+// issuedAt returns when the token was issued, to the microsecond unless it
+// predates iat_us
+func (c *JWTClaims) issuedAt() (time.Time, bool) {
+	if c.IssuedAtMicros > 0 {
+		return time.UnixMicro(c.IssuedAtMicros), true
+	}
+	if c.IssuedAt != nil {
+		return c.IssuedAt.Time, true
+	}
+	return time.Time{}, false
+}
+
+// IsTokenBlacklisted checks if a token is blacklisted
+func (m *JWTManager) IsTokenBlacklisted(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := m.storeCall(ctx, "lookup", m.lookupTimeout, func(ctx context.Context) (err error) {
+		revoked, err = m.store.IsRevoked(ctx, jti)
+		return err
 	})
+	return revoked, err
+}
+
+// AvailabilityStats returns how many revocation checks the availability
+// policy decided because the store failed
+func (m *JWTManager) AvailabilityStats() AvailabilityStats {
+	return AvailabilityStats{
+		Policy:          m.availability,
+		FailedOpen:      m.failedOpen.Load(),
+		ServedFromCache: m.servedFromCache.Load(),
+		Rejected:        m.rejected.Load(),
+	}
+}
+
+// lookupRevocations asks the store whether any of the token IDs is revoked
+// and for the user's revocation epoch
+func (m *JWTManager) lookupRevocations(ctx context.Context, userID int, tokenIDs []string) (map[string]bool, time.Time, error) {
+	var revoked map[string]bool
+	err := m.storeCall(ctx, "lookup", m.lookupTimeout, func(ctx context.Context) (err error) {
+		revoked, err = m.store.AreRevoked(ctx, tokenIDs)
+		return err
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var revokedBefore time.Time
+	err = m.storeCall(ctx, "user lookup", m.lookupTimeout, func(ctx context.Context) (err error) {
+		revokedBefore, err = m.store.UserRevokedBefore(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return revoked, revokedBefore, nil
+}
+
+// applyAvailabilityPolicy answers a revocation check the store failed on
+// according to the policy in effect for ctx
+func (m *JWTManager) applyAvailabilityPolicy(ctx context.Context, userID int, tokenIDs []string, storeErr error) (map[string]bool, time.Time, error) {
+	// A caller that gave up gets no answer at all
+	if ctx.Err() != nil {
+		return nil, time.Time{}, storeErr
+	}
+
+	switch availabilityPolicyFrom(ctx, m.availability) {
+	case FailOpen:
+		m.failedOpen.Add(1)
+		log.Printf("Warning: accepting token of user %d without revocation check: %v", userID, storeErr)
+		return map[string]bool{}, time.Time{}, nil
+	case DegradeToCache:
+		if lastKnown, ok := m.store.(LastKnownRevocations); ok {
+			revoked, ok := lastKnown.LastKnownRevoked(tokenIDs)
+			// Both answers must be known, a missing epoch could hide a logout everywhere
+			revokedBefore, epochKnown := lastKnown.LastKnownUserRevokedBefore(userID)
+			if ok && epochKnown {
+				m.servedFromCache.Add(1)
+				return revoked, revokedBefore, nil
+			}
+		}
+	}
+
+	m.rejected.Add(1)
+	return nil, time.Time{}, fmt.Errorf("%w: %w", ErrRevocationStoreUnavailable, storeErr)
+}
+
+// storeCall runs a revocation store operation under the operation's timeout,
+// turning a missed deadline into a StoreTimeoutError
+func (m *JWTManager) storeCall(ctx context.Context, op string, timeout time.Duration, call func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := call(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &StoreTimeoutError{Op: op, Limit: timeout, Err: err}
+	}
+
+	return err
+}
+
+// parseToken validates the token's signature, lifetime, issuer, audience and
+// subject and returns its claims
+func (m *JWTManager) parseToken(tokenString string) (*JWTClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, m.keyFunc, m.parserOptions()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, jwt.ErrTokenExpired):
+			return nil, ErrTokenExpired
+		case errors.Is(err, jwt.ErrTokenNotValidYet):
+			return nil, ErrTokenNotYetValid
+		}
+
+		return nil, ErrInvalidToken
+	}
 
-	// Even if token is invalid, we try to extract the claims
 	claims, ok := token.Claims.(*JWTClaims)
 	if !ok || !token.Valid {
-		return errors.New("could not parse token claims")
+		return nil, ErrInvalidToken
+	}
+
+	_, named := token.Header["kid"]
+	if err := m.validateRegisteredClaims(claims, !named); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// parserOptions restricts parsing to the keyring's algorithms and applies the
+// clock-skew leeway against the manager's clock
+func (m *JWTManager) parserOptions() []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithValidMethods(m.keyring.Algorithms()),
+		jwt.WithLeeway(m.config.JWTLeeway),
+		jwt.WithTimeFunc(m.clock.Now),
+	}
+}
+
+// validateRegisteredClaims checks that the token was issued by this issuer
+// for one of the configured audiences and that its subject is its user.
+// Tokens minted before these claims were stamped carry no kid, iss or sub;
+// they're accepted until they expire so upgrading doesn't sign everyone out.
+func (m *JWTManager) validateRegisteredClaims(claims *JWTClaims, unnamed bool) error {
+	if unnamed && claims.Issuer == "" && claims.Subject == "" {
+		return nil
+	}
+
+	if m.config.Issuer != "" && claims.Issuer != m.config.Issuer {
+		return ErrInvalidIssuer
+	}
+
+	if len(m.config.JWTAudience) > 0 && !slices.ContainsFunc(claims.Audience, func(audience string) bool {
+		return slices.Contains(m.config.JWTAudience, audience)
+	}) {
+		return ErrInvalidAudience
+	}
+
+	if claims.Subject != strconv.Itoa(claims.UserID) {
+		return ErrInvalidSubject
+	}
+
+	return nil
+}
+
+// keyFunc selects the verification key named by the token's "kid" header
+func (m *JWTManager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return m.unnamedKeys(token)
+	}
+
+	key, ok := m.keyring.Get(kid)
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	// Only the key's own algorithm is accepted, which rules out algorithm confusion
+	if token.Method.Alg() != key.Algorithm() {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.PublicKey, nil
+}
+
+// unnamedKeys returns every key of the token's algorithm that may still
+// verify tokens, for tokens issued before key IDs were introduced. Which key
+// signed them isn't known, and after a rotation it is no longer the active one.
+func (m *JWTManager) unnamedKeys(token *jwt.Token) (interface{}, error) {
+	var keys jwt.VerificationKeySet
+	for _, key := range m.keyring.Keys() {
+		if key.Algorithm() == token.Method.Alg() {
+			keys.Keys = append(keys.Keys, key.PublicKey)
+		}
+	}
+
+	if len(keys.Keys) == 0 {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return keys, nil
+}
+
+// issueTokenPair signs an access and a refresh token carrying the identity in
+// base. The refresh token keeps the granted scopes of base, the access token
+// carries accessScope.
+func (m *JWTManager) issueTokenPair(base JWTClaims, accessScope string) (string, string, error) {
+	access := base
+	access.Scope = accessScope
+
+	accessTokenString, err := m.signToken(access, "access", m.config.AccessTokenExpiration)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshTokenString, err := m.signToken(base, "refresh", m.config.RefreshTokenExpiration)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessTokenString, refreshTokenString, nil
+}
+
+// signToken stamps a fresh token ID, type and lifetime onto claims and signs them
+func (m *JWTManager) signToken(claims JWTClaims, tokenType string, ttl time.Duration) (string, error) {
+	tokenID, err := m.ids.NewID()
+	if err != nil {
+		return "", err
+	}
+
+	now := m.clock.Now()
+
+	claims.TokenID = tokenID
+	claims.TokenType = tokenType
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    m.config.Issuer,
+		Subject:   strconv.Itoa(claims.UserID),
+		Audience:  m.config.JWTAudience,
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+	claims.IssuedAtMicros = now.UnixMicro()
+
+	key := m.keyring.Active()
+	if !key.CanSign() {
+		return "", ErrSigningKeyUnavailable
 	}
 
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// revokeClaims blacklists the token described by claims until it expires
+func (m *JWTManager) revokeClaims(ctx context.Context, claims *JWTClaims, reason string) error {
 	// Get the token ID and expiration time
 	jti := claims.TokenID
 	exp := claims.ExpiresAt
@@ -186,8 +779,9 @@ func (m *JWTManager) BlacklistToken(tokenString string) error {
 	// Calculate time until token expiration
 	var ttl time.Duration
 	if exp != nil {
-		ttl = time.Until(exp.Time)
-		if ttl < 0 {
+		// Verifiers accept the token for up to the leeway after it expires
+		ttl = exp.Sub(m.clock.Now()) + m.config.JWTLeeway
+		if ttl <= 0 {
 			// Token already expired, no need to blacklist
 			return nil
 		}
@@ -196,33 +790,117 @@ func (m *JWTManager) BlacklistToken(tokenString string) error {
 		ttl = 24 * time.Hour
 	}
 
-	// Store token ID in Redis with TTL
-	ctx := context.Background()
-	key := fmt.Sprintf("blacklist:%s", jti)
-	err := m.redisCache.Set(ctx, key, "1", ttl).Err()
-	log.Printf("--- Blacklisted token %s with TTL %s, key %s", jti, ttl, key)
-	return err
+	// Store token ID in the revocation store with TTL
+	err := m.storeCall(ctx, "revoke", m.revokeTimeout, func(ctx context.Context) error {
+		return m.store.Revoke(ctx, RevokedToken{
+			TokenID: jti,
+			UserID:  claims.UserID,
+			Reason:  reason,
+			TTL:     ttl,
+		})
+	})
+	if err != nil {
+		log.Printf("Warning: failed to blacklist token %s: %v", jti, err)
+		return err
+	}
+
+	log.Printf("--- Blacklisted token %s with TTL %s (%s)", jti, ttl, reason)
+	return nil
 }
 
-// IsTokenBlacklisted checks if a token is blacklisted
-func (m *JWTManager) IsTokenBlacklisted(jti string) (bool, error) {
-	ctx := context.Background()
-	result, err := m.redisCache.Exists(ctx, fmt.Sprintf("blacklist:%s", jti)).Result()
+// spendRefreshToken marks the refresh token described by claims as rotated
+// until it expires, reporting false if it already was
+func (m *JWTManager) spendRefreshToken(ctx context.Context, claims *JWTClaims) (bool, error) {
+	ttl := 24 * time.Hour
+	if claims.ExpiresAt != nil {
+		ttl = claims.ExpiresAt.Sub(m.clock.Now()) + m.config.JWTLeeway
+	}
 
+	var rotated bool
+	err := m.storeCall(ctx, "revoke", m.revokeTimeout, func(ctx context.Context) (err error) {
+		rotated, err = m.store.RevokeIfAbsent(ctx, RevokedToken{
+			TokenID: rotationRevocationKey(claims.TokenID),
+			UserID:  claims.UserID,
+			Reason:  "rotated",
+			TTL:     ttl,
+		})
+		return err
+	})
+	if err == nil && rotated {
+		log.Printf("--- Rotated refresh token %s with TTL %s", claims.TokenID, ttl)
+	}
+	return rotated, err
+}
+
+// handleRefreshTokenReuse revokes the family of a refresh token that was
+// presented after being rotated, since either the legitimate client or an
+// attacker is holding a stolen copy
+func (m *JWTManager) handleRefreshTokenReuse(ctx context.Context, claims *JWTClaims) error {
+	if claims.FamilyID == "" {
+		return ErrTokenBlacklisted
+	}
+
+	// The family must be revoked even if the client hangs up, so only the
+	// deadline applies. RevokeFamily logs a failure.
+	_ = m.RevokeFamily(context.WithoutCancel(ctx), claims.FamilyID, claims.UserID, SessionTokenReuse)
+	m.markSessionEnded(context.WithoutCancel(ctx), claims.SessionID, SessionTokenReuse)
+
+	m.securityEvents(SecurityEvent{
+		Type:     SecurityEventRefreshTokenReuse,
+		UserID:   claims.UserID,
+		Username: claims.Username,
+		TokenID:  claims.TokenID,
+		FamilyID: claims.FamilyID,
+		Time:     m.clock.Now(),
+	})
+
+	return ErrRefreshTokenReused
+}
+
+// grantedRole returns the role to put in a user's tokens and the scopes it
+// grants: from the authorizer when one is set, where the scopes of every role
+// the user holds or inherits add up, otherwise the given role from the users
+// table
+func (m *JWTManager) grantedRole(ctx context.Context, userID int, role string) (string, []string, error) {
+	if m.authorizer == nil {
+		return role, m.config.RoleScopes[role], nil
+	}
+
+	primary, roles, err := m.authorizer.TokenRoles(ctx, userID)
 	if err != nil {
-		return false, err
+		return "", nil, fmt.Errorf("%w: %w", ErrRoleStoreUnavailable, err)
+	}
+
+	var scopes []string
+	for _, name := range append([]string{primary}, roles...) {
+		for _, scope := range m.config.RoleScopes[name] {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
 	}
+	return primary, scopes, nil
+}
 
-	return result > 0, nil
+// loginLock returns the lock serializing the logins of a user
+func (m *JWTManager) loginLock(userID int) *sync.Mutex {
+	return &m.loginLocks[uint(userID)%uint(len(m.loginLocks))]
 }
 
-// Helper function to generate a unique token ID
-func generateTokenId() string {
-	// Create a unique token ID by combining timestamp and random values
-	timestamp := strconv.FormatInt(time.Now().UnixNano(), 16)
-	randomBytes := make([]byte, 8)
-	_, _ = time.Now().UnixNano(), randomBytes
+// Revocation store entries other than token IDs live under these prefixes
+const (
+	familyRevocationPrefix   = "family:"
+	rotationRevocationPrefix = "rotated:"
+)
+
+// familyRevocationKey is the revocation store entry covering a whole token family
+func familyRevocationKey(familyID string) string {
+	return familyRevocationPrefix + familyID
+}
 
-	hash := sha256.Sum256([]byte(timestamp + string(randomBytes)))
-	return hex.EncodeToString(hash[:])
+// rotationRevocationKey is the revocation store entry of a refresh token
+// exchanged for a new one, kept apart from the token's own entry so reuse can
+// be told from other revocations
+func rotationRevocationKey(tokenID string) string {
+	return rotationRevocationPrefix + tokenID
 }
@@ -2,46 +2,199 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/anhbkpro/jwt-blacklist-go/config"
 	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
-	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-// mockRedisClient creates a test Redis client using a real Redis instance
-// For proper testing, you should have Redis running on localhost:6379
-// In a CI environment, you might want to use a Redis mock instead
-func mockRedisClient() *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       15, // Use DB 15 for testing to avoid conflicts
-	})
+// newTestRevocationStore creates an in-memory revocation store so the tests
+// can run without a Redis instance
+func newTestRevocationStore(t *testing.T) *MemoryRevocationStore {
+	store := NewMemoryRevocationStore(0, time.Minute)
+	t.Cleanup(store.Close)
+	return store
 }
 
-// cleanupRedis clears the test Redis database
-func cleanupRedis(client *redis.Client) {
-	client.FlushDB(context.Background())
+// newFakeClockManager creates a JWT manager whose tokens and in-memory
+// revocation store run on a fake clock, stopped at a whole second since that
+// is the precision of token timestamps
+func newFakeClockManager(t *testing.T, cfg *config.Config) (*JWTManager, *FakeClock) {
+	clock := NewFakeClock(time.Now().Truncate(time.Second))
+
+	store := newTestRevocationStore(t)
+	store.SetClock(clock)
+
+	jwtManager, err := NewJWTManager(cfg, store)
+	require.NoError(t, err)
+	jwtManager.SetClock(clock)
+	return jwtManager, clock
 }
 
-func TestMultiDeviceLogout(t *testing.T) {
-	// Initialize Redis client
-	redisClient := mockRedisClient()
-	defer cleanupRedis(redisClient)
+// stubRevocationStore is a map-backed RevocationStore for tests that don't need Redis
+type stubRevocationStore struct {
+	mu           sync.Mutex
+	revoked      map[string]bool
+	epochs       map[int]time.Time
+	lookups      int
+	epochLookups int
+	err          error
+	hang         bool // block until the caller's context is done, like an unresponsive server
+}
 
-	// Create a test context
-	ctx := context.Background()
+func newStubRevocationStore() *stubRevocationStore {
+	return &stubRevocationStore{revoked: make(map[string]bool), epochs: make(map[int]time.Time)}
+}
+
+func (s *stubRevocationStore) Revoke(ctx context.Context, token RevokedToken) error {
+	if s.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.revoked[token.TokenID] = true
+	return nil
+}
+
+func (s *stubRevocationStore) RevokeIfAbsent(ctx context.Context, token RevokedToken) (bool, error) {
+	if s.hang {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if s.revoked[token.TokenID] {
+		return false, nil
+	}
+	s.revoked[token.TokenID] = true
+	return true, nil
+}
+
+func (s *stubRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.revoked[tokenID], nil
+}
+
+func (s *stubRevocationStore) AreRevoked(ctx context.Context, tokenIDs []string) (map[string]bool, error) {
+	if s.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	if s.err != nil {
+		return nil, s.err
+	}
+	result := make(map[string]bool, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		result[tokenID] = s.revoked[tokenID]
+	}
+	return result, nil
+}
+
+func (s *stubRevocationStore) List(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokenIDs []string
+	for tokenID := range s.revoked {
+		tokenIDs = append(tokenIDs, tokenID)
+	}
+	return tokenIDs, s.err
+}
+
+func (s *stubRevocationStore) Count(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.revoked)), s.err
+}
+
+func (s *stubRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.epochs[userID] = before
+	return nil
+}
 
-	// Ping Redis to make sure it's available
-	_, err := redisClient.Ping(ctx).Result()
-	if err != nil {
-		t.Skip("Redis not available, skipping test")
+func (s *stubRevocationStore) UserRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.epochLookups++
+	if s.err != nil {
+		return time.Time{}, s.err
 	}
+	return s.epochs[userID], nil
+}
+
+func (s *stubRevocationStore) RevokedUsers(ctx context.Context) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var userIDs []int
+	for userID := range s.epochs {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, s.err
+}
+
+func TestVerifyTokenWithRevocationStore(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: 7 * 24 * time.Hour,
+	}
+	store := newStubRevocationStore()
+	jwtManager, err := NewJWTManager(cfg, store)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	accessToken, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+
+	claims, err := jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+
+	// Revoking the token goes through the store
+	require.NoError(t, jwtManager.BlacklistToken(ctx, accessToken))
+	assert.True(t, store.revoked[claims.TokenID])
+
+	_, err = jwtManager.VerifyToken(ctx, accessToken)
+	assert.Equal(t, ErrTokenBlacklisted, err)
+
+	// Store failures are surfaced to the caller
+	storeErr := errors.New("store unavailable")
+	store.err = storeErr
+	otherToken, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	_, err = jwtManager.VerifyToken(ctx, otherToken)
+	assert.ErrorIs(t, err, ErrRevocationStoreUnavailable)
+	assert.ErrorIs(t, err, storeErr)
+}
 
+func TestMultiDeviceLogout(t *testing.T) {
 	// Create test configuration
 	cfg := &config.Config{
 		JWTSecret:              "test-secret-key",
@@ -50,7 +203,9 @@ func TestMultiDeviceLogout(t *testing.T) {
 	}
 
 	// Create JWT manager
-	jwtManager := NewJWTManager(cfg, redisClient)
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
 
 	// Create a test user
 	user := &models.User{
@@ -63,12 +218,12 @@ func TestMultiDeviceLogout(t *testing.T) {
 	// Test case: Multi-device scenario
 	t.Run("MultiDeviceLogout", func(t *testing.T) {
 		// Step 1: Generate tokens for "iPhone" (first device)
-		iPhoneAccessToken, _, err := jwtManager.GenerateTokens(user)
+		iPhoneAccessToken, _, err := jwtManager.GenerateTokens(ctx, user)
 		require.NoError(t, err)
 		require.NotEmpty(t, iPhoneAccessToken)
 
 		// Verify the iPhone access token is valid
-		iPhoneAccessClaims, err := jwtManager.VerifyToken(iPhoneAccessToken)
+		iPhoneAccessClaims, err := jwtManager.VerifyToken(ctx, iPhoneAccessToken)
 		require.NoError(t, err)
 		require.NotNil(t, iPhoneAccessClaims)
 
@@ -76,12 +231,12 @@ func TestMultiDeviceLogout(t *testing.T) {
 		iPhoneTokenID := iPhoneAccessClaims.TokenID
 
 		// Step 2: Generate tokens for "iPad" (second device)
-		iPadAccessToken, _, err := jwtManager.GenerateTokens(user)
+		iPadAccessToken, _, err := jwtManager.GenerateTokens(ctx, user)
 		require.NoError(t, err)
 		require.NotEmpty(t, iPadAccessToken)
 
 		// Verify the iPad access token is valid
-		iPadAccessClaims, err := jwtManager.VerifyToken(iPadAccessToken)
+		iPadAccessClaims, err := jwtManager.VerifyToken(ctx, iPadAccessToken)
 		require.NoError(t, err)
 		require.NotNil(t, iPadAccessClaims)
 
@@ -92,68 +247,53 @@ func TestMultiDeviceLogout(t *testing.T) {
 		assert.NotEqual(t, iPhoneTokenID, iPadTokenID, "Tokens should have unique JTIs")
 
 		// Step 3: Logout from iPad (blacklist its token)
-		err = jwtManager.BlacklistToken(iPadAccessToken)
+		err = jwtManager.BlacklistToken(ctx, iPadAccessToken)
 		require.NoError(t, err)
 
 		// Verify iPad token is now blacklisted
-		isBlacklisted, err := jwtManager.IsTokenBlacklisted(iPadTokenID)
+		isBlacklisted, err := jwtManager.IsTokenBlacklisted(ctx, iPadTokenID)
 		require.NoError(t, err)
 		assert.True(t, isBlacklisted, "iPad token should be blacklisted")
 
 		// Verify iPhone token is NOT blacklisted
-		isBlacklisted, err = jwtManager.IsTokenBlacklisted(iPhoneTokenID)
+		isBlacklisted, err = jwtManager.IsTokenBlacklisted(ctx, iPhoneTokenID)
 		require.NoError(t, err)
 		assert.False(t, isBlacklisted, "iPhone token should not be blacklisted")
 
 		// Step 4: Try to verify both tokens
 		// iPad token should fail verification
-		_, err = jwtManager.VerifyToken(iPadAccessToken)
+		_, err = jwtManager.VerifyToken(ctx, iPadAccessToken)
 		assert.Error(t, err)
 		assert.Equal(t, ErrTokenBlacklisted, err)
 
 		// iPhone token should still pass verification
-		_, err = jwtManager.VerifyToken(iPhoneAccessToken)
+		_, err = jwtManager.VerifyToken(ctx, iPhoneAccessToken)
 		assert.NoError(t, err)
 
 		// Step 5: Logout from iPhone too
-		err = jwtManager.BlacklistToken(iPhoneAccessToken)
+		err = jwtManager.BlacklistToken(ctx, iPhoneAccessToken)
 		require.NoError(t, err)
 
 		// Verify iPhone token is now blacklisted
-		isBlacklisted, err = jwtManager.IsTokenBlacklisted(iPhoneTokenID)
+		isBlacklisted, err = jwtManager.IsTokenBlacklisted(ctx, iPhoneTokenID)
 		require.NoError(t, err)
 		assert.True(t, isBlacklisted, "iPhone token should now be blacklisted")
 
 		// Both tokens should now fail verification
-		_, err = jwtManager.VerifyToken(iPhoneAccessToken)
+		_, err = jwtManager.VerifyToken(ctx, iPhoneAccessToken)
 		assert.Error(t, err)
 		assert.Equal(t, ErrTokenBlacklisted, err)
 	})
 }
 
 func TestTokenExpirationInBlacklist(t *testing.T) {
-	// Initialize Redis client
-	redisClient := mockRedisClient()
-	defer cleanupRedis(redisClient)
-
-	// Create a test context
-	ctx := context.Background()
-
-	// Ping Redis to make sure it's available
-	_, err := redisClient.Ping(ctx).Result()
-	if err != nil {
-		t.Skip("Redis not available, skipping test")
-	}
-
 	// Create test configuration with very short token expiration for testing
 	cfg := &config.Config{
 		JWTSecret:              "test-secret-key",
 		AccessTokenExpiration:  2 * time.Second, // Very short for testing
 		RefreshTokenExpiration: 5 * time.Second,
 	}
-
-	// Create JWT manager
-	jwtManager := NewJWTManager(cfg, redisClient)
+	ctx := context.Background()
 
 	// Create a test user
 	user := &models.User{
@@ -165,39 +305,528 @@ func TestTokenExpirationInBlacklist(t *testing.T) {
 
 	// Test case: Token expiration in blacklist
 	t.Run("BlacklistedTokenExpiration", func(t *testing.T) {
+		jwtManager, clock := newFakeClockManager(t, cfg)
+
 		// Generate tokens
-		accessToken, _, err := jwtManager.GenerateTokens(user)
+		accessToken, _, err := jwtManager.GenerateTokens(ctx, user)
 		require.NoError(t, err)
 
 		// Verify token
-		accessClaims, err := jwtManager.VerifyToken(accessToken)
+		accessClaims, err := jwtManager.VerifyToken(ctx, accessToken)
 		require.NoError(t, err)
 
 		// Blacklist the token
-		err = jwtManager.BlacklistToken(accessToken)
+		err = jwtManager.BlacklistToken(ctx, accessToken)
 		require.NoError(t, err)
 
 		// Verify token is blacklisted
-		isBlacklisted, err := jwtManager.IsTokenBlacklisted(accessClaims.TokenID)
+		isBlacklisted, err := jwtManager.IsTokenBlacklisted(ctx, accessClaims.TokenID)
 		require.NoError(t, err)
 		assert.True(t, isBlacklisted)
 
 		// Check that token verification fails
-		_, err = jwtManager.VerifyToken(accessToken)
+		_, err = jwtManager.VerifyToken(ctx, accessToken)
 		assert.Error(t, err)
 		assert.Equal(t, ErrTokenBlacklisted, err)
 
-		// Wait for token to expire in both JWT and Redis
-		time.Sleep(3 * time.Second)
+		// A second before expiry the token would still be accepted, so it stays blacklisted
+		clock.Advance(time.Second)
+		isBlacklisted, err = jwtManager.IsTokenBlacklisted(ctx, accessClaims.TokenID)
+		require.NoError(t, err)
+		assert.True(t, isBlacklisted)
 
-		// Blacklist entry should be automatically removed by Redis
-		isBlacklisted, err = jwtManager.IsTokenBlacklisted(accessClaims.TokenID)
+		_, err = jwtManager.VerifyToken(ctx, accessToken)
+		assert.Equal(t, ErrTokenBlacklisted, err)
+
+		// Exactly at expiry the blacklist entry is removed by the store
+		clock.Advance(time.Second)
+		isBlacklisted, err = jwtManager.IsTokenBlacklisted(ctx, accessClaims.TokenID)
 		require.NoError(t, err)
 		assert.False(t, isBlacklisted, "Blacklist entry should be automatically removed")
 
 		// Token verification should still fail, but now due to expiration
-		_, err = jwtManager.VerifyToken(accessToken)
+		_, err = jwtManager.VerifyToken(ctx, accessToken)
 		assert.Error(t, err)
 		assert.Equal(t, ErrTokenExpired, err)
 	})
+
+	t.Run("LeewayExtendsTheBlacklistEntry", func(t *testing.T) {
+		skewed := *cfg
+		skewed.JWTLeeway = 30 * time.Second
+		jwtManager, clock := newFakeClockManager(t, &skewed)
+
+		accessToken, _, err := jwtManager.GenerateTokens(ctx, user)
+		require.NoError(t, err)
+		require.NoError(t, jwtManager.BlacklistToken(ctx, accessToken))
+
+		// Within the leeway after expiry the token is still accepted, so must stay blacklisted
+		clock.Advance(2*time.Second + 29*time.Second)
+		_, err = jwtManager.VerifyToken(ctx, accessToken)
+		assert.Equal(t, ErrTokenBlacklisted, err)
+
+		// At the end of the leeway the token and its entry expire together
+		clock.Advance(time.Second)
+		_, err = jwtManager.VerifyToken(ctx, accessToken)
+		assert.Equal(t, ErrTokenExpired, err)
+
+		count, err := jwtManager.store.Count(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("ExpiredTokensAreNotBlacklisted", func(t *testing.T) {
+		jwtManager, clock := newFakeClockManager(t, cfg)
+
+		accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+		require.NoError(t, err)
+
+		// The refresh token outlives the access token by three seconds
+		clock.Advance(2 * time.Second)
+		assert.Error(t, jwtManager.BlacklistToken(ctx, accessToken))
+		require.NoError(t, jwtManager.BlacklistToken(ctx, refreshToken))
+
+		count, err := jwtManager.store.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		clock.Advance(3 * time.Second)
+		count, err = jwtManager.store.Count(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+}
+
+func TestRevokeAllForUser(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: 7 * 24 * time.Hour,
+	}
+	jwtManager, clock := newFakeClockManager(t, cfg)
+	ctx := context.Background()
+	clock.Set(time.Unix(1_800_000_000, 100_000_000)) // early in a second
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+	otherUser := &models.User{ID: 2, Username: "otheruser", Role: "user"}
+
+	// Sessions on two devices plus one for another user
+	iPhoneAccess, iPhoneRefresh, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	iPadAccess, iPadRefresh, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	otherAccess, _, err := jwtManager.GenerateTokens(ctx, otherUser)
+	require.NoError(t, err)
+
+	require.NoError(t, jwtManager.RevokeAllForUser(ctx, user.ID))
+
+	// Every access and refresh token of the user is rejected
+	for _, token := range []string{iPhoneAccess, iPhoneRefresh, iPadAccess, iPadRefresh} {
+		_, err = jwtManager.VerifyToken(ctx, token)
+		assert.Equal(t, ErrTokenBlacklisted, err)
+	}
+	_, _, err = jwtManager.RefreshToken(ctx, iPhoneRefresh)
+	assert.Error(t, err)
+
+	// Other users are unaffected
+	_, err = jwtManager.VerifyToken(ctx, otherAccess)
+	assert.NoError(t, err)
+
+	// Logging in again right away works, even within the same second
+	clock.Advance(time.Millisecond)
+	sameSecond, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	_, err = jwtManager.VerifyToken(ctx, sameSecond)
+	assert.NoError(t, err)
+
+	// Tokens without iat_us fall back to iat, revoked for the whole second
+	// as they can't be told apart
+	claims, err := jwtManager.parseToken(sameSecond)
+	require.NoError(t, err)
+	claims.IssuedAtMicros = 0
+	key := jwtManager.keyring.Active()
+	unsigned := jwt.NewWithClaims(key.Method, claims)
+	unsigned.Header["kid"] = key.ID
+	legacy, err := unsigned.SignedString(key.PrivateKey)
+	require.NoError(t, err)
+	_, err = jwtManager.VerifyToken(ctx, legacy)
+	assert.Equal(t, ErrTokenBlacklisted, err)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: 7 * 24 * time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var events []SecurityEvent
+	jwtManager.OnSecurityEvent(func(event SecurityEvent) {
+		events = append(events, event)
+	})
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+
+	originalClaims, err := jwtManager.VerifyToken(ctx, refreshToken)
+	require.NoError(t, err)
+	require.NotEmpty(t, originalClaims.FamilyID)
+
+	// Refreshing rotates the refresh token within the same family
+	newAccessToken, newRefreshToken, err := jwtManager.RefreshToken(ctx, refreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, refreshToken, newRefreshToken)
+
+	newClaims, err := jwtManager.VerifyToken(ctx, newRefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, originalClaims.FamilyID, newClaims.FamilyID)
+
+	// The old refresh token is revoked, the access tokens are untouched
+	_, err = jwtManager.VerifyToken(ctx, refreshToken)
+	assert.ErrorIs(t, err, ErrTokenBlacklisted)
+	_, err = jwtManager.VerifyToken(ctx, accessToken)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	// Presenting the rotated refresh token again is treated as theft
+	_, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+	assert.Equal(t, ErrRefreshTokenReused, err)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, SecurityEventRefreshTokenReuse, events[0].Type)
+	assert.Equal(t, user.ID, events[0].UserID)
+	assert.Equal(t, originalClaims.FamilyID, events[0].FamilyID)
+
+	// The whole family is revoked, including tokens minted by the legitimate rotation
+	for _, token := range []string{accessToken, newAccessToken, newRefreshToken} {
+		_, err = jwtManager.VerifyToken(ctx, token)
+		assert.Equal(t, ErrTokenBlacklisted, err)
+	}
+
+	// Other logins of the same user are unaffected
+	otherAccess, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	_, err = jwtManager.VerifyToken(ctx, otherAccess)
+	assert.NoError(t, err)
+}
+
+func TestRefreshTokenReuseNeedsRotation(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: 7 * 24 * time.Hour,
+	}
+	ctx := context.Background()
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	revocations := map[string]func(m *JWTManager, accessToken, refreshToken string) error{
+		"logout": func(m *JWTManager, accessToken, refreshToken string) error {
+			return m.BlacklistToken(ctx, refreshToken)
+		},
+		"logout everywhere": func(m *JWTManager, accessToken, refreshToken string) error {
+			return m.RevokeAllForUser(ctx, user.ID)
+		},
+		"revoked by client": func(m *JWTManager, accessToken, refreshToken string) error {
+			return m.RevokeToken(ctx, refreshToken)
+		},
+	}
+
+	for name, revoke := range revocations {
+		t.Run(name, func(t *testing.T) {
+			jwtManager, _ := newFakeClockManager(t, cfg)
+
+			var events []SecurityEvent
+			jwtManager.OnSecurityEvent(func(event SecurityEvent) {
+				events = append(events, event)
+			})
+
+			accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+			require.NoError(t, err)
+			require.NoError(t, revoke(jwtManager, accessToken, refreshToken))
+
+			// A revoked refresh token is rejected, but it was never spent
+			_, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+			assert.Equal(t, ErrTokenBlacklisted, err)
+			assert.Empty(t, events)
+		})
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: 7 * 24 * time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var events atomic.Int32
+	jwtManager.OnSecurityEvent(func(event SecurityEvent) {
+		events.Add(1)
+	})
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+	_, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+
+	// Every goroutine presents the same refresh token at once
+	const refreshes = 20
+	errs := make([]error, refreshes)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, errs[i] = jwtManager.RefreshToken(ctx, refreshToken)
+		}()
+	}
+	wg.Wait()
+
+	// Exactly one rotation wins. The first loser reports reuse and revokes the
+	// family, which later ones find revoked.
+	succeeded, reused := 0, 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrRefreshTokenReused):
+			reused++
+		default:
+			assert.Equal(t, ErrTokenBlacklisted, err)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+	assert.GreaterOrEqual(t, reused, 1)
+	assert.Equal(t, int32(reused), events.Load())
+}
+
+func TestRevokeToken(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: 7 * 24 * time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	// Revoking an access token leaves its refresh token usable
+	accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	require.NoError(t, jwtManager.RevokeToken(ctx, accessToken))
+
+	_, err = jwtManager.VerifyToken(ctx, accessToken)
+	assert.Equal(t, ErrTokenBlacklisted, err)
+	_, err = jwtManager.VerifyToken(ctx, refreshToken)
+	assert.NoError(t, err)
+
+	// Revoking a refresh token takes the access tokens minted from it along
+	rotatedAccess, rotatedRefresh, err := jwtManager.RefreshToken(ctx, refreshToken)
+	require.NoError(t, err)
+	require.NoError(t, jwtManager.RevokeToken(ctx, rotatedRefresh))
+
+	for _, token := range []string{rotatedAccess, rotatedRefresh} {
+		_, err = jwtManager.VerifyToken(ctx, token)
+		assert.Equal(t, ErrTokenBlacklisted, err)
+	}
+
+	// Unknown and already revoked tokens are not an error
+	assert.NoError(t, jwtManager.RevokeToken(ctx, "not-a-token"))
+	assert.NoError(t, jwtManager.RevokeToken(ctx, rotatedRefresh))
+}
+
+func TestStoreTimeouts(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: 7 * 24 * time.Hour,
+		Revocation: &config.RevocationConfig{
+			LookupTimeout: 20 * time.Millisecond,
+			RevokeTimeout: 20 * time.Millisecond,
+		},
+	}
+	store := newStubRevocationStore()
+	jwtManager, err := NewJWTManager(cfg, store)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+	accessToken, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+
+	store.hang = true
+
+	// A hanging store is cut off at the lookup deadline with a typed error
+	_, err = jwtManager.VerifyToken(ctx, accessToken)
+	var timeoutErr *StoreTimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, "lookup", timeoutErr.Op)
+	assert.Equal(t, 20*time.Millisecond, timeoutErr.Limit)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = jwtManager.BlacklistToken(ctx, accessToken)
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, "revoke", timeoutErr.Op)
+
+	// A caller that gives up is not reported as a store timeout
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = jwtManager.VerifyToken(canceled, accessToken)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, errors.As(err, &timeoutErr))
+}
+
+func TestRegisteredClaims(t *testing.T) {
+	cfg := &config.Config{
+		Issuer:                 "https://auth.example.com",
+		JWTAudience:            []string{"api", "reports"},
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	user := &models.User{ID: 42, Username: "testuser", Role: "user"}
+	accessToken, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+
+	claims, err := jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "https://auth.example.com", claims.Issuer)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, jwt.ClaimStrings{"api", "reports"}, claims.Audience)
+	require.NotNil(t, claims.NotBefore)
+	assert.Equal(t, claims.IssuedAt.Unix(), claims.NotBefore.Unix())
+
+	// A server sharing the secret but not the issuer or audience rejects the token
+	staging := *cfg
+	staging.Issuer = "https://auth.staging.example.com"
+	stagingManager, err := NewJWTManager(&staging, newTestRevocationStore(t))
+	require.NoError(t, err)
+	_, err = stagingManager.VerifyToken(ctx, accessToken)
+	assert.ErrorIs(t, err, ErrInvalidIssuer)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	billing := *cfg
+	billing.JWTAudience = []string{"billing"}
+	billingManager, err := NewJWTManager(&billing, newTestRevocationStore(t))
+	require.NoError(t, err)
+	_, err = billingManager.VerifyToken(ctx, accessToken)
+	assert.ErrorIs(t, err, ErrInvalidAudience)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Hand-crafted tokens exercise the remaining checks
+	sign := func(t *testing.T, registered jwt.RegisteredClaims) string {
+		t.Helper()
+		registered.Issuer = cfg.Issuer
+		registered.Audience = jwt.ClaimStrings{"api"}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
+			UserID:           42,
+			TokenID:          "crafted",
+			TokenType:        "access",
+			RegisteredClaims: registered,
+		})
+		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+		require.NoError(t, err)
+		return tokenString
+	}
+
+	now := time.Now()
+	_, err = jwtManager.VerifyToken(ctx, sign(t, jwt.RegisteredClaims{
+		Subject:   "7",
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+	}))
+	assert.ErrorIs(t, err, ErrInvalidSubject)
+
+	notYetValid := sign(t, jwt.RegisteredClaims{
+		Subject:   "42",
+		NotBefore: jwt.NewNumericDate(now.Add(time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	})
+	_, err = jwtManager.VerifyToken(ctx, notYetValid)
+	assert.ErrorIs(t, err, ErrTokenNotYetValid)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	justExpired := sign(t, jwt.RegisteredClaims{
+		Subject:   "42",
+		ExpiresAt: jwt.NewNumericDate(now.Add(-time.Minute)),
+	})
+	_, err = jwtManager.VerifyToken(ctx, justExpired)
+	assert.Equal(t, ErrTokenExpired, err)
+
+	// The leeway absorbs clock skew on both ends
+	skewed := *cfg
+	skewed.JWTLeeway = 2 * time.Minute
+	skewedManager, err := NewJWTManager(&skewed, newTestRevocationStore(t))
+	require.NoError(t, err)
+	_, err = skewedManager.VerifyToken(ctx, notYetValid)
+	assert.NoError(t, err)
+	_, err = skewedManager.VerifyToken(ctx, justExpired)
+	assert.NoError(t, err)
+}
+
+func TestPreUpgradeTokensVerifyUntilTheyExpire(t *testing.T) {
+	cfg := &config.Config{
+		Issuer:                 "https://auth.example.com",
+		JWTAudience:            []string{"api"},
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Minted the way tokens were before iss, sub, aud and kid were stamped
+	mint := func(t *testing.T, claims JWTClaims) string {
+		t.Helper()
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.JWTSecret))
+		require.NoError(t, err)
+		return tokenString
+	}
+	now := time.Now()
+	legacy := JWTClaims{
+		UserID:    42,
+		Username:  "testuser",
+		Role:      "user",
+		TokenID:   "legacy-token",
+		TokenType: "access",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTokenExpiration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	claims, err := jwtManager.VerifyToken(ctx, mint(t, legacy))
+	require.NoError(t, err)
+	assert.Equal(t, 42, claims.UserID)
+
+	// Revoking it still works
+	require.NoError(t, jwtManager.RevokeToken(ctx, mint(t, legacy)))
+	_, err = jwtManager.VerifyToken(ctx, mint(t, legacy))
+	assert.ErrorIs(t, err, ErrTokenBlacklisted)
+
+	// Once expired it's rejected like any other token
+	expired := legacy
+	expired.TokenID = "expired-legacy-token"
+	expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+	_, err = jwtManager.VerifyToken(ctx, mint(t, expired))
+	assert.Equal(t, ErrTokenExpired, err)
+
+	// Setting only one of the claims doesn't make a token legacy
+	foreign := legacy
+	foreign.TokenID = "foreign-token"
+	foreign.Issuer = "https://auth.staging.example.com"
+	_, err = jwtManager.VerifyToken(ctx, mint(t, foreign))
+	assert.ErrorIs(t, err, ErrInvalidIssuer)
 }
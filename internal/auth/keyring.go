@@ -0,0 +1,513 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// keyringManifestFile is the name of the manifest inside a keyring directory
+	keyringManifestFile = "keyring.json"
+
+	// keyringLockFile is held by whoever is changing a keyring directory
+	keyringLockFile = "keyring.lock"
+
+	// keyringLockStale is the age after which a lock file is taken to be left
+	// behind by a crashed process and is broken
+	keyringLockStale = time.Minute
+)
+
+// ErrKeyringLocked is returned by LockKeyringDir while another process holds
+// the lock of the directory
+var ErrKeyringLocked = errors.New("keyring directory is locked by another process")
+
+// Keyring holds every key that may verify tokens, with exactly one active key
+// used for signing. Retired keys stay available for verification until the
+// tokens they signed have expired, so rotating keys does not log users out.
+// A new key may be staged first: it verifies tokens and is published right
+// away but only signs from its promotion time, so every instance and JWKS
+// consumer knows it before the first token it signed shows up.
+// Keys are handed out as copies, so rotation never changes one under its user.
+type Keyring struct {
+	mu        sync.RWMutex
+	keys      map[string]*SigningKey
+	active    string
+	next      string    // staged key, empty if none
+	promoteAt time.Time // when the staged key becomes active
+	clock     Clock
+}
+
+// keyringManifest is the on-disk description of a keyring directory
+type keyringManifest struct {
+	Active    string                 `json:"active"`
+	Next      string                 `json:"next,omitempty"`
+	PromoteAt *time.Time             `json:"promote_at,omitempty"`
+	Keys      []keyringManifestEntry `json:"keys"`
+}
+
+type keyringManifestEntry struct {
+	ID          string     `json:"kid"`
+	Algorithm   string     `json:"alg"`
+	File        string     `json:"file"`
+	CreatedAt   time.Time  `json:"created_at"`
+	VerifyUntil *time.Time `json:"verify_until,omitempty"`
+}
+
+// KeyringSchedule configures background maintenance of a Keyring
+type KeyringSchedule struct {
+	Dir              string        // keyring directory to reload from and save to, optional
+	Algorithm        string        // algorithm of keys created by scheduled rotation
+	RotationInterval time.Duration // maximum age of the active key, 0 disables rotation
+	Publish          time.Duration // how long a new key only verifies before it signs
+	Retain           time.Duration // how long retired keys keep verifying tokens
+	CheckInterval    time.Duration // how often to reload and check the active key's age
+}
+
+// NewKeyring creates a keyring whose only key is active
+func NewKeyring(active *SigningKey) *Keyring {
+	return &Keyring{
+		keys:   map[string]*SigningKey{active.ID: copyKey(active)},
+		active: active.ID,
+		clock:  SystemClock,
+	}
+}
+
+// SetClock replaces the clock keys are created, retired and rotated by, e.g.
+// with a FakeClock in tests
+func (k *Keyring) SetClock(clock Clock) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.clock = clock
+}
+
+// Active returns the key used to sign new tokens, promoting the staged key
+// once its time has come
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	if !k.promotionDue() {
+		defer k.mu.RUnlock()
+		return copyKey(k.keys[k.active])
+	}
+	k.mu.RUnlock()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.promotionDue() {
+		k.active, k.next, k.promoteAt = k.next, "", time.Time{}
+	}
+	return copyKey(k.keys[k.active])
+}
+
+// Staged returns the key that becomes active at the returned time, if any
+func (k *Keyring) Staged() (*SigningKey, time.Time, bool) {
+	k.Active() // promotes a staged key that is due
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.next == "" {
+		return nil, time.Time{}, false
+	}
+	return copyKey(k.keys[k.next]), k.promoteAt, true
+}
+
+// promotionDue reports whether the staged key should be active by now.
+// The caller holds k.mu.
+func (k *Keyring) promotionDue() bool {
+	return k.next != "" && !k.clock.Now().Before(k.promoteAt)
+}
+
+// Get returns the key with the given ID if it may still verify tokens
+func (k *Keyring) Get(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[kid]
+	if !ok || isRetired(key, k.clock.Now()) {
+		return nil, false
+	}
+
+	return copyKey(key), true
+}
+
+// Keys returns every key that may still verify tokens, oldest first
+func (k *Keyring) Keys() []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := k.clock.Now()
+	keys := make([]*SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		if !isRetired(key, now) {
+			keys = append(keys, copyKey(key))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys
+}
+
+// Algorithms returns the distinct algorithms of the keys that may verify tokens
+func (k *Keyring) Algorithms() []string {
+	seen := make(map[string]bool)
+	var algorithms []string
+	for _, key := range k.Keys() {
+		if !seen[key.Algorithm()] {
+			seen[key.Algorithm()] = true
+			algorithms = append(algorithms, key.Algorithm())
+		}
+	}
+
+	return algorithms
+}
+
+// Rotate generates a new key that verifies tokens at once and becomes active
+// after the publish duration, and retires the active key, which keeps
+// verifying tokens for the retain duration after that. A publish duration of
+// zero activates the new key immediately. A key staged earlier that never
+// became active is dropped.
+func (k *Keyring) Rotate(algorithm string, publish, retain time.Duration) (*SigningKey, error) {
+	k.mu.RLock()
+	clock := k.clock
+	k.mu.RUnlock()
+
+	key, err := GenerateSigningKey(algorithm, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.promotionDue() {
+		k.active = k.next
+	} else if k.next != "" {
+		delete(k.keys, k.next)
+	}
+	k.next, k.promoteAt = "", time.Time{}
+
+	promoteAt := k.clock.Now().Add(max(publish, 0)).UTC()
+
+	// Retire a copy of the previous key, as the old one may be in use
+	if previous, ok := k.keys[k.active]; ok {
+		retired := copyKey(previous)
+		retired.VerifyUntil = promoteAt.Add(retain)
+		k.keys[retired.ID] = retired
+	}
+
+	k.keys[key.ID] = key
+	if publish > 0 {
+		k.next, k.promoteAt = key.ID, promoteAt
+	} else {
+		k.active = key.ID
+	}
+
+	return copyKey(key), nil
+}
+
+// Prune drops retired keys that can no longer verify any token
+func (k *Keyring) Prune() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.clock.Now()
+	pruned := 0
+	for kid, key := range k.keys {
+		if kid != k.active && kid != k.next && isRetired(key, now) {
+			delete(k.keys, kid)
+			pruned++
+		}
+	}
+
+	return pruned
+}
+
+// LoadKeyring reads a keyring directory written by Save
+func LoadKeyring(dir string) (*Keyring, error) {
+	data, err := os.ReadFile(filepath.Join(dir, keyringManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyring manifest: %w", err)
+	}
+
+	var manifest keyringManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("could not parse keyring manifest: %w", err)
+	}
+
+	keyring := &Keyring{
+		keys:   make(map[string]*SigningKey, len(manifest.Keys)),
+		active: manifest.Active,
+		next:   manifest.Next,
+		clock:  SystemClock,
+	}
+	if manifest.PromoteAt != nil {
+		keyring.promoteAt = *manifest.PromoteAt
+	}
+
+	for _, entry := range manifest.Keys {
+		pemData, err := os.ReadFile(filepath.Join(dir, entry.File))
+		if err != nil {
+			return nil, fmt.Errorf("could not read key %s: %w", entry.ID, err)
+		}
+
+		key, err := ParsePrivateKeyPEM(entry.Algorithm, pemData)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse key %s: %w", entry.ID, err)
+		}
+
+		key.ID = entry.ID
+		key.CreatedAt = entry.CreatedAt
+		if entry.VerifyUntil != nil {
+			key.VerifyUntil = *entry.VerifyUntil
+		}
+		keyring.keys[key.ID] = key
+	}
+
+	if _, ok := keyring.keys[keyring.active]; !ok {
+		return nil, fmt.Errorf("active key %q not found in keyring", keyring.active)
+	}
+	if _, ok := keyring.keys[keyring.next]; keyring.next != "" && !ok {
+		return nil, fmt.Errorf("staged key %q not found in keyring", keyring.next)
+	}
+
+	return keyring, nil
+}
+
+// Save writes every key as a PEM file plus a manifest into dir.
+// The manifest is replaced atomically so concurrent readers never see a partial keyring.
+func (k *Keyring) Save(dir string) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	manifest := keyringManifest{Active: k.active, Next: k.next}
+	if k.next != "" {
+		promoteAt := k.promoteAt
+		manifest.PromoteAt = &promoteAt
+	}
+	for _, key := range k.keys {
+		pemData, err := EncodePrivateKeyPEM(key)
+		if err != nil {
+			return fmt.Errorf("could not encode key %s: %w", key.ID, err)
+		}
+
+		file := key.ID + ".pem"
+		if err := os.WriteFile(filepath.Join(dir, file), pemData, 0o600); err != nil {
+			return err
+		}
+
+		entry := keyringManifestEntry{
+			ID:        key.ID,
+			Algorithm: key.Algorithm(),
+			File:      file,
+			CreatedAt: key.CreatedAt,
+		}
+		if !key.VerifyUntil.IsZero() {
+			verifyUntil := key.VerifyUntil
+			entry.VerifyUntil = &verifyUntil
+		}
+		manifest.Keys = append(manifest.Keys, entry)
+	}
+
+	sort.Slice(manifest.Keys, func(i, j int) bool {
+		return manifest.Keys[i].CreatedAt.Before(manifest.Keys[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, keyringManifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, keyringManifestFile)); err != nil {
+		return err
+	}
+
+	// Remove the files of pruned keys
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		if _, ok := k.keys[kid]; !ok {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reload replaces the keyring's contents with the keyring stored in dir
+func (k *Keyring) Reload(dir string) error {
+	loaded, err := LoadKeyring(dir)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = loaded.keys
+	k.active = loaded.active
+	k.next = loaded.next
+	k.promoteAt = loaded.promoteAt
+
+	return nil
+}
+
+// RunSchedule reloads the keyring from disk and rotates the active key once it
+// is older than the rotation interval, until ctx is done. Reloading before
+// checking lets instances sharing a directory pick up each other's rotations;
+// the publish duration of the schedule has to cover the check interval for
+// them to know a new key before it signs.
+func (k *Keyring) RunSchedule(ctx context.Context, schedule KeyringSchedule) {
+	if schedule.CheckInterval <= 0 {
+		schedule.CheckInterval = time.Minute
+	}
+
+	ticker := time.NewTicker(schedule.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := k.runScheduledCheck(schedule); err != nil {
+				log.Printf("Warning: keyring maintenance failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runScheduledCheck performs a single reload and rotation check. With a
+// directory, the rotation happens under its lock and after reloading it once
+// more, so of several instances due at once only one rotates and the others
+// pick up its key.
+func (k *Keyring) runScheduledCheck(schedule KeyringSchedule) error {
+	if schedule.Dir != "" {
+		if err := k.Reload(schedule.Dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if !k.rotationDue(schedule.RotationInterval) {
+		return nil
+	}
+
+	if schedule.Dir != "" {
+		unlock, err := LockKeyringDir(schedule.Dir)
+		if errors.Is(err, ErrKeyringLocked) {
+			// Another instance is rotating; the next check reloads its key
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := k.Reload(schedule.Dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if !k.rotationDue(schedule.RotationInterval) {
+			return nil
+		}
+	}
+
+	key, err := k.Rotate(schedule.Algorithm, schedule.Publish, schedule.Retain)
+	if err != nil {
+		return err
+	}
+	k.Prune()
+	log.Printf("Rotated signing key, new key %s (%s) signs in %s", key.ID, key.Algorithm(), schedule.Publish)
+
+	if schedule.Dir != "" {
+		return k.Save(schedule.Dir)
+	}
+
+	return nil
+}
+
+// rotationDue reports whether the active key is older than the rotation
+// interval and no new key is staged yet
+func (k *Keyring) rotationDue(interval time.Duration) bool {
+	if _, _, staged := k.Staged(); staged {
+		return false
+	}
+
+	k.mu.RLock()
+	now := k.clock.Now()
+	k.mu.RUnlock()
+
+	return interval > 0 && now.Sub(k.Active().CreatedAt) >= interval
+}
+
+// LockKeyringDir takes the lock of a keyring directory, to be held while
+// reloading, changing and saving it, and returns the function releasing it.
+// A lock older than a minute is taken to be left behind and is broken.
+func LockKeyringDir(dir string) (func(), error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, keyringLockFile)
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		info, statErr := os.Stat(path)
+		if errors.Is(statErr, os.ErrNotExist) && attempt == 0 {
+			continue // released meanwhile
+		}
+		if attempt > 0 || statErr != nil || time.Since(info.ModTime()) < keyringLockStale {
+			return nil, ErrKeyringLocked
+		}
+		log.Printf("Warning: breaking stale keyring lock %s", path)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
+
+// copyKey returns a copy of key the keyring's later changes don't affect
+func copyKey(key *SigningKey) *SigningKey {
+	if key == nil {
+		return nil
+	}
+	copied := *key
+	return &copied
+}
+
+// isRetired reports whether a retired key is past its verification window
+func isRetired(key *SigningKey, now time.Time) bool {
+	return !key.VerifyUntil.IsZero() && !now.Before(key.VerifyUntil)
+}
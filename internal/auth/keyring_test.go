@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRotation(t *testing.T) {
+	// Tokens outlive the retired key, so only the key decides whether they verify
+	cfg := &config.Config{
+		AccessTokenExpiration:  2 * time.Hour,
+		RefreshTokenExpiration: 4 * time.Hour,
+	}
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	initialKey, err := GenerateSigningKey(AlgorithmRS256, SystemClock)
+	require.NoError(t, err)
+	keyring := NewKeyring(initialKey)
+	jwtManager, err := NewJWTManagerWithKeyring(cfg, keyring, newTestRevocationStore(t))
+	require.NoError(t, err)
+	clock := NewFakeClock(time.Now())
+	jwtManager.SetClock(clock)
+	ctx := context.Background()
+
+	oldToken, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, initialKey.ID, tokenKeyID(t, oldToken))
+
+	// Rotate to a key of a different algorithm
+	newKey, err := keyring.Rotate(AlgorithmEdDSA, 0, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, newKey.ID, keyring.Active().ID)
+	assert.ElementsMatch(t, []string{AlgorithmRS256, AlgorithmEdDSA}, keyring.Algorithms())
+
+	newToken, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, newKey.ID, tokenKeyID(t, newToken))
+
+	// Tokens signed by the retired key keep working during the overlap
+	_, err = jwtManager.VerifyToken(ctx, oldToken)
+	assert.NoError(t, err)
+	_, err = jwtManager.VerifyToken(ctx, newToken)
+	assert.NoError(t, err)
+
+	// Once the retired key's window has passed it is pruned and its tokens are rejected
+	clock.Advance(time.Hour)
+	assert.Equal(t, 1, keyring.Prune())
+
+	_, err = jwtManager.VerifyToken(ctx, oldToken)
+	assert.Equal(t, ErrInvalidToken, err)
+	_, err = jwtManager.VerifyToken(ctx, newToken)
+	assert.NoError(t, err)
+}
+
+func TestUnknownKeyIDIsRejected(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	claims := JWTClaims{
+		UserID:           1,
+		TokenID:          "jti",
+		TokenType:        "access",
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "1"},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "unknown"
+	tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+	require.NoError(t, err)
+
+	_, err = jwtManager.VerifyToken(ctx, tokenString)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// Tokens without a key ID are checked against every retained key
+	delete(token.Header, "kid")
+	tokenString, err = token.SignedString([]byte(cfg.JWTSecret))
+	require.NoError(t, err)
+
+	_, err = jwtManager.VerifyToken(ctx, tokenString)
+	assert.NoError(t, err)
+
+	// Including after the key that signed them was rotated out of signing
+	_, err = jwtManager.Keyring().Rotate(AlgorithmHS256, 0, time.Hour)
+	require.NoError(t, err)
+	_, err = jwtManager.VerifyToken(ctx, tokenString)
+	assert.NoError(t, err)
+}
+
+func TestStagedKeyVerifiesBeforeItSigns(t *testing.T) {
+	cfg := &config.Config{
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+	dir := t.TempDir()
+
+	initialKey, err := GenerateSigningKey(AlgorithmES256, SystemClock)
+	require.NoError(t, err)
+	keyring := NewKeyring(initialKey)
+	clock := NewFakeClock(time.Now())
+	keyring.SetClock(clock)
+
+	staged, err := keyring.Rotate(AlgorithmES256, 10*time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, keyring.Save(dir))
+
+	// Another instance reloading the directory knows the staged key but keeps signing with the current one
+	other, err := LoadKeyring(dir)
+	require.NoError(t, err)
+	jwtManager, err := NewJWTManagerWithKeyring(cfg, other, newTestRevocationStore(t))
+	require.NoError(t, err)
+	jwtManager.SetClock(clock)
+	ctx := context.Background()
+
+	token, _, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, initialKey.ID, tokenKeyID(t, token))
+	assert.Len(t, other.PublicJWKS().Keys, 2)
+
+	// The rotating instance promotes the staged key on the same schedule
+	stagedToken, err := signWithKey(keyring, staged.ID, user)
+	require.NoError(t, err)
+	_, err = jwtManager.VerifyToken(ctx, stagedToken)
+	assert.NoError(t, err)
+
+	clock.Advance(10 * time.Minute)
+	token, _, err = jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, staged.ID, tokenKeyID(t, token))
+
+	// The previous key verifies for the retain duration after the promotion
+	clock.Advance(59 * time.Minute)
+	_, ok := other.Get(initialKey.ID)
+	assert.True(t, ok)
+	clock.Advance(time.Minute)
+	_, ok = other.Get(initialKey.ID)
+	assert.False(t, ok)
+}
+
+// signWithKey signs a minimal access token for user with the keyring's key kid
+func signWithKey(keyring *Keyring, kid string, user *models.User) (string, error) {
+	key, _ := keyring.Get(kid)
+	claims := JWTClaims{
+		UserID:           user.ID,
+		Username:         user.Username,
+		TokenID:          "staged",
+		TokenType:        "access",
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+func TestKeyringSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := GenerateSigningKey(AlgorithmES256, SystemClock)
+	require.NoError(t, err)
+	keyring := NewKeyring(key)
+	_, err = keyring.Rotate(AlgorithmHS256, 0, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, keyring.Save(dir))
+
+	loaded, err := LoadKeyring(dir)
+	require.NoError(t, err)
+	assert.Equal(t, keyring.Active().ID, loaded.Active().ID)
+	assert.Equal(t, keyring.Active().PrivateKey, loaded.Active().PrivateKey)
+	require.Len(t, loaded.Keys(), 2)
+
+	retired, ok := loaded.Get(key.ID)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), retired.VerifyUntil, time.Second)
+
+	// Scheduled rotation checks reload the directory before deciding to rotate
+	schedule := KeyringSchedule{Dir: dir, Algorithm: AlgorithmHS256, RotationInterval: time.Hour, Retain: time.Hour}
+	require.NoError(t, loaded.runScheduledCheck(schedule))
+	assert.Equal(t, keyring.Active().ID, loaded.Active().ID, "active key is younger than the rotation interval")
+
+	schedule.RotationInterval = time.Nanosecond
+	require.NoError(t, loaded.runScheduledCheck(schedule))
+	assert.NotEqual(t, keyring.Active().ID, loaded.Active().ID)
+
+	// The rotation is persisted for other instances
+	require.NoError(t, keyring.Reload(dir))
+	assert.Equal(t, loaded.Active().ID, keyring.Active().ID)
+}
+
+func TestKeyringHandsOutCopies(t *testing.T) {
+	key, err := GenerateSigningKey(AlgorithmHS256, SystemClock)
+	require.NoError(t, err)
+	keyring := NewKeyring(key)
+
+	active := keyring.Active()
+	listed := keyring.Keys()[0]
+	_, err = keyring.Rotate(AlgorithmHS256, 0, time.Hour)
+	require.NoError(t, err)
+
+	// Keys handed out before the rotation don't change under their users
+	assert.True(t, active.VerifyUntil.IsZero())
+	assert.True(t, listed.VerifyUntil.IsZero())
+	assert.True(t, key.VerifyUntil.IsZero())
+
+	// Nor do changes to them reach the keyring
+	active.VerifyUntil = time.Now().Add(-time.Second)
+	retired, ok := keyring.Get(key.ID)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), retired.VerifyUntil, time.Second)
+}
+
+func TestScheduledRotationSharingADirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := GenerateSigningKey(AlgorithmHS256, SystemClock)
+	require.NoError(t, err)
+	key.CreatedAt = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, NewKeyring(key).Save(dir))
+
+	schedule := KeyringSchedule{Dir: dir, Algorithm: AlgorithmHS256, RotationInterval: time.Hour, Retain: time.Hour}
+
+	t.Run("no rotation while another process holds the lock", func(t *testing.T) {
+		keyring, err := LoadKeyring(dir)
+		require.NoError(t, err)
+
+		unlock, err := LockKeyringDir(dir)
+		require.NoError(t, err)
+		_, err = LockKeyringDir(dir)
+		assert.ErrorIs(t, err, ErrKeyringLocked)
+
+		require.NoError(t, keyring.runScheduledCheck(schedule))
+		assert.Equal(t, key.ID, keyring.Active().ID)
+		unlock()
+	})
+
+	t.Run("stale lock is broken", func(t *testing.T) {
+		unlock, err := LockKeyringDir(dir)
+		require.NoError(t, err)
+		defer unlock()
+
+		stale := time.Now().Add(-2 * keyringLockStale)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, keyringLockFile), stale, stale))
+
+		relock, err := LockKeyringDir(dir)
+		require.NoError(t, err)
+		relock()
+	})
+
+	t.Run("instances due at once rotate only once", func(t *testing.T) {
+		const instances = 8
+		keyrings := make([]*Keyring, instances)
+		for i := range keyrings {
+			keyrings[i], err = LoadKeyring(dir)
+			require.NoError(t, err)
+		}
+
+		var wg sync.WaitGroup
+		for _, keyring := range keyrings {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, keyring.runScheduledCheck(schedule))
+			}()
+		}
+		wg.Wait()
+
+		saved, err := LoadKeyring(dir)
+		require.NoError(t, err)
+		require.Len(t, saved.Keys(), 2)
+		assert.NotEqual(t, key.ID, saved.Active().ID)
+
+		// Instances that didn't rotate pick up the new key at their next check
+		for _, keyring := range keyrings {
+			require.NoError(t, keyring.runScheduledCheck(schedule))
+			assert.Equal(t, saved.Active().ID, keyring.Active().ID)
+		}
+	})
+}
+
+// tokenKeyID returns the "kid" header of a token without verifying it
+func tokenKeyID(t *testing.T, tokenString string) string {
+	t.Helper()
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &JWTClaims{})
+	require.NoError(t, err)
+
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// hmacPEMType is the PEM block type used to store generated HMAC secrets
+const hmacPEMType = "HMAC SECRET KEY"
+
+// ErrSigningKeyUnavailable is returned when tokens must be signed but only a
+// public key was configured
+var ErrSigningKeyUnavailable = errors.New("signing key not available")
+
+// SigningKey pairs a signing method with the keys used to sign and verify tokens.
+// For HS256 both keys are the shared secret; for asymmetric algorithms the
+// private key may be nil on services that only verify tokens.
+type SigningKey struct {
+	ID         string // "kid" header value
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+
+	// CreatedAt is when the key was generated; VerifyUntil is when a retired
+	// key stops being accepted for verification (zero while it is active)
+	CreatedAt   time.Time
+	VerifyUntil time.Time
+}
+
+// Algorithm returns the JWT "alg" value of the key
+func (k *SigningKey) Algorithm() string {
+	return k.Method.Alg()
+}
+
+// CanSign reports whether the key can be used to issue tokens
+func (k *SigningKey) CanSign() bool {
+	return k.PrivateKey != nil
+}
+
+// GenerateSigningKey creates a new random key for the algorithm, created at
+// the current time of clock
+func GenerateSigningKey(algorithm string, clock Clock) (*SigningKey, error) {
+	var key *SigningKey
+
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		key = NewHMACSigningKey(secret)
+	case AlgorithmRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key = &SigningKey{Method: jwt.SigningMethodRS256, PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+	case AlgorithmES256:
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key = &SigningKey{Method: jwt.SigningMethodES256, PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+	case AlgorithmEdDSA:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key = &SigningKey{Method: jwt.SigningMethodEdDSA, PrivateKey: privateKey, PublicKey: publicKey}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	key.ID = computeKeyID(key)
+	key.CreatedAt = clock.Now().UTC()
+	return key, nil
+}
+
+// LoadSigningKey builds the signing key described by the configuration.
+// Asymmetric keys are read as PEM either inline or from a file; when only
+// a public key is given the key can verify but not sign tokens.
+func LoadSigningKey(cfg *config.Config) (*SigningKey, error) {
+	algorithm := cfg.JWTAlgorithm
+	if algorithm == "" {
+		algorithm = AlgorithmHS256
+	}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		if cfg.JWTSecret == "" {
+			return nil, errors.New("JWT secret is required for HS256")
+		}
+		key := NewHMACSigningKey([]byte(cfg.JWTSecret))
+		key.ID = computeKeyID(key)
+		return key, nil
+	case AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	privatePEM, err := readPEM(cfg.JWTPrivateKey, cfg.JWTPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private key: %w", err)
+	}
+	publicPEM, err := readPEM(cfg.JWTPublicKey, cfg.JWTPublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read public key: %w", err)
+	}
+
+	if privatePEM == nil && publicPEM == nil {
+		return nil, fmt.Errorf("a private or public key is required for %s", algorithm)
+	}
+
+	var key *SigningKey
+	if privatePEM != nil {
+		key, err = ParsePrivateKeyPEM(algorithm, privatePEM)
+	} else {
+		key, err = ParsePublicKeyPEM(algorithm, publicPEM)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = computeKeyID(key)
+	return key, nil
+}
+
+// NewHMACSigningKey creates an HS256 key from a shared secret
+func NewHMACSigningKey(secret []byte) *SigningKey {
+	return &SigningKey{
+		Method:     jwt.SigningMethodHS256,
+		PrivateKey: secret,
+		PublicKey:  secret,
+	}
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded private key for the given algorithm
+func ParsePrivateKeyPEM(algorithm string, data []byte) (*SigningKey, error) {
+	switch algorithm {
+	case AlgorithmHS256:
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != hmacPEMType {
+			return nil, errors.New("invalid HMAC secret PEM")
+		}
+		return NewHMACSigningKey(block.Bytes), nil
+	case AlgorithmRS256:
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{Method: jwt.SigningMethodRS256, PrivateKey: key, PublicKey: &key.PublicKey}, nil
+	case AlgorithmES256:
+		key, err := jwt.ParseECPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 key")
+		}
+		return &SigningKey{Method: jwt.SigningMethodES256, PrivateKey: key, PublicKey: &key.PublicKey}, nil
+	case AlgorithmEdDSA:
+		key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		edKey := key.(ed25519.PrivateKey)
+		return &SigningKey{Method: jwt.SigningMethodEdDSA, PrivateKey: edKey, PublicKey: edKey.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// ParsePublicKeyPEM parses a PEM encoded public key for the given algorithm.
+// The resulting key can only verify tokens.
+func ParsePublicKeyPEM(algorithm string, data []byte) (*SigningKey, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{Method: jwt.SigningMethodRS256, PublicKey: key}, nil
+	case AlgorithmES256:
+		key, err := jwt.ParseECPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 key")
+		}
+		return &SigningKey{Method: jwt.SigningMethodES256, PublicKey: key}, nil
+	case AlgorithmEdDSA:
+		key, err := jwt.ParseEdPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{Method: jwt.SigningMethodEdDSA, PublicKey: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// EncodePrivateKeyPEM serializes the key's private half as PEM
+func EncodePrivateKeyPEM(key *SigningKey) ([]byte, error) {
+	if !key.CanSign() {
+		return nil, ErrSigningKeyUnavailable
+	}
+
+	if secret, ok := key.PrivateKey.([]byte); ok {
+		return pem.EncodeToMemory(&pem.Block{Type: hmacPEMType, Bytes: secret}), nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// computeKeyID derives a stable key ID from the public half of the key.
+// HMAC secrets are hashed with a domain prefix so the ID reveals nothing useful.
+func computeKeyID(key *SigningKey) string {
+	var material []byte
+	if secret, ok := key.PublicKey.([]byte); ok {
+		material = append([]byte("hmac:"), secret...)
+	} else if der, err := x509.MarshalPKIXPublicKey(key.PublicKey); err == nil {
+		material = der
+	}
+
+	sum := sha256.Sum256(material)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// readPEM returns inline PEM data, or the contents of file when no inline data is set
+func readPEM(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// generateTestKeyPEM creates a key pair for the algorithm and returns both halves as PEM
+func generateTestKeyPEM(t *testing.T, algorithm string) (string, string) {
+	t.Helper()
+
+	var privateKey interface{}
+	var publicKey interface{}
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		privateKey, publicKey = key, &key.PublicKey
+	case AlgorithmES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		privateKey, publicKey = key, &key.PublicKey
+	case AlgorithmEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		privateKey, publicKey = private, public
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			privatePEM, publicPEM := generateTestKeyPEM(t, algorithm)
+
+			issuer, err := NewJWTManager(&config.Config{
+				JWTAlgorithm:           algorithm,
+				JWTPrivateKey:          privatePEM,
+				AccessTokenExpiration:  15 * time.Minute,
+				RefreshTokenExpiration: time.Hour,
+			}, newTestRevocationStore(t))
+			require.NoError(t, err)
+
+			accessToken, _, err := issuer.GenerateTokens(context.Background(), user)
+			require.NoError(t, err)
+
+			token, _, err := jwt.NewParser().ParseUnverified(accessToken, &JWTClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, algorithm, token.Header["alg"])
+
+			claims, err := issuer.VerifyToken(context.Background(), accessToken)
+			require.NoError(t, err)
+			assert.Equal(t, user.ID, claims.UserID)
+
+			// A downstream service holding only the public key can verify but not sign
+			verifier, err := NewJWTManager(&config.Config{
+				JWTAlgorithm:           algorithm,
+				JWTPublicKey:           publicPEM,
+				AccessTokenExpiration:  15 * time.Minute,
+				RefreshTokenExpiration: time.Hour,
+			}, newTestRevocationStore(t))
+			require.NoError(t, err)
+
+			_, err = verifier.VerifyToken(context.Background(), accessToken)
+			assert.NoError(t, err)
+
+			_, _, err = verifier.GenerateTokens(context.Background(), user)
+			assert.Equal(t, ErrSigningKeyUnavailable, err)
+
+			// The public key used as an HMAC secret must not be accepted
+			forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			forgedString, err := forged.SignedString([]byte(publicPEM))
+			require.NoError(t, err)
+
+			_, err = verifier.VerifyToken(context.Background(), forgedString)
+			assert.Equal(t, ErrInvalidToken, err)
+		})
+	}
+}
+
+func TestVerificationRestrictedToConfiguredAlgorithm(t *testing.T) {
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	hmacManager, err := NewJWTManager(&config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}, newTestRevocationStore(t))
+	require.NoError(t, err)
+
+	privatePEM, _ := generateTestKeyPEM(t, AlgorithmES256)
+	ecManager, err := NewJWTManager(&config.Config{
+		JWTAlgorithm:           AlgorithmES256,
+		JWTPrivateKey:          privatePEM,
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}, newTestRevocationStore(t))
+	require.NoError(t, err)
+
+	hmacToken, _, err := hmacManager.GenerateTokens(context.Background(), user)
+	require.NoError(t, err)
+	ecToken, _, err := ecManager.GenerateTokens(context.Background(), user)
+	require.NoError(t, err)
+
+	_, err = ecManager.VerifyToken(context.Background(), hmacToken)
+	assert.Equal(t, ErrInvalidToken, err)
+	_, err = hmacManager.VerifyToken(context.Background(), ecToken)
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestLoadSigningKeyErrors(t *testing.T) {
+	_, err := LoadSigningKey(&config.Config{JWTAlgorithm: "none"})
+	assert.Error(t, err)
+
+	_, err = LoadSigningKey(&config.Config{JWTAlgorithm: AlgorithmRS256})
+	assert.Error(t, err, "asymmetric algorithms need a key")
+
+	// An RSA key cannot be used for ES256
+	privatePEM, _ := generateTestKeyPEM(t, AlgorithmRS256)
+	_, err = LoadSigningKey(&config.Config{JWTAlgorithm: AlgorithmES256, JWTPrivateKey: privatePEM})
+	assert.Error(t, err)
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAccountLocked is returned for logins to a username locked after too
+	// many failed attempts
+	ErrAccountLocked = errors.New("account temporarily locked")
+
+	// ErrLoginThrottled is returned for logins attempted before the delay
+	// following a failed attempt has passed, or from a client IP locked after
+	// too many failed attempts
+	ErrLoginThrottled = errors.New("too many login attempts")
+)
+
+// LoginBlockedError is returned by LoginLimiter.Reserve for a login that may
+// not be attempted yet. It wraps ErrAccountLocked or ErrLoginThrottled.
+type LoginBlockedError struct {
+	Reason     error
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", e.Reason, e.RetryAfter)
+}
+
+func (e *LoginBlockedError) Unwrap() error {
+	return e.Reason
+}
+
+// LoginAttempts is the record of recent failed logins under one key
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// LoginAttemptStore keeps failed login records until they expire. Records of
+// a username and of a client IP live under separate keys.
+type LoginAttemptStore interface {
+	// Get returns the record of key, the zero record if there is none
+	Get(ctx context.Context, key string) (LoginAttempts, error)
+
+	// AddFailure atomically counts a failed attempt made at the given time and
+	// keeps the record for at least ttl. It returns the record as it was
+	// before, so concurrent callers each see a different count.
+	AddFailure(ctx context.Context, key string, at time.Time, ttl time.Duration) (LoginAttempts, error)
+
+	// RemoveFailure takes back a failure counted by AddFailure, if the record
+	// still has one
+	RemoveFailure(ctx context.Context, key string) error
+
+	// Lock clears the failures of key and locks it until the given time
+	Lock(ctx context.Context, key string, until time.Time) error
+
+	// Delete forgets the record of key
+	Delete(ctx context.Context, key string) error
+}
+
+// LoginLimits configures the brute-force protection of logins. Zero values
+// disable the corresponding protection.
+type LoginLimits struct {
+	MaxFailures     int           // failures of a username that lock it
+	IPMaxFailures   int           // failures from a client IP that lock it
+	Window          time.Duration // how long a failure counts after the last one
+	LockoutDuration time.Duration // how long a locked username or IP stays locked
+	DelayAfter      int           // failures of a username before delays start
+	BaseDelay       time.Duration // delay after the first delayed failure, doubled with each further one
+	MaxDelay        time.Duration // cap of the delay
+}
+
+// LoginLimiter tracks failed logins per username and per client IP, slowing
+// down and then locking out whoever keeps guessing. Usernames are tracked
+// whether or not they exist, so its answers never tell them apart.
+//
+// Every attempt is counted as failed before the password is checked, so
+// concurrent attempts can't all get past the limits before any failure is
+// recorded; a successful login takes its attempt back.
+type LoginLimiter struct {
+	store  LoginAttemptStore
+	limits LoginLimits
+	clock  Clock
+}
+
+// NewLoginLimiter creates a login limiter keeping its records in store
+func NewLoginLimiter(store LoginAttemptStore, limits LoginLimits) *LoginLimiter {
+	return &LoginLimiter{store: store, limits: limits, clock: SystemClock}
+}
+
+// SetClock replaces the clock delays and lockouts are measured with
+func (l *LoginLimiter) SetClock(clock Clock) {
+	l.clock = clock
+}
+
+// Reserve counts a login to username from ip as a failed attempt and returns
+// a *LoginBlockedError, taking the attempt back, if it may not be made now.
+// An attempt made before the wait is over restarts it. Errors of the store
+// are logged and let the login through, since the password is still checked.
+func (l *LoginLimiter) Reserve(ctx context.Context, username, ip string) error {
+	trackIP := ip != "" && l.limits.IPMaxFailures > 0
+
+	if trackIP {
+		if err := l.reserve(ctx, ipLoginKey(ip), l.limits.IPMaxFailures, ErrLoginThrottled, false); err != nil {
+			return err
+		}
+	}
+
+	if err := l.reserve(ctx, userLoginKey(username), l.limits.MaxFailures, ErrAccountLocked, true); err != nil {
+		if trackIP {
+			l.release(ctx, ipLoginKey(ip))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RecordFailure locks username and ip once the failed attempts counted by
+// Reserve reach their limits
+func (l *LoginLimiter) RecordFailure(ctx context.Context, username, ip string) error {
+	if err := l.lockIfExhausted(ctx, userLoginKey(username), l.limits.MaxFailures); err != nil {
+		return err
+	}
+	if ip != "" && l.limits.IPMaxFailures > 0 {
+		return l.lockIfExhausted(ctx, ipLoginKey(ip), l.limits.IPMaxFailures)
+	}
+	return nil
+}
+
+// RecordSuccess clears the failures of username and takes back the attempt
+// Reserve counted against ip. Earlier failures of the IP are kept, so logging
+// into an account of one's own doesn't reset them.
+func (l *LoginLimiter) RecordSuccess(ctx context.Context, username, ip string) error {
+	if err := l.store.Delete(ctx, userLoginKey(username)); err != nil {
+		return err
+	}
+	if ip != "" && l.limits.IPMaxFailures > 0 {
+		return l.store.RemoveFailure(ctx, ipLoginKey(ip))
+	}
+	return nil
+}
+
+// UnlockUser clears the failures and lockout of username
+func (l *LoginLimiter) UnlockUser(ctx context.Context, username string) error {
+	return l.store.Delete(ctx, userLoginKey(username))
+}
+
+// UnlockIP clears the failures and lockout of a client IP
+func (l *LoginLimiter) UnlockIP(ctx context.Context, ip string) error {
+	return l.store.Delete(ctx, ipLoginKey(ip))
+}
+
+// reserve counts an attempt under key and judges it by the record as it was
+// before, taking the attempt back if it is refused
+func (l *LoginLimiter) reserve(ctx context.Context, key string, maxFailures int, lockedReason error, delayed bool) error {
+	now := l.clock.Now()
+
+	before, err := l.store.AddFailure(ctx, key, now, l.limits.Window)
+	if err != nil {
+		log.Printf("Warning: failed to count login attempt of %s: %v", key, err)
+		return nil
+	}
+
+	var blocked *LoginBlockedError
+	switch {
+	case now.Before(before.LockedUntil):
+		blocked = &LoginBlockedError{Reason: lockedReason, RetryAfter: before.LockedUntil.Sub(now)}
+	case maxFailures > 0 && before.Failures >= maxFailures:
+		// Attempts still in flight used up the limit, and lock it if they fail
+		blocked = &LoginBlockedError{Reason: ErrLoginThrottled, RetryAfter: time.Second}
+	case delayed:
+		if next := before.LastFailure.Add(l.delay(before.Failures)); now.Before(next) {
+			blocked = &LoginBlockedError{Reason: ErrLoginThrottled, RetryAfter: next.Sub(now)}
+		}
+	}
+	if blocked == nil {
+		return nil
+	}
+
+	l.release(ctx, key)
+	return blocked
+}
+
+// release takes back an attempt counted under key
+func (l *LoginLimiter) release(ctx context.Context, key string) {
+	if err := l.store.RemoveFailure(ctx, key); err != nil {
+		log.Printf("Warning: failed to take back login attempt of %s: %v", key, err)
+	}
+}
+
+// lockIfExhausted locks key once its failures reach maxFailures
+func (l *LoginLimiter) lockIfExhausted(ctx context.Context, key string, maxFailures int) error {
+	if maxFailures <= 0 {
+		return nil
+	}
+
+	attempts, err := l.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if attempts.Failures < maxFailures {
+		return nil
+	}
+
+	log.Printf("--- Locked %s after %d failed logins", key, attempts.Failures)
+	return l.store.Lock(ctx, key, l.clock.Now().Add(l.limits.LockoutDuration))
+}
+
+// delay returns how long to wait after the last of the given failures: the
+// base delay once DelayAfter failures are reached, doubling with each further
+// failure up to the maximum
+func (l *LoginLimiter) delay(failures int) time.Duration {
+	start := max(l.limits.DelayAfter, 1)
+	if l.limits.BaseDelay <= 0 || failures < start {
+		return 0
+	}
+
+	delay := l.limits.BaseDelay
+	for i := start; i < failures; i++ {
+		delay *= 2
+		if l.limits.MaxDelay > 0 && delay >= l.limits.MaxDelay {
+			break
+		}
+	}
+	if l.limits.MaxDelay > 0 && delay > l.limits.MaxDelay {
+		delay = l.limits.MaxDelay
+	}
+	return delay
+}
+
+// userLoginKey is the attempt record key of a username, case-insensitive so
+// variants of a name share their failures
+func userLoginKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// ipLoginKey is the attempt record key of a client IP
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLoginLimiter(limits LoginLimits) (*LoginLimiter, *FakeClock) {
+	clock := NewFakeClock(time.Now())
+
+	store := NewMemoryLoginAttemptStore()
+	store.SetClock(clock)
+
+	limiter := NewLoginLimiter(store, limits)
+	limiter.SetClock(clock)
+	return limiter, clock
+}
+
+// retryAfter returns the RetryAfter of a *LoginBlockedError, failing otherwise
+func retryAfter(t *testing.T, err error) time.Duration {
+	t.Helper()
+	var blocked *LoginBlockedError
+	require.ErrorAs(t, err, &blocked)
+	return blocked.RetryAfter
+}
+
+// fail makes a login attempt that fails, as the login handler would
+func fail(t *testing.T, limiter *LoginLimiter, username, ip string) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, limiter.Reserve(ctx, username, ip))
+	require.NoError(t, limiter.RecordFailure(ctx, username, ip))
+}
+
+// succeed makes a login attempt that succeeds, as the login handler would
+func succeed(t *testing.T, limiter *LoginLimiter, username, ip string) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, limiter.Reserve(ctx, username, ip))
+	require.NoError(t, limiter.RecordSuccess(ctx, username, ip))
+}
+
+func TestLoginLimiterProgressiveDelays(t *testing.T) {
+	limiter, clock := newTestLoginLimiter(LoginLimits{
+		Window:     15 * time.Minute,
+		DelayAfter: 2,
+		BaseDelay:  time.Second,
+		MaxDelay:   5 * time.Second,
+	})
+	ctx := context.Background()
+	const ip = "203.0.113.7"
+
+	// The first failures cost nothing
+	fail(t, limiter, "admin", ip)
+	fail(t, limiter, "admin", ip)
+
+	// Then every failure doubles the wait, up to the maximum
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		clock.Advance(delay - time.Millisecond)
+		err := limiter.Reserve(ctx, "admin", ip)
+		assert.ErrorIs(t, err, ErrLoginThrottled)
+		assert.Equal(t, time.Millisecond, retryAfter(t, err))
+
+		// Attempting early restarts the wait, without counting as a failure
+		err = limiter.Reserve(ctx, "admin", ip)
+		assert.Equal(t, delay, retryAfter(t, err))
+
+		clock.Advance(delay)
+		fail(t, limiter, "admin", ip)
+	}
+
+	// Other usernames aren't slowed down
+	succeed(t, limiter, "user", ip)
+
+	// Failures are forgotten a window after the last one
+	clock.Advance(15 * time.Minute)
+	fail(t, limiter, "admin", ip)
+	succeed(t, limiter, "admin", ip)
+}
+
+func TestLoginLimiterLockout(t *testing.T) {
+	limiter, clock := newTestLoginLimiter(LoginLimits{
+		MaxFailures:     3,
+		Window:          15 * time.Minute,
+		LockoutDuration: 10 * time.Minute,
+	})
+	ctx := context.Background()
+
+	// Usernames are tracked whether they exist or not, so both lock alike
+	for _, username := range []string{"admin", "no-such-user"} {
+		for i := 0; i < 3; i++ {
+			fail(t, limiter, username, "203.0.113.7")
+		}
+
+		err := limiter.Reserve(ctx, username, "198.51.100.1")
+		assert.ErrorIs(t, err, ErrAccountLocked)
+		assert.Equal(t, 10*time.Minute, retryAfter(t, err))
+	}
+
+	// Letter case doesn't get around the lockout
+	assert.ErrorIs(t, limiter.Reserve(ctx, "Admin", ""), ErrAccountLocked)
+
+	// The lockout ends on its own, with a fresh count of failures
+	clock.Advance(10 * time.Minute)
+	fail(t, limiter, "admin", "")
+	fail(t, limiter, "admin", "")
+	succeed(t, limiter, "admin", "")
+
+	// Or when an admin lifts it
+	require.NoError(t, limiter.UnlockUser(ctx, "no-such-user"))
+	succeed(t, limiter, "no-such-user", "")
+}
+
+func TestLoginLimiterIPLockout(t *testing.T) {
+	limiter, _ := newTestLoginLimiter(LoginLimits{
+		MaxFailures:     10,
+		IPMaxFailures:   3,
+		Window:          15 * time.Minute,
+		LockoutDuration: 10 * time.Minute,
+	})
+	ctx := context.Background()
+	const ip = "203.0.113.7"
+
+	// Spraying passwords over many usernames locks the IP
+	fail(t, limiter, "alice", ip)
+	fail(t, limiter, "bob", ip)
+
+	// A successful login of one's own account doesn't reset the IP's
+	// failures, nor count as one
+	succeed(t, limiter, "carol", ip)
+	succeed(t, limiter, "carol", ip)
+	fail(t, limiter, "dave", ip)
+
+	err := limiter.Reserve(ctx, "erin", ip)
+	assert.ErrorIs(t, err, ErrLoginThrottled)
+	assert.Equal(t, 10*time.Minute, retryAfter(t, err))
+
+	// Other clients can still log in to the same accounts
+	succeed(t, limiter, "alice", "198.51.100.1")
+
+	require.NoError(t, limiter.UnlockIP(ctx, ip))
+	succeed(t, limiter, "erin", ip)
+}
+
+func TestLoginLimiterSuccessResetsUsername(t *testing.T) {
+	limiter, _ := newTestLoginLimiter(LoginLimits{
+		MaxFailures: 3,
+		Window:      15 * time.Minute,
+		DelayAfter:  2,
+		BaseDelay:   time.Second,
+	})
+
+	fail(t, limiter, "admin", "")
+	succeed(t, limiter, "admin", "")
+	fail(t, limiter, "admin", "")
+
+	// The earlier failure no longer counts towards delays or the lockout
+	succeed(t, limiter, "admin", "")
+}
+
+func TestLoginLimiterConcurrentAttempts(t *testing.T) {
+	limiter, _ := newTestLoginLimiter(LoginLimits{
+		MaxFailures:     3,
+		Window:          15 * time.Minute,
+		LockoutDuration: 10 * time.Minute,
+	})
+	ctx := context.Background()
+
+	// A burst of guesses can't get past the limit before any of them fails
+	const attempts = 20
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limiter.Reserve(ctx, "admin", ""); err != nil {
+				var blocked *LoginBlockedError
+				assert.ErrorAs(t, err, &blocked)
+				return
+			}
+			allowed.Add(1)
+			assert.NoError(t, limiter.RecordFailure(ctx, "admin", ""))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(3), allowed.Load())
+	assert.ErrorIs(t, limiter.Reserve(ctx, "admin", ""), ErrAccountLocked)
+}
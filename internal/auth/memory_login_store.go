@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryLoginPurgeInterval is the number of recorded failures between sweeps
+// of expired records, which bounds the memory taken by one-off usernames
+const memoryLoginPurgeInterval = 1024
+
+// MemoryLoginAttemptStore implements LoginAttemptStore in process memory.
+// Expired records are dropped when they are next read, and swept
+// periodically as failures are recorded.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	records  map[string]loginRecord
+	clock    Clock
+	failures int
+}
+
+// loginRecord is a login attempt record and the time it can be forgotten
+type loginRecord struct {
+	attempts  LoginAttempts
+	expiresAt time.Time
+}
+
+// NewMemoryLoginAttemptStore creates a new in-memory login attempt store
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		records: make(map[string]loginRecord),
+		clock:   SystemClock,
+	}
+}
+
+// SetClock replaces the clock records expire by, e.g. with a FakeClock in tests
+func (s *MemoryLoginAttemptStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Get returns the unexpired record of key
+func (s *MemoryLoginAttemptStore) Get(ctx context.Context, key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current(key).attempts, nil
+}
+
+// AddFailure counts a failed attempt and keeps the record for at least ttl,
+// returning the record as it was before
+func (s *MemoryLoginAttemptStore) AddFailure(ctx context.Context, key string, at time.Time, ttl time.Duration) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures++
+	if s.failures%memoryLoginPurgeInterval == 0 {
+		s.purgeExpired()
+	}
+
+	record := s.current(key)
+	before := record.attempts
+	record.attempts.Failures++
+	record.attempts.LastFailure = at
+	if expiresAt := s.clock.Now().Add(ttl); expiresAt.After(record.expiresAt) {
+		record.expiresAt = expiresAt
+	}
+
+	s.records[key] = record
+	return before, nil
+}
+
+// RemoveFailure takes back one failure of key, if its record still has one
+func (s *MemoryLoginAttemptStore) RemoveFailure(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.current(key)
+	if record.attempts.Failures > 0 {
+		record.attempts.Failures--
+		s.records[key] = record
+	}
+	return nil
+}
+
+// Lock clears the failures of key and keeps it locked until the given time
+func (s *MemoryLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.current(key)
+	record.attempts.Failures = 0
+	record.attempts.LockedUntil = until
+	if until.After(record.expiresAt) {
+		record.expiresAt = until
+	}
+
+	s.records[key] = record
+	return nil
+}
+
+// Delete forgets the record of key
+func (s *MemoryLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// current returns the unexpired record of key, dropping an expired one. It
+// must be called with s.mu held.
+func (s *MemoryLoginAttemptStore) current(key string) loginRecord {
+	record, ok := s.records[key]
+	if ok && !s.clock.Now().Before(record.expiresAt) {
+		delete(s.records, key)
+		return loginRecord{}
+	}
+	return record
+}
+
+// purgeExpired must be called with s.mu held
+func (s *MemoryLoginAttemptStore) purgeExpired() {
+	now := s.clock.Now()
+	for key, record := range s.records {
+		if !now.Before(record.expiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
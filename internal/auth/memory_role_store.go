@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// MemoryRoleStore implements RoleStore in process memory, for development
+// and deployments without a database
+type MemoryRoleStore struct {
+	mu        sync.RWMutex
+	roles     map[string]Role
+	userRoles map[int][]string
+}
+
+// NewMemoryRoleStore creates a new in-memory role store holding the given
+// role definitions and user assignments. Users left out hold no roles, as
+// there's no users table to fall back to.
+func NewMemoryRoleStore(roles []Role, userRoles map[int][]string) *MemoryRoleStore {
+	s := &MemoryRoleStore{
+		roles:     make(map[string]Role, len(roles)),
+		userRoles: make(map[int][]string, len(userRoles)),
+	}
+	for _, role := range roles {
+		s.roles[role.Name] = cloneRole(role)
+	}
+	for userID, assigned := range userRoles {
+		s.userRoles[userID] = slices.Clone(assigned)
+	}
+	return s
+}
+
+// Roles returns every role definition, sorted by name
+func (s *MemoryRoleStore) Roles(ctx context.Context) ([]Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roles := make([]Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, cloneRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// PutRole creates or replaces a role definition
+func (s *MemoryRoleStore) PutRole(ctx context.Context, role Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.roles[role.Name] = cloneRole(role)
+	return nil
+}
+
+// UserRoles returns the roles assigned directly to a user
+func (s *MemoryRoleStore) UserRoles(ctx context.Context, userID int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.userRoles[userID]), nil
+}
+
+// SetUserRoles replaces the roles assigned to a user
+func (s *MemoryRoleStore) SetUserRoles(ctx context.Context, userID int, roles []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Kept even when empty, like the assignment rows of PostgresRoleStore
+	s.userRoles[userID] = slices.Clone(roles)
+	return nil
+}
+
+// cloneRole copies a role so callers can't modify the stored slices
+func cloneRole(role Role) Role {
+	role.Permissions = slices.Clone(role.Permissions)
+	role.Inherits = slices.Clone(role.Inherits)
+	return role
+}
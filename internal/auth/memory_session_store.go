@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MemorySessionStore implements SessionStore in process memory. Expired
+// sessions are dropped lazily, when their user's sessions are listed.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+	byUser   map[int]map[string]struct{}
+	clock    Clock
+}
+
+// NewMemorySessionStore creates a new in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]Session),
+		byUser:   make(map[int]map[string]struct{}),
+		clock:    SystemClock,
+	}
+}
+
+// SetClock replaces the clock sessions expire by, e.g. with a FakeClock in tests
+func (s *MemorySessionStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Save creates or replaces a session, leaving an ended one ended
+func (s *MemorySessionStore) Save(ctx context.Context, session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.sessions[session.ID]; ok && !current.Active() && session.Active() {
+		return nil
+	}
+
+	s.sessions[session.ID] = session
+	if s.byUser[session.UserID] == nil {
+		s.byUser[session.UserID] = make(map[string]struct{})
+	}
+	s.byUser[session.UserID][session.ID] = struct{}{}
+	return nil
+}
+
+// Get returns an unexpired session, or nil if there is none with that ID
+func (s *MemorySessionStore) Get(ctx context.Context, sessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok || !s.clock.Now().Before(session.ExpiresAt) {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// ListByUser returns the user's unexpired sessions, oldest first
+func (s *MemorySessionStore) ListByUser(ctx context.Context, userID int) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	var sessions []Session
+	for sessionID := range s.byUser[userID] {
+		session := s.sessions[sessionID]
+		if !now.Before(session.ExpiresAt) {
+			delete(s.sessions, sessionID)
+			delete(s.byUser[userID], sessionID)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if len(s.byUser[userID]) == 0 {
+		delete(s.byUser, userID)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRevocationStoreFull is returned when the in-memory store reached its size cap
+var ErrRevocationStoreFull = errors.New("revocation store is full")
+
+// MemoryRevocationStore implements RevocationStore in process memory.
+// Entries expire together with the token they belong to and are purged by a
+// background janitor goroutine. It is intended for development, tests and
+// single-node deployments.
+type MemoryRevocationStore struct {
+	mu         sync.RWMutex
+	entries    map[string]time.Time // token ID -> expiry
+	userEpochs map[int]userEpoch
+	maxEntries int
+	clock      Clock
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// userEpoch is a per-user revocation epoch and the time it can be forgotten
+type userEpoch struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+// NewMemoryRevocationStore creates a new in-memory revocation store.
+// maxEntries caps the revoked tokens and user epochs held together, zero or
+// less disabling the cap; a cleanupInterval of zero or less disables the
+// janitor, leaving expired entries to be purged lazily when the store fills up.
+func NewMemoryRevocationStore(maxEntries int, cleanupInterval time.Duration) *MemoryRevocationStore {
+	s := &MemoryRevocationStore{
+		entries:    make(map[string]time.Time),
+		userEpochs: make(map[int]userEpoch),
+		maxEntries: maxEntries,
+		clock:      SystemClock,
+		stop:       make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go s.janitor(cleanupInterval)
+	}
+
+	return s
+}
+
+// SetClock replaces the clock entries expire by, e.g. with a FakeClock in tests
+func (s *MemoryRevocationStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Revoke stores the token ID until its TTL elapses
+func (s *MemoryRevocationStore) Revoke(ctx context.Context, token RevokedToken) error {
+	_, err := s.revoke(token, false)
+	return err
+}
+
+// RevokeIfAbsent stores the token ID unless it is already revoked
+func (s *MemoryRevocationStore) RevokeIfAbsent(ctx context.Context, token RevokedToken) (bool, error) {
+	return s.revoke(token, true)
+}
+
+// revoke stores the token ID, leaving a revoked one alone if onlyIfAbsent is set
+func (s *MemoryRevocationStore) revoke(token RevokedToken, onlyIfAbsent bool) (bool, error) {
+	if token.TTL <= 0 {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if onlyIfAbsent && s.isRevoked(token.TokenID, now) {
+		return false, nil
+	}
+	if _, exists := s.entries[token.TokenID]; !exists && !s.hasRoom(now) {
+		return false, ErrRevocationStoreFull
+	}
+
+	s.entries[token.TokenID] = now.Add(token.TTL)
+	return true, nil
+}
+
+// IsRevoked checks if a token ID is present and not yet expired
+func (s *MemoryRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.isRevoked(tokenID, s.clock.Now()), nil
+}
+
+// AreRevoked checks several token IDs under a single lock
+func (s *MemoryRevocationStore) AreRevoked(ctx context.Context, tokenIDs []string) (map[string]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.clock.Now()
+	result := make(map[string]bool, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		result[tokenID] = s.isRevoked(tokenID, now)
+	}
+
+	return result, nil
+}
+
+// List returns the IDs of all entries that have not expired yet
+func (s *MemoryRevocationStore) List(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.clock.Now()
+	tokenIDs := make([]string, 0, len(s.entries))
+	for tokenID, expiresAt := range s.entries {
+		if now.Before(expiresAt) {
+			tokenIDs = append(tokenIDs, tokenID)
+		}
+	}
+
+	return tokenIDs, nil
+}
+
+// Count returns the number of entries that have not expired yet
+func (s *MemoryRevocationStore) Count(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.clock.Now()
+	var count int64
+	for _, expiresAt := range s.entries {
+		if now.Before(expiresAt) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// RevokeUser records the user's revocation epoch until ttl elapses, never
+// moving an unexpired epoch backwards or shortening its expiry
+func (s *MemoryRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	current, exists := s.userEpochs[userID]
+	if !exists && !s.hasRoom(now) {
+		return ErrRevocationStoreFull
+	}
+
+	epoch := userEpoch{before: before, expiresAt: now.Add(ttl)}
+	if exists && now.Before(current.expiresAt) {
+		if current.before.After(epoch.before) {
+			epoch.before = current.before
+		}
+		if current.expiresAt.After(epoch.expiresAt) {
+			epoch.expiresAt = current.expiresAt
+		}
+	}
+
+	s.userEpochs[userID] = epoch
+	return nil
+}
+
+// RevokedUsers returns the IDs of all users whose epoch has not expired yet
+func (s *MemoryRevocationStore) RevokedUsers(ctx context.Context) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.clock.Now()
+	userIDs := make([]int, 0, len(s.userEpochs))
+	for userID, epoch := range s.userEpochs {
+		if now.Before(epoch.expiresAt) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	return userIDs, nil
+}
+
+// UserRevokedBefore returns the user's unexpired revocation epoch
+func (s *MemoryRevocationStore) UserRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	epoch, exists := s.userEpochs[userID]
+	if !exists || !s.clock.Now().Before(epoch.expiresAt) {
+		return time.Time{}, nil
+	}
+
+	return epoch.before, nil
+}
+
+// Close stops the background janitor
+func (s *MemoryRevocationStore) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// janitor periodically removes expired entries until the store is closed
+func (s *MemoryRevocationStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.purgeExpired(s.clock.Now())
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// hasRoom reports whether another token or user epoch fits under the size
+// cap, dropping expired ones to make room before giving up. It must be
+// called with s.mu held for writing.
+func (s *MemoryRevocationStore) hasRoom(now time.Time) bool {
+	if s.maxEntries <= 0 || len(s.entries)+len(s.userEpochs) < s.maxEntries {
+		return true
+	}
+
+	s.purgeExpired(now)
+	return len(s.entries)+len(s.userEpochs) < s.maxEntries
+}
+
+// isRevoked must be called with s.mu held
+func (s *MemoryRevocationStore) isRevoked(tokenID string, now time.Time) bool {
+	expiresAt, exists := s.entries[tokenID]
+	return exists && now.Before(expiresAt)
+}
+
+// purgeExpired must be called with s.mu held for writing
+func (s *MemoryRevocationStore) purgeExpired(now time.Time) {
+	for tokenID, expiresAt := range s.entries {
+		if !now.Before(expiresAt) {
+			delete(s.entries, tokenID)
+		}
+	}
+	for userID, epoch := range s.userEpochs {
+		if !now.Before(epoch.expiresAt) {
+			delete(s.userEpochs, userID)
+		}
+	}
+}
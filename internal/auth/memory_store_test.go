@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRevocationStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Contract", func(t *testing.T) {
+		testRevocationStoreContract(t, func(t *testing.T) RevocationStore {
+			store := NewMemoryRevocationStore(0, 0)
+			t.Cleanup(store.Close)
+			return store
+		})
+	})
+
+	t.Run("EpochsExpire", func(t *testing.T) {
+		store := NewMemoryRevocationStore(0, 0)
+		defer store.Close()
+		clock := NewFakeClock(time.Now())
+		store.SetClock(clock)
+
+		epoch := clock.Now()
+		require.NoError(t, store.RevokeUser(ctx, 1, epoch, time.Minute))
+		require.NoError(t, store.RevokeUser(ctx, 2, epoch, time.Hour))
+
+		userIDs, err := store.RevokedUsers(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []int{1, 2}, userIDs)
+
+		// Expired epochs are gone
+		clock.Advance(time.Minute)
+		before, err := store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, before.IsZero())
+
+		userIDs, err = store.RevokedUsers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []int{2}, userIDs)
+	})
+
+	t.Run("EpochExpiryIsNeverShortened", func(t *testing.T) {
+		store := NewMemoryRevocationStore(0, 0)
+		defer store.Close()
+		clock := NewFakeClock(time.Now())
+		store.SetClock(clock)
+
+		epoch := clock.Now()
+		require.NoError(t, store.RevokeUser(ctx, 1, epoch, time.Hour))
+		require.NoError(t, store.RevokeUser(ctx, 1, epoch.Add(time.Second), time.Minute))
+
+		clock.Advance(2 * time.Minute)
+		before, err := store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, epoch.Add(time.Second).Equal(before))
+
+		// An expired epoch is replaced outright
+		clock.Advance(time.Hour)
+		require.NoError(t, store.RevokeUser(ctx, 1, epoch, time.Minute))
+		before, err = store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, epoch.Equal(before))
+	})
+
+	t.Run("SizeCap", func(t *testing.T) {
+		store := NewMemoryRevocationStore(2, 0)
+		defer store.Close()
+		clock := NewFakeClock(time.Now())
+		store.SetClock(clock)
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", TTL: 50 * time.Millisecond}))
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "b", TTL: time.Minute}))
+
+		// Store is full of live entries
+		err := store.Revoke(ctx, RevokedToken{TokenID: "c", TTL: time.Minute})
+		assert.Equal(t, ErrRevocationStoreFull, err)
+
+		// Re-revoking an existing entry does not need extra room
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "b", TTL: time.Minute}))
+
+		// Once an entry expires its slot is reclaimed
+		clock.Advance(50 * time.Millisecond)
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "c", TTL: time.Minute}))
+	})
+
+	t.Run("SizeCapCountsUserEpochs", func(t *testing.T) {
+		store := NewMemoryRevocationStore(2, 0)
+		defer store.Close()
+		clock := NewFakeClock(time.Now())
+		store.SetClock(clock)
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", TTL: time.Minute}))
+		require.NoError(t, store.RevokeUser(ctx, 1, clock.Now(), 50*time.Millisecond))
+
+		err := store.RevokeUser(ctx, 2, clock.Now(), time.Minute)
+		assert.Equal(t, ErrRevocationStoreFull, err)
+		err = store.Revoke(ctx, RevokedToken{TokenID: "b", TTL: time.Minute})
+		assert.Equal(t, ErrRevocationStoreFull, err)
+
+		// Moving an existing epoch does not need extra room
+		require.NoError(t, store.RevokeUser(ctx, 1, clock.Now(), 50*time.Millisecond))
+
+		// Once the epoch expires its slot is reclaimed
+		clock.Advance(50 * time.Millisecond)
+		require.NoError(t, store.RevokeUser(ctx, 2, clock.Now(), time.Minute))
+	})
+
+	t.Run("JanitorPurgesExpiredEntries", func(t *testing.T) {
+		store := NewMemoryRevocationStore(0, 10*time.Millisecond)
+		defer store.Close()
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", TTL: 20 * time.Millisecond}))
+
+		assert.Eventually(t, func() bool {
+			store.mu.RLock()
+			defer store.mu.RUnlock()
+			return len(store.entries) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidPolicy is returned for policy files that can't be compiled
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// Policy effects
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Policy is a list of rules deciding which authenticated requests may proceed.
+// Rules are evaluated in order and the first one matching the request decides;
+// requests no rule matches get the default effect.
+type Policy struct {
+	Default string       `yaml:"default" json:"default"` // allow (the default) or deny
+	DryRun  bool         `yaml:"dry_run" json:"dry_run"` // log denials instead of enforcing them
+	Rules   []PolicyRule `yaml:"rules" json:"rules"`
+}
+
+// PolicyRule applies its effect to the requests it targets whose attributes
+// satisfy every condition
+type PolicyRule struct {
+	Name    string            `yaml:"name" json:"name"`
+	Effect  string            `yaml:"effect" json:"effect"`
+	Methods []string          `yaml:"methods" json:"methods"` // empty matches any method
+	Paths   []string          `yaml:"paths" json:"paths"`     // route patterns like /api/users/:id, or prefixes like /api/admin/*
+	When    []PolicyCondition `yaml:"when" json:"when"`
+}
+
+// PolicyCondition tests one request attribute, such as claims.role,
+// param.id, query.page, header.X-Tenant, method, path or ip. Exactly one
+// operator must be set. Attributes with several values, like the aud claim,
+// satisfy equals and in when any of their values does.
+type PolicyCondition struct {
+	Attribute       string   `yaml:"attribute" json:"attribute"`
+	Equals          *string  `yaml:"equals" json:"equals"`
+	NotEquals       *string  `yaml:"not_equals" json:"not_equals"`
+	In              []string `yaml:"in" json:"in"`
+	EqualsAttribute string   `yaml:"equals_attribute" json:"equals_attribute"`
+	CIDR            []string `yaml:"cidr" json:"cidr"`
+	Exists          *bool    `yaml:"exists" json:"exists"`
+
+	networks []*net.IPNet
+}
+
+// PolicyRequest holds the attributes of a request that rules are evaluated against
+type PolicyRequest struct {
+	Claims   *JWTClaims
+	Method   string
+	Path     string            // the requested path
+	Route    string            // the matched route pattern, e.g. /api/users/:id
+	Params   map[string]string // route parameters
+	Query    map[string][]string
+	Header   map[string][]string
+	ClientIP string
+}
+
+// PolicyDecision is the outcome of evaluating a request
+type PolicyDecision struct {
+	Allowed bool
+	Rule    string // name of the deciding rule, empty for the default effect
+}
+
+// PolicyEngine evaluates requests against a compiled policy
+type PolicyEngine struct {
+	policy Policy
+}
+
+// LoadPolicyFile reads a policy from a YAML or, for .json files, JSON file
+func LoadPolicyFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&policy)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&policy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPolicy, path, err)
+	}
+
+	return &policy, nil
+}
+
+// NewPolicyEngine compiles a policy, rejecting unknown effects and malformed conditions
+func NewPolicyEngine(policy Policy) (*PolicyEngine, error) {
+	if policy.Default == "" {
+		policy.Default = EffectAllow
+	}
+	if policy.Default != EffectAllow && policy.Default != EffectDeny {
+		return nil, fmt.Errorf("%w: unknown default effect %q", ErrInvalidPolicy, policy.Default)
+	}
+
+	rules := make([]PolicyRule, len(policy.Rules))
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return nil, fmt.Errorf("%w: %s: unknown effect %q", ErrInvalidPolicy, rule.Name, rule.Effect)
+		}
+
+		conditions := make([]PolicyCondition, len(rule.When))
+		for j, condition := range rule.When {
+			compiled, err := compileCondition(condition)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPolicy, rule.Name, err)
+			}
+			conditions[j] = compiled
+		}
+		rule.When = conditions
+		rules[i] = rule
+	}
+	policy.Rules = rules
+
+	return &PolicyEngine{policy: policy}, nil
+}
+
+// DryRun reports whether denials should only be logged
+func (e *PolicyEngine) DryRun() bool {
+	return e.policy.DryRun
+}
+
+// Evaluate decides the request by its first matching rule
+func (e *PolicyEngine) Evaluate(req PolicyRequest) PolicyDecision {
+	attributes := newPolicyAttributes(req)
+
+	for _, rule := range e.policy.Rules {
+		if !rule.targets(req) {
+			continue
+		}
+
+		matched := true
+		for _, condition := range rule.When {
+			if !condition.holds(attributes) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return PolicyDecision{Allowed: rule.Effect == EffectAllow, Rule: rule.Name}
+		}
+	}
+
+	return PolicyDecision{Allowed: e.policy.Default == EffectAllow}
+}
+
+// targets reports whether the rule applies to the request's method and path
+func (r *PolicyRule) targets(req PolicyRequest) bool {
+	if len(r.Methods) > 0 && !slices.ContainsFunc(r.Methods, func(method string) bool {
+		return strings.EqualFold(method, req.Method)
+	}) {
+		return false
+	}
+
+	if len(r.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range r.Paths {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(req.Path, prefix) {
+				return true
+			}
+		} else if pattern == req.Route || pattern == req.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// compileCondition checks that exactly one operator is set and parses CIDR ranges
+func compileCondition(c PolicyCondition) (PolicyCondition, error) {
+	if c.Attribute == "" {
+		return c, errors.New("condition without attribute")
+	}
+
+	operators := 0
+	for _, set := range []bool{c.Equals != nil, c.NotEquals != nil, c.In != nil, c.EqualsAttribute != "", c.CIDR != nil, c.Exists != nil} {
+		if set {
+			operators++
+		}
+	}
+	if operators != 1 {
+		return c, fmt.Errorf("condition on %s needs exactly one operator", c.Attribute)
+	}
+
+	for _, cidr := range c.CIDR {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return c, err
+		}
+		c.networks = append(c.networks, network)
+	}
+
+	return c, nil
+}
+
+// holds reports whether the condition is satisfied by the request attributes
+func (c *PolicyCondition) holds(attributes policyAttributes) bool {
+	values := attributes.lookup(c.Attribute)
+
+	switch {
+	case c.Exists != nil:
+		return (len(values) > 0) == *c.Exists
+	case c.Equals != nil:
+		return slices.Contains(values, *c.Equals)
+	case c.NotEquals != nil:
+		return !slices.Contains(values, *c.NotEquals)
+	case c.In != nil:
+		return slices.ContainsFunc(values, func(value string) bool {
+			return slices.Contains(c.In, value)
+		})
+	case c.EqualsAttribute != "":
+		others := attributes.lookup(c.EqualsAttribute)
+		return slices.ContainsFunc(values, func(value string) bool {
+			return slices.Contains(others, value)
+		})
+	case c.CIDR != nil:
+		return slices.ContainsFunc(values, func(value string) bool {
+			ip := net.ParseIP(value)
+			return ip != nil && slices.ContainsFunc(c.networks, func(network *net.IPNet) bool {
+				return network.Contains(ip)
+			})
+		})
+	}
+
+	return false
+}
+
+// policyAttributes resolves attribute names to their values for one request
+type policyAttributes struct {
+	req    PolicyRequest
+	claims map[string]interface{}
+}
+
+func newPolicyAttributes(req PolicyRequest) policyAttributes {
+	attributes := policyAttributes{req: req}
+
+	// Going through JSON exposes every claim, custom ones included, by its claim name
+	if req.Claims != nil {
+		if data, err := json.Marshal(req.Claims); err == nil {
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			_ = decoder.Decode(&attributes.claims)
+		}
+	}
+
+	return attributes
+}
+
+// lookup returns the values of an attribute, or none if the request doesn't have it
+func (a policyAttributes) lookup(name string) []string {
+	switch name {
+	case "method":
+		return []string{a.req.Method}
+	case "path":
+		return []string{a.req.Path}
+	case "route":
+		return []string{a.req.Route}
+	case "ip":
+		return []string{a.req.ClientIP}
+	}
+
+	source, key, _ := strings.Cut(name, ".")
+	switch source {
+	case "claims":
+		if key == "scope" {
+			// Scopes are tested one by one, like the elements of a list
+			scope, _ := a.claims[key].(string)
+			return strings.Fields(scope)
+		}
+		return claimValues(a.claims[key])
+	case "param":
+		if value, ok := a.req.Params[key]; ok {
+			return []string{value}
+		}
+	case "query":
+		return a.req.Query[key]
+	case "header":
+		return a.req.Header[textproto.CanonicalMIMEHeaderKey(key)]
+	}
+
+	return nil
+}
+
+// claimValues renders a decoded claim as strings, one per element for lists
+func claimValues(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case json.Number:
+		return []string{value.String()}
+	case bool:
+		return []string{strconv.FormatBool(value)}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			values = append(values, claimValues(item)...)
+		}
+		return values
+	}
+
+	return nil
+}
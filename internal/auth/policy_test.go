@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPolicyFile(t *testing.T) {
+	// The example shipped with the server must stay loadable
+	policy, err := LoadPolicyFile(filepath.Join("..", "..", "config", "policy.example.yaml"))
+	require.NoError(t, err)
+	_, err = NewPolicyEngine(*policy)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	jsonPath := filepath.Join(dir, "policy.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{
+		"default": "deny",
+		"dry_run": true,
+		"rules": [{"name": "reads", "effect": "allow", "methods": ["GET"]}]
+	}`), 0o600))
+	policy, err = LoadPolicyFile(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, "deny", policy.Default)
+	assert.True(t, policy.DryRun)
+	assert.Len(t, policy.Rules, 1)
+
+	// Typos are reported instead of silently ignored
+	typoPath := filepath.Join(dir, "typo.yaml")
+	require.NoError(t, os.WriteFile(typoPath, []byte("rules:\n  - name: x\n    efect: deny\n"), 0o600))
+	_, err = LoadPolicyFile(typoPath)
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+}
+
+func TestNewPolicyEngineRejectsInvalidPolicies(t *testing.T) {
+	value := "x"
+	tests := map[string]Policy{
+		"unknown default": {Default: "maybe"},
+		"unknown effect":  {Rules: []PolicyRule{{Effect: "permit"}}},
+		"no operator":     {Rules: []PolicyRule{{Effect: EffectDeny, When: []PolicyCondition{{Attribute: "ip"}}}}},
+		"two operators":   {Rules: []PolicyRule{{Effect: EffectDeny, When: []PolicyCondition{{Attribute: "ip", Equals: &value, In: []string{"y"}}}}}},
+		"no attribute":    {Rules: []PolicyRule{{Effect: EffectDeny, When: []PolicyCondition{{Equals: &value}}}}},
+		"bad cidr":        {Rules: []PolicyRule{{Effect: EffectDeny, When: []PolicyCondition{{Attribute: "ip", CIDR: []string{"10.0.0.0/33"}}}}}},
+	}
+
+	for name, policy := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewPolicyEngine(policy)
+			assert.ErrorIs(t, err, ErrInvalidPolicy)
+		})
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy, err := LoadPolicyFile(filepath.Join("..", "..", "config", "policy.example.yaml"))
+	require.NoError(t, err)
+	engine, err := NewPolicyEngine(*policy)
+	require.NoError(t, err)
+
+	user := &JWTClaims{UserID: 7, Username: "testuser", Role: "user"}
+	admin := &JWTClaims{UserID: 1, Username: "admin", Role: "admin"}
+
+	readUser := func(claims *JWTClaims, id string) PolicyRequest {
+		return PolicyRequest{
+			Claims:   claims,
+			Method:   "GET",
+			Path:     "/api/users/" + id,
+			Route:    "/api/users/:id",
+			Params:   map[string]string{"id": id},
+			ClientIP: "203.0.113.9",
+		}
+	}
+
+	decision := engine.Evaluate(readUser(user, "7"))
+	assert.Equal(t, PolicyDecision{Allowed: true, Rule: "read-own-user-record"}, decision)
+
+	decision = engine.Evaluate(readUser(user, "8"))
+	assert.Equal(t, PolicyDecision{Allowed: false, Rule: "read-other-user-records"}, decision)
+
+	decision = engine.Evaluate(readUser(admin, "8"))
+	assert.Equal(t, PolicyDecision{Allowed: true, Rule: "admins-read-any-user-record"}, decision)
+
+	// Other methods on the same route fall through to the default
+	req := readUser(user, "8")
+	req.Method = "DELETE"
+	assert.Equal(t, PolicyDecision{Allowed: true}, engine.Evaluate(req))
+
+	dashboard := func(ip string) PolicyRequest {
+		return PolicyRequest{Claims: admin, Method: "GET", Path: "/api/admin/dashboard", Route: "/api/admin/dashboard", ClientIP: ip}
+	}
+	assert.True(t, engine.Evaluate(dashboard("10.1.2.3")).Allowed)
+	assert.True(t, engine.Evaluate(dashboard("::1")).Allowed)
+	assert.Equal(t, PolicyDecision{Allowed: false, Rule: "admin-from-elsewhere"}, engine.Evaluate(dashboard("203.0.113.9")))
+}
+
+func TestPolicyAttributes(t *testing.T) {
+	claims := &JWTClaims{
+		UserID: 7,
+		Role:   "user",
+		Scope:  "profile read",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience: jwt.ClaimStrings{"api", "reports"},
+		},
+		Extra: map[string]interface{}{"tenant_id": 42, "beta": true},
+	}
+
+	allowIf := func(condition PolicyCondition) bool {
+		engine, err := NewPolicyEngine(Policy{
+			Default: EffectDeny,
+			Rules:   []PolicyRule{{Name: "test", Effect: EffectAllow, When: []PolicyCondition{condition}}},
+		})
+		require.NoError(t, err)
+
+		return engine.Evaluate(PolicyRequest{
+			Claims:   claims,
+			Method:   "POST",
+			Path:     "/api/reports",
+			Route:    "/api/reports",
+			Query:    map[string][]string{"format": {"csv"}},
+			Header:   map[string][]string{"X-Tenant": {"42"}},
+			ClientIP: "192.168.1.5",
+		}).Allowed
+	}
+
+	str := func(s string) *string { return &s }
+	yes, no := true, false
+
+	assert.True(t, allowIf(PolicyCondition{Attribute: "claims.scope", Equals: str("read")}))
+	assert.False(t, allowIf(PolicyCondition{Attribute: "claims.scope", Equals: str("write")}))
+	assert.True(t, allowIf(PolicyCondition{Attribute: "claims.aud", In: []string{"reports", "billing"}}))
+	assert.True(t, allowIf(PolicyCondition{Attribute: "claims.tenant_id", Equals: str("42")}))
+	assert.True(t, allowIf(PolicyCondition{Attribute: "claims.beta", Equals: str("true")}))
+	assert.True(t, allowIf(PolicyCondition{Attribute: "header.x-tenant", EqualsAttribute: "claims.tenant_id"}))
+	assert.True(t, allowIf(PolicyCondition{Attribute: "query.format", Equals: str("csv")}))
+	assert.True(t, allowIf(PolicyCondition{Attribute: "method", NotEquals: str("DELETE")}))
+	assert.True(t, allowIf(PolicyCondition{Attribute: "ip", CIDR: []string{"192.168.0.0/16"}}))
+	assert.False(t, allowIf(PolicyCondition{Attribute: "ip", CIDR: []string{"10.0.0.0/8"}}))
+	assert.True(t, allowIf(PolicyCondition{Attribute: "claims.missing", Exists: &no}))
+	assert.False(t, allowIf(PolicyCondition{Attribute: "param.id", Exists: &yes}))
+}
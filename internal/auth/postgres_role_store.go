@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// PostgresRoleStore implements RoleStore on the roles, role_permissions,
+// role_inherits, user_roles and user_role_assignments tables
+type PostgresRoleStore struct {
+	db *sql.DB
+}
+
+// NewPostgresRoleStore creates a new PostgreSQL role store
+func NewPostgresRoleStore(db *sql.DB) *PostgresRoleStore {
+	return &PostgresRoleStore{db: db}
+}
+
+// Roles returns every role definition, sorted by name
+func (s *PostgresRoleStore) Roles(ctx context.Context) ([]Role, error) {
+	query := `
+		SELECT r.name,
+			COALESCE(ARRAY(SELECT permission FROM role_permissions WHERE role = r.name ORDER BY permission), '{}'),
+			COALESCE(ARRAY(SELECT parent FROM role_inherits WHERE role = r.name ORDER BY parent), '{}')
+		FROM roles r
+		ORDER BY r.name
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.Name, pq.Array(&role.Permissions), pq.Array(&role.Inherits)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// PutRole creates or replaces a role definition in a single transaction
+func (s *PostgresRoleStore) PutRole(ctx context.Context, role Role) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO roles (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, []interface{}{role.Name}},
+		{`DELETE FROM role_permissions WHERE role = $1`, []interface{}{role.Name}},
+		{`DELETE FROM role_inherits WHERE role = $1`, []interface{}{role.Name}},
+		{`INSERT INTO role_permissions (role, permission) SELECT $1, unnest($2::text[])`, []interface{}{role.Name, pq.Array(role.Permissions)}},
+		{`INSERT INTO role_inherits (role, parent) SELECT $1, unnest($2::text[])`, []interface{}{role.Name, pq.Array(role.Inherits)}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UserRoles returns the roles assigned directly to a user. Users never
+// assigned any, such as those created after the roles were carried over, hold
+// their role in the users table; users assigned no roles hold none.
+func (s *PostgresRoleStore) UserRoles(ctx context.Context, userID int) ([]string, error) {
+	query := `
+		SELECT role FROM user_roles WHERE user_id = $1
+		UNION ALL
+		SELECT u.role FROM users u JOIN roles r ON r.name = u.role
+		WHERE u.id = $1 AND NOT EXISTS (SELECT 1 FROM user_role_assignments WHERE user_id = $1)
+		ORDER BY role
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// SetUserRoles replaces the roles assigned to a user in a single transaction,
+// recording the assignment so an empty one overrides the users table
+func (s *PostgresRoleStore) SetUserRoles(ctx context.Context, userID int, roles []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	assigned := `
+		INSERT INTO user_role_assignments (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET assigned_at = CURRENT_TIMESTAMP
+	`
+	if _, err := tx.ExecContext(ctx, assigned, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO user_roles (user_id, role) SELECT $1, unnest($2::text[])`
+	if _, err := tx.ExecContext(ctx, query, userID, pq.Array(roles)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresRoleStoreFallsBackToTheUsersTable(t *testing.T) {
+	ctx := context.Background()
+	_, sqlDB := newTestPostgresStore(t)
+	store := NewPostgresRoleStore(sqlDB)
+
+	// A user created after the roles were carried over has no assignment rows
+	var userID int
+	require.NoError(t, sqlDB.QueryRow(
+		`INSERT INTO users (username, email, password, role) VALUES ('newcomer', 'newcomer@example.com', 'x', 'editor') RETURNING id`,
+	).Scan(&userID))
+	t.Cleanup(func() { sqlDB.Exec("DELETE FROM users WHERE id = $1", userID) })
+
+	roles, err := store.UserRoles(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"editor"}, roles)
+
+	// Assignments take over once there are any
+	require.NoError(t, store.SetUserRoles(ctx, userID, []string{"admin", "user"}))
+	roles, err = store.UserRoles(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "user"}, roles)
+}
+
+func TestPostgresRoleStoreDemotesToNoRoles(t *testing.T) {
+	ctx := context.Background()
+	_, sqlDB := newTestPostgresStore(t)
+	authorizer := NewAuthorizer(NewPostgresRoleStore(sqlDB), time.Minute)
+
+	var userID int
+	require.NoError(t, sqlDB.QueryRow(
+		`INSERT INTO users (username, email, password, role) VALUES ('demoted', 'demoted@example.com', 'x', 'admin') RETURNING id`,
+	).Scan(&userID))
+	t.Cleanup(func() { sqlDB.Exec("DELETE FROM users WHERE id = $1", userID) })
+
+	allowed, err := authorizer.HasPermission(ctx, userID, "users:manage")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// An empty assignment doesn't fall back to the users table
+	require.NoError(t, authorizer.SetUserRoles(ctx, userID, []string{}))
+
+	allowed, err = authorizer.HasPermission(ctx, userID, "users:manage")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	primary, roles, err := authorizer.TokenRoles(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "", primary)
+	assert.Empty(t, roles)
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresRevocationStore implements RevocationStore on the revoked_tokens and
+// user_revocation_epochs tables. Unlike Redis without persistence, revocations
+// survive restarts and can be audited with plain SQL. Expired rows are deleted
+// by a background purge.
+//
+// Expiry times are computed and compared by the database clock alone, so a
+// skewed server clock can't end revocations early or late. Queries are bounded
+// by the caller's context.
+type PostgresRevocationStore struct {
+	db       *sql.DB
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPostgresRevocationStore creates a new PostgreSQL revocation store.
+// A purgeInterval of zero or less disables the background purge.
+func NewPostgresRevocationStore(db *sql.DB, purgeInterval time.Duration) *PostgresRevocationStore {
+	s := &PostgresRevocationStore{
+		db:   db,
+		stop: make(chan struct{}),
+	}
+
+	if purgeInterval > 0 {
+		go s.purgeLoop(purgeInterval)
+	}
+
+	return s
+}
+
+// Revoke inserts the token ID, extending the expiry if it is already revoked
+func (s *PostgresRevocationStore) Revoke(ctx context.Context, token RevokedToken) error {
+	if token.TTL <= 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at, reason)
+		VALUES ($1, NULLIF($2, 0), NOW() + make_interval(secs => $3), $4)
+		ON CONFLICT (jti) DO UPDATE
+		SET expires_at = GREATEST(revoked_tokens.expires_at, EXCLUDED.expires_at)
+	`
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		token.TokenID,
+		token.UserID,
+		token.TTL.Seconds(),
+		token.Reason,
+	)
+
+	return err
+}
+
+// RevokeIfAbsent inserts the token ID unless an unexpired row holds it. An
+// expired row not purged yet is taken over; the row count tells the winner.
+func (s *PostgresRevocationStore) RevokeIfAbsent(ctx context.Context, token RevokedToken) (bool, error) {
+	if token.TTL <= 0 {
+		return false, nil
+	}
+
+	query := `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at, reason)
+		VALUES ($1, NULLIF($2, 0), NOW() + make_interval(secs => $3), $4)
+		ON CONFLICT (jti) DO UPDATE
+		SET user_id = EXCLUDED.user_id, expires_at = EXCLUDED.expires_at,
+			reason = EXCLUDED.reason, revoked_at = CURRENT_TIMESTAMP
+		WHERE revoked_tokens.expires_at <= NOW()
+	`
+
+	result, err := s.db.ExecContext(
+		ctx,
+		query,
+		token.TokenID,
+		token.UserID,
+		token.TTL.Seconds(),
+		token.Reason,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// IsRevoked checks if an unexpired row exists for the token ID
+func (s *PostgresRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM revoked_tokens
+			WHERE jti = $1 AND expires_at > NOW()
+		)
+	`
+
+	var revoked bool
+	if err := s.db.QueryRowContext(ctx, query, tokenID).Scan(&revoked); err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+// AreRevoked checks several token IDs with a single query
+func (s *PostgresRevocationStore) AreRevoked(ctx context.Context, tokenIDs []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(tokenIDs))
+	if len(tokenIDs) == 0 {
+		return result, nil
+	}
+
+	query := `
+		SELECT jti FROM revoked_tokens
+		WHERE jti = ANY($1) AND expires_at > NOW()
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(tokenIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for _, tokenID := range tokenIDs {
+		result[tokenID] = false
+	}
+	for rows.Next() {
+		var tokenID string
+		if err := rows.Scan(&tokenID); err != nil {
+			return nil, err
+		}
+		result[tokenID] = true
+	}
+
+	return result, rows.Err()
+}
+
+// List returns the IDs of all unexpired revocations
+func (s *PostgresRevocationStore) List(ctx context.Context) ([]string, error) {
+	query := "SELECT jti FROM revoked_tokens WHERE expires_at > NOW()"
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokenIDs []string
+	for rows.Next() {
+		var tokenID string
+		if err := rows.Scan(&tokenID); err != nil {
+			return nil, err
+		}
+		tokenIDs = append(tokenIDs, tokenID)
+	}
+
+	return tokenIDs, rows.Err()
+}
+
+// Count returns the number of unexpired revocations
+func (s *PostgresRevocationStore) Count(ctx context.Context) (int64, error) {
+	query := "SELECT COUNT(*) FROM revoked_tokens WHERE expires_at > NOW()"
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, query).Scan(&count)
+
+	return count, err
+}
+
+// RevokeUser upserts the user's revocation epoch, never moving it backwards
+func (s *PostgresRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO user_revocation_epochs (user_id, revoked_before, expires_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = GREATEST(user_revocation_epochs.revoked_before, EXCLUDED.revoked_before),
+			expires_at = GREATEST(user_revocation_epochs.expires_at, EXCLUDED.expires_at)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID, before, ttl.Seconds())
+
+	return err
+}
+
+// UserRevokedBefore reads the user's unexpired revocation epoch
+func (s *PostgresRevocationStore) UserRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	query := `
+		SELECT revoked_before FROM user_revocation_epochs
+		WHERE user_id = $1 AND expires_at > NOW()
+	`
+
+	var before time.Time
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&before)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return before, nil
+}
+
+// RevokedUsers returns the IDs of all users with an unexpired revocation epoch
+func (s *PostgresRevocationStore) RevokedUsers(ctx context.Context) ([]int, error) {
+	query := "SELECT user_id FROM user_revocation_epochs WHERE expires_at > NOW()"
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// Purge deletes expired rows and returns how many were removed
+func (s *PostgresRevocationStore) Purge(ctx context.Context) (int64, error) {
+	var purged int64
+	for _, query := range []string{
+		"DELETE FROM revoked_tokens WHERE expires_at <= NOW()",
+		"DELETE FROM user_revocation_epochs WHERE expires_at <= NOW()",
+	} {
+		result, err := s.db.ExecContext(ctx, query)
+		if err != nil {
+			return purged, err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return purged, err
+		}
+		purged += rows
+	}
+
+	return purged, nil
+}
+
+// Close stops the background purge
+func (s *PostgresRevocationStore) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// purgeLoop periodically deletes expired rows until the store is closed
+func (s *PostgresRevocationStore) purgeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// A purge is abandoned rather than run into the next one
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			purged, err := s.Purge(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Warning: failed to purge expired revoked tokens: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d expired revoked tokens", purged)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPostgresStore returns a store on the scratch database named by
+// POSTGRES_TEST_DSN, migrated and emptied, skipping the test without one
+func newTestPostgresStore(t *testing.T) (*PostgresRevocationStore, *sql.DB) {
+	t.Helper()
+
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	sqlDB, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	require.NoError(t, (&db.PostgresDB{DB: sqlDB}).RunMigrations("../../migrations"))
+	_, err = sqlDB.Exec("TRUNCATE revoked_tokens, user_revocation_epochs")
+	require.NoError(t, err)
+
+	store := NewPostgresRevocationStore(sqlDB, 0)
+	t.Cleanup(store.Close)
+	return store, sqlDB
+}
+
+func TestPostgresRevocationStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Contract", func(t *testing.T) {
+		testRevocationStoreContract(t, func(t *testing.T) RevocationStore {
+			store, _ := newTestPostgresStore(t)
+			return store
+		})
+	})
+
+	t.Run("ExpiryFollowsTheDatabaseClock", func(t *testing.T) {
+		store, sqlDB := newTestPostgresStore(t)
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", TTL: time.Hour}))
+		require.NoError(t, store.RevokeUser(ctx, 1, time.Now(), time.Hour))
+
+		for _, query := range []string{
+			"SELECT EXTRACT(EPOCH FROM expires_at - NOW()) FROM revoked_tokens WHERE jti = 'a'",
+			"SELECT EXTRACT(EPOCH FROM expires_at - NOW()) FROM user_revocation_epochs WHERE user_id = 1",
+		} {
+			var remaining float64
+			require.NoError(t, sqlDB.QueryRow(query).Scan(&remaining))
+			assert.InDelta(t, time.Hour.Seconds(), remaining, 5)
+		}
+	})
+
+	t.Run("RevokingAgainNeverShortensTheExpiry", func(t *testing.T) {
+		store, sqlDB := newTestPostgresStore(t)
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", TTL: time.Hour}))
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", TTL: time.Minute}))
+
+		var remaining float64
+		require.NoError(t, sqlDB.QueryRow("SELECT EXTRACT(EPOCH FROM expires_at - NOW()) FROM revoked_tokens WHERE jti = 'a'").Scan(&remaining))
+		assert.Greater(t, remaining, time.Minute.Seconds())
+	})
+
+	t.Run("RevokeIfAbsent", func(t *testing.T) {
+		store, sqlDB := newTestPostgresStore(t)
+
+		revoked, err := store.RevokeIfAbsent(ctx, RevokedToken{TokenID: "a", TTL: time.Minute})
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		revoked, err = store.RevokeIfAbsent(ctx, RevokedToken{TokenID: "a", TTL: time.Minute})
+		require.NoError(t, err)
+		assert.False(t, revoked, "already revoked")
+
+		// An expired row the purge hasn't deleted yet is taken over
+		_, err = sqlDB.Exec("INSERT INTO revoked_tokens (jti, expires_at) VALUES ('b', NOW() - INTERVAL '1 second')")
+		require.NoError(t, err)
+		revoked, err = store.RevokeIfAbsent(ctx, RevokedToken{TokenID: "b", TTL: time.Minute})
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		isRevoked, err := store.IsRevoked(ctx, "b")
+		require.NoError(t, err)
+		assert.True(t, isRevoked)
+	})
+
+	t.Run("PurgeDeletesExpiredRows", func(t *testing.T) {
+		store, sqlDB := newTestPostgresStore(t)
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "live", TTL: time.Hour}))
+		_, err := sqlDB.Exec("INSERT INTO revoked_tokens (jti, expires_at) VALUES ('expired', NOW() - INTERVAL '1 second')")
+		require.NoError(t, err)
+		_, err = sqlDB.Exec("INSERT INTO user_revocation_epochs (user_id, revoked_before, expires_at) VALUES (1, NOW(), NOW() - INTERVAL '1 second')")
+		require.NoError(t, err)
+
+		purged, err := store.Purge(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), purged)
+
+		count, err := store.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("QueriesAreBoundedByTheCallersContext", func(t *testing.T) {
+		store, _ := newTestPostgresStore(t)
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := store.IsRevoked(canceled, "a")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+	ErrRoleCycle   = errors.New("role inheritance cycle")
+
+	// ErrRoleStoreUnavailable is returned when the roles of a user being
+	// issued tokens can't be looked up
+	ErrRoleStoreUnavailable = errors.New("role store unavailable")
+)
+
+// Role grants its permissions, and those of every role it inherits, to the users holding it
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Inherits    []string `json:"inherits,omitempty"`
+}
+
+// DefaultRoles is the admin ⊇ editor ⊇ user hierarchy seeded by the migrations
+var DefaultRoles = []Role{
+	{Name: "user", Permissions: []string{"profile:read", "reports:read"}},
+	{Name: "editor", Permissions: []string{"reports:write"}, Inherits: []string{"user"}},
+	{Name: "admin", Permissions: []string{"admin:dashboard", "users:manage"}, Inherits: []string{"editor"}},
+}
+
+// RoleStore persists role definitions and the roles assigned to each user
+type RoleStore interface {
+	// Roles returns every role definition
+	Roles(ctx context.Context) ([]Role, error)
+
+	// PutRole creates or replaces a role definition
+	PutRole(ctx context.Context, role Role) error
+
+	// UserRoles returns the roles assigned directly to a user. Users never
+	// assigned roles may hold a default one, such as their role in the users
+	// table, but an assignment of no roles always means none.
+	UserRoles(ctx context.Context, userID int) ([]string, error)
+
+	// SetUserRoles replaces the roles assigned to a user
+	SetUserRoles(ctx context.Context, userID int, roles []string) error
+}
+
+// maxCachedUsers bounds the role assignments an Authorizer keeps in memory
+const maxCachedUsers = 10000
+
+// Authorizer answers permission checks from a RoleStore. Roles are looked up
+// on every check rather than read from the token, so a role change applies to
+// tokens already issued; caching bounds that delay to cacheTTL on other
+// instances and removes it entirely on the instance making the change.
+type Authorizer struct {
+	store    RoleStore
+	cacheTTL time.Duration
+
+	mu          sync.Mutex
+	clock       Clock
+	roles       map[string]Role
+	rolesExpiry time.Time
+	rolesGen    uint64 // bumped by PutRole, so a load racing it isn't cached
+	userRoles   *lruCache[[]string]
+}
+
+// NewAuthorizer creates a new authorizer. A cacheTTL of zero or less reads
+// the store on every check.
+func NewAuthorizer(store RoleStore, cacheTTL time.Duration) *Authorizer {
+	return &Authorizer{
+		store:     store,
+		cacheTTL:  cacheTTL,
+		clock:     SystemClock,
+		userRoles: newLRUCache[[]string](maxCachedUsers),
+	}
+}
+
+// SetClock replaces the clock cached lookups age by, e.g. with a FakeClock in tests
+func (a *Authorizer) SetClock(clock Clock) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clock = clock
+	a.userRoles.setClock(clock)
+}
+
+// Roles returns the user's roles, including every role they inherit
+func (a *Authorizer) Roles(ctx context.Context, userID int) ([]string, error) {
+	definitions, assigned, err := a.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	effective := expandRoles(definitions, assigned)
+	names := make([]string, 0, len(effective))
+	for name := range effective {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// TokenRoles returns the user's roles, including every role they inherit, and
+// the one their tokens name in the role claim: the assigned role inheriting
+// the most others, the first by name among equals. Users without roles get an
+// empty role claim.
+func (a *Authorizer) TokenRoles(ctx context.Context, userID int) (string, []string, error) {
+	definitions, assigned, err := a.load(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	primary, reach := "", 0
+	for _, name := range assigned {
+		if _, ok := definitions[name]; !ok {
+			continue
+		}
+		n := len(expandRoles(definitions, []string{name}))
+		if n > reach || (n == reach && name < primary) {
+			primary, reach = name, n
+		}
+	}
+
+	effective := expandRoles(definitions, assigned)
+	names := make([]string, 0, len(effective))
+	for name := range effective {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return primary, names, nil
+}
+
+// Permissions returns every permission granted to the user through their roles
+func (a *Authorizer) Permissions(ctx context.Context, userID int) ([]string, error) {
+	definitions, assigned, err := a.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make([]string, 0)
+	for name := range expandRoles(definitions, assigned) {
+		for _, permission := range definitions[name].Permissions {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// HasPermission reports whether any of the user's roles grants the permission
+func (a *Authorizer) HasPermission(ctx context.Context, userID int, permission string) (bool, error) {
+	permissions, err := a.Permissions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(permissions, permission), nil
+}
+
+// SetUserRoles replaces the user's roles, effective immediately on this instance
+func (a *Authorizer) SetUserRoles(ctx context.Context, userID int, roles []string) error {
+	definitions, err := a.definitions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range roles {
+		if _, ok := definitions[name]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownRole, name)
+		}
+	}
+
+	if err := a.store.SetUserRoles(ctx, userID, roles); err != nil {
+		return err
+	}
+
+	a.userRoles.remove(strconv.Itoa(userID))
+	return nil
+}
+
+// PutRole creates or replaces a role definition, effective immediately on this instance
+func (a *Authorizer) PutRole(ctx context.Context, role Role) error {
+	definitions, err := a.definitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	updated := make(map[string]Role, len(definitions)+1)
+	for name, definition := range definitions {
+		updated[name] = definition
+	}
+	updated[role.Name] = role
+
+	for _, parent := range role.Inherits {
+		if _, ok := updated[parent]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownRole, parent)
+		}
+	}
+	if inheritsFrom(updated, role.Inherits, role.Name) {
+		return fmt.Errorf("%w: %q", ErrRoleCycle, role.Name)
+	}
+
+	if err := a.store.PutRole(ctx, role); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.roles = nil
+	a.rolesGen++
+	a.mu.Unlock()
+	return nil
+}
+
+// load returns the role definitions and the roles assigned to the user
+func (a *Authorizer) load(ctx context.Context, userID int) (map[string]Role, []string, error) {
+	definitions, err := a.definitions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := strconv.Itoa(userID)
+	if assigned, ok := a.userRoles.get(key); ok {
+		return definitions, assigned, nil
+	}
+
+	// SetUserRoles bumps the generation, so a read racing it isn't cached
+	gen := a.userRoles.generation(key)
+	assigned, err := a.store.UserRoles(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.userRoles.setIfUnchanged(key, assigned, a.cacheTTL, gen)
+	return definitions, assigned, nil
+}
+
+// definitions returns the role definitions by name, cached for cacheTTL. The
+// store is read without holding a.mu, so a slow query doesn't stall every
+// check served from the cache.
+func (a *Authorizer) definitions(ctx context.Context) (map[string]Role, error) {
+	a.mu.Lock()
+	if a.roles != nil && a.clock.Now().Before(a.rolesExpiry) {
+		roles := a.roles
+		a.mu.Unlock()
+		return roles, nil
+	}
+	gen := a.rolesGen
+	a.mu.Unlock()
+
+	roles, err := a.store.Roles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make(map[string]Role, len(roles))
+	for _, role := range roles {
+		definitions[role.Name] = role
+	}
+
+	a.mu.Lock()
+	if a.rolesGen == gen {
+		a.roles = definitions
+		a.rolesExpiry = a.clock.Now().Add(a.cacheTTL)
+	}
+	a.mu.Unlock()
+	return definitions, nil
+}
+
+// expandRoles returns the assigned roles and every role they inherit,
+// ignoring unknown roles and stopping at cycles
+func expandRoles(definitions map[string]Role, assigned []string) map[string]bool {
+	effective := make(map[string]bool)
+	pending := slices.Clone(assigned)
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		role, ok := definitions[name]
+		if !ok || effective[name] {
+			continue
+		}
+		effective[name] = true
+		pending = append(pending, role.Inherits...)
+	}
+	return effective
+}
+
+// inheritsFrom reports whether target is reachable from the given roles
+func inheritsFrom(definitions map[string]Role, roles []string, target string) bool {
+	return expandRoles(definitions, roles)[target]
+}
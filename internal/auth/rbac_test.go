@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizerRoleHierarchy(t *testing.T) {
+	store := NewMemoryRoleStore(DefaultRoles, map[int][]string{
+		1: {"admin"},
+		2: {"user"},
+		3: {"editor", "user"},
+	})
+	authorizer := NewAuthorizer(store, time.Minute)
+	ctx := context.Background()
+
+	roles, err := authorizer.Roles(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin", "editor", "user"}, roles)
+
+	permissions, err := authorizer.Permissions(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin:dashboard", "profile:read", "reports:read", "reports:write", "users:manage"}, permissions)
+
+	permissions, err = authorizer.Permissions(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"profile:read", "reports:read", "reports:write"}, permissions)
+
+	allowed, err := authorizer.HasPermission(ctx, 2, "reports:read")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = authorizer.HasPermission(ctx, 2, "reports:write")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// Users without roles get no permissions
+	permissions, err = authorizer.Permissions(ctx, 99)
+	require.NoError(t, err)
+	assert.Empty(t, permissions)
+}
+
+func TestAuthorizerChangesApplyImmediately(t *testing.T) {
+	store := NewMemoryRoleStore(DefaultRoles, map[int][]string{2: {"user"}})
+	authorizer := NewAuthorizer(store, time.Hour)
+	ctx := context.Background()
+
+	allowed, err := authorizer.HasPermission(ctx, 2, "reports:write")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// Promoting the user is visible despite the cached lookup above
+	require.NoError(t, authorizer.SetUserRoles(ctx, 2, []string{"editor"}))
+	allowed, err = authorizer.HasPermission(ctx, 2, "reports:write")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// So is granting a role a new permission
+	require.NoError(t, authorizer.PutRole(ctx, Role{
+		Name:        "editor",
+		Permissions: []string{"reports:write", "reports:publish"},
+		Inherits:    []string{"user"},
+	}))
+	allowed, err = authorizer.HasPermission(ctx, 2, "reports:publish")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// And removing every role
+	require.NoError(t, authorizer.SetUserRoles(ctx, 2, nil))
+	allowed, err = authorizer.HasPermission(ctx, 2, "reports:read")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestAuthorizerCachesLookups(t *testing.T) {
+	store := NewMemoryRoleStore(DefaultRoles, map[int][]string{2: {"user"}})
+	authorizer := NewAuthorizer(store, time.Hour)
+	ctx := context.Background()
+
+	_, err := authorizer.Permissions(ctx, 2)
+	require.NoError(t, err)
+
+	// Changes made behind the authorizer's back, e.g. by another instance, wait for the cache TTL
+	require.NoError(t, store.SetUserRoles(ctx, 2, []string{"admin"}))
+	allowed, err := authorizer.HasPermission(ctx, 2, "users:manage")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	uncached := NewAuthorizer(store, 0)
+	allowed, err = uncached.HasPermission(ctx, 2, "users:manage")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestAuthorizerValidatesRoles(t *testing.T) {
+	authorizer := NewAuthorizer(NewMemoryRoleStore(DefaultRoles, nil), time.Minute)
+	ctx := context.Background()
+
+	err := authorizer.SetUserRoles(ctx, 1, []string{"superuser"})
+	assert.ErrorIs(t, err, ErrUnknownRole)
+
+	err = authorizer.PutRole(ctx, Role{Name: "auditor", Inherits: []string{"ghost"}})
+	assert.ErrorIs(t, err, ErrUnknownRole)
+
+	// user inheriting admin would make admin ⊇ editor ⊇ user ⊇ admin
+	err = authorizer.PutRole(ctx, Role{Name: "user", Permissions: []string{"profile:read"}, Inherits: []string{"admin"}})
+	assert.ErrorIs(t, err, ErrRoleCycle)
+
+	err = authorizer.PutRole(ctx, Role{Name: "auditor", Inherits: []string{"auditor"}})
+	assert.ErrorIs(t, err, ErrRoleCycle)
+
+	require.NoError(t, authorizer.PutRole(ctx, Role{Name: "auditor", Permissions: []string{"audit:read"}, Inherits: []string{"user"}}))
+	require.NoError(t, authorizer.SetUserRoles(ctx, 1, []string{"auditor"}))
+
+	roles, err := authorizer.Roles(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"auditor", "user"}, roles)
+}
+
+// slowRoleStore holds every read of the role definitions until released
+type slowRoleStore struct {
+	*MemoryRoleStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *slowRoleStore) Roles(ctx context.Context) ([]Role, error) {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.MemoryRoleStore.Roles(ctx)
+}
+
+func TestAuthorizerLoadsWithoutHoldingItsLock(t *testing.T) {
+	store := &slowRoleStore{
+		MemoryRoleStore: NewMemoryRoleStore(DefaultRoles, map[int][]string{1: {"user"}}),
+		entered:         make(chan struct{}, 2),
+		release:         make(chan struct{}),
+	}
+	authorizer := NewAuthorizer(store, time.Hour)
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := authorizer.Roles(context.Background(), 1)
+			assert.NoError(t, err)
+		}()
+	}
+
+	// Both lookups reach the store while the first one is still waiting on it
+	for range 2 {
+		select {
+		case <-store.entered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("a lookup waited for another one's query")
+		}
+	}
+	close(store.release)
+	wg.Wait()
+}
+
+// pausedUserRoleStore holds the next read of a user's roles, after it read
+// them, until released
+type pausedUserRoleStore struct {
+	*MemoryRoleStore
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *pausedUserRoleStore) UserRoles(ctx context.Context, userID int) ([]string, error) {
+	roles, err := s.MemoryRoleStore.UserRoles(ctx, userID)
+	if s.read != nil {
+		close(s.read)
+		<-s.release
+		s.read = nil
+	}
+	return roles, err
+}
+
+func TestAuthorizerDoesNotCacheLookupsRacingRoleChanges(t *testing.T) {
+	store := &pausedUserRoleStore{
+		MemoryRoleStore: NewMemoryRoleStore(DefaultRoles, map[int][]string{2: {"user"}}),
+		read:            make(chan struct{}),
+		release:         make(chan struct{}),
+	}
+	authorizer := NewAuthorizer(store, time.Hour)
+	ctx := context.Background()
+
+	// A lookup reads the old roles, then the user is promoted before it caches them
+	read := store.read
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := authorizer.Roles(ctx, 2)
+		assert.NoError(t, err)
+	}()
+	<-read
+	require.NoError(t, authorizer.SetUserRoles(ctx, 2, []string{"admin"}))
+	close(store.release)
+	<-done
+
+	allowed, err := authorizer.HasPermission(ctx, 2, "users:manage")
+	require.NoError(t, err)
+	assert.True(t, allowed, "the lookup racing the promotion was cached")
+}
+
+func TestTokensFollowAssignedRoles(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+		RoleScopes: map[string][]string{
+			"admin":  {"admin"},
+			"editor": {"write"},
+			"user":   {"profile", "read"},
+		},
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	authorizer := NewAuthorizer(NewMemoryRoleStore(DefaultRoles, map[int][]string{1: {"user", "admin"}}), time.Minute)
+	jwtManager.SetAuthorizer(authorizer)
+	ctx := context.Background()
+
+	// The users table still says "user"
+	user := &models.User{ID: 1, Username: "alice", Role: "user"}
+	accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+
+	claims, err := jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Role)
+	assert.ElementsMatch(t, []string{"admin", "write", "profile", "read"}, claims.Scopes())
+
+	// Demoted users keep only what their roles still grant when refreshing
+	require.NoError(t, authorizer.SetUserRoles(ctx, 1, []string{"user"}))
+
+	_, _, err = jwtManager.RefreshScopedToken(ctx, refreshToken, []string{"admin"})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+
+	accessToken, refreshToken, err = jwtManager.RefreshToken(ctx, refreshToken)
+	require.NoError(t, err)
+	for _, token := range []string{accessToken, refreshToken} {
+		claims, err = jwtManager.VerifyToken(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, "user", claims.Role)
+		assert.Equal(t, []string{"profile", "read"}, claims.Scopes())
+	}
+
+	// Promotion takes a new login, as refreshes never widen the scopes
+	require.NoError(t, authorizer.SetUserRoles(ctx, 1, []string{"editor"}))
+	accessToken, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+	require.NoError(t, err)
+	claims, err = jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "editor", claims.Role)
+	assert.Equal(t, []string{"profile", "read"}, claims.Scopes())
+}
+
+func TestTokensOfUsersDemotedToNoRoles(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+		RoleScopes: map[string][]string{
+			"editor": {"write"},
+			"user":   {"profile", "read"},
+		},
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	authorizer := NewAuthorizer(NewMemoryRoleStore(DefaultRoles, map[int][]string{7: {"editor"}}), time.Minute)
+	jwtManager.SetAuthorizer(authorizer)
+	ctx := context.Background()
+
+	// The users table still says "editor" after the demotion
+	user := &models.User{ID: 7, Username: "demoted", Role: "editor"}
+	require.NoError(t, authorizer.SetUserRoles(ctx, 7, []string{}))
+
+	allowed, err := authorizer.HasPermission(ctx, 7, "profile:read")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// New tokens agree with the permission checks
+	accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	for _, token := range []string{accessToken, refreshToken} {
+		claims, err := jwtManager.VerifyToken(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, "", claims.Role)
+		assert.Empty(t, claims.Scopes())
+	}
+
+	// So do users never assigned roles, as there's no users table to fall back to
+	accessToken, _, err = jwtManager.GenerateTokens(ctx, &models.User{ID: 8, Username: "newcomer", Role: "editor"})
+	require.NoError(t, err)
+	claims, err := jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "", claims.Role)
+	assert.Empty(t, claims.Scopes())
+
+	allowed, err = authorizer.HasPermission(ctx, 8, "profile:read")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisLoginAttemptsPrefix is prepended to every login attempt record key
+const redisLoginAttemptsPrefix = "login_attempts:"
+
+// RedisLoginAttemptStore implements LoginAttemptStore on Redis hashes
+// expiring with their records, so every instance sees the same failures
+type RedisLoginAttemptStore struct {
+	client *redis.Client
+	clock  Clock
+}
+
+// NewRedisLoginAttemptStore creates a new Redis-backed login attempt store
+func NewRedisLoginAttemptStore(client *redis.Client) *RedisLoginAttemptStore {
+	return &RedisLoginAttemptStore{client: client, clock: SystemClock}
+}
+
+// SetClock replaces the clock lockouts are measured with, e.g. with a
+// FakeClock in tests
+func (s *RedisLoginAttemptStore) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+// Get returns the record of key, the zero record if there is none
+func (s *RedisLoginAttemptStore) Get(ctx context.Context, key string) (LoginAttempts, error) {
+	fields, err := s.client.HGetAll(ctx, redisLoginAttemptsPrefix+key).Result()
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+	return parseLoginAttempts(fields), nil
+}
+
+// addFailureScript returns the record as it was before counting a failed
+// attempt, and extends its expiry, never shortening that of a lockout. It
+// runs atomically, so concurrent callers each see a different count and no
+// record is ever left without an expiry.
+var addFailureScript = redis.NewScript(`
+local fields = redis.call('HGETALL', KEYS[1])
+redis.call('HINCRBY', KEYS[1], 'failures', 1)
+redis.call('HSET', KEYS[1], 'last_failure', ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return fields
+`)
+
+// AddFailure counts a failed attempt and keeps the record for at least ttl,
+// returning the record as it was before
+func (s *RedisLoginAttemptStore) AddFailure(ctx context.Context, key string, at time.Time, ttl time.Duration) (LoginAttempts, error) {
+	values, err := addFailureScript.Run(ctx, s.client, []string{redisLoginAttemptsPrefix + key}, at.UnixNano(), ttl.Milliseconds()).StringSlice()
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		fields[values[i]] = values[i+1]
+	}
+	return parseLoginAttempts(fields), nil
+}
+
+// removeFailureScript decrements the failures of a record that still has
+// some, without creating one that already expired
+var removeFailureScript = redis.NewScript(`
+local failures = tonumber(redis.call('HGET', KEYS[1], 'failures') or '0')
+if failures > 0 then
+	redis.call('HINCRBY', KEYS[1], 'failures', -1)
+end
+return failures
+`)
+
+// RemoveFailure takes back one failure of key, if its record still has one
+func (s *RedisLoginAttemptStore) RemoveFailure(ctx context.Context, key string) error {
+	return removeFailureScript.Run(ctx, s.client, []string{redisLoginAttemptsPrefix + key}).Err()
+}
+
+// Lock clears the failures of key and keeps it locked until the given time
+func (s *RedisLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	redisKey := redisLoginAttemptsPrefix + key
+
+	remaining, err := s.client.PTTL(ctx, redisKey).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisKey, "failures", 0, "locked_until", until.UnixNano())
+		if lockout := until.Sub(s.clock.Now()); lockout > remaining {
+			pipe.PExpire(ctx, redisKey, lockout)
+		}
+		return nil
+	})
+	return err
+}
+
+// Delete forgets the record of key
+func (s *RedisLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, redisLoginAttemptsPrefix+key).Err()
+}
+
+// parseLoginAttempts reads a record from the fields of its hash
+func parseLoginAttempts(fields map[string]string) LoginAttempts {
+	var attempts LoginAttempts
+	attempts.Failures, _ = strconv.Atoi(fields["failures"])
+	if nanos, err := strconv.ParseInt(fields["last_failure"], 10, 64); err == nil {
+		attempts.LastFailure = time.Unix(0, nanos)
+	}
+	if nanos, err := strconv.ParseInt(fields["locked_until"], 10, 64); err == nil {
+		attempts.LockedUntil = time.Unix(0, nanos)
+	}
+	return attempts
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisLoginAttemptStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("AddFailureReturnsThePreviousRecord", func(t *testing.T) {
+		store := NewRedisLoginAttemptStore(newTestRedisClient(t))
+		first := time.Now()
+
+		previous, err := store.AddFailure(ctx, "alice", first, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, LoginAttempts{}, previous)
+
+		previous, err = store.AddFailure(ctx, "alice", first.Add(time.Second), time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 1, previous.Failures)
+		assert.True(t, first.Equal(previous.LastFailure), "want %s, got %s", first, previous.LastFailure)
+
+		attempts, err := store.Get(ctx, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, 2, attempts.Failures)
+	})
+
+	t.Run("ConcurrentFailuresEachSeeADifferentCount", func(t *testing.T) {
+		store := NewRedisLoginAttemptStore(newTestRedisClient(t))
+
+		const attempts = 20
+		var mu sync.Mutex
+		var counts []int
+		var wg sync.WaitGroup
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				previous, err := store.AddFailure(ctx, "alice", time.Now(), time.Hour)
+				assert.NoError(t, err)
+
+				mu.Lock()
+				counts = append(counts, previous.Failures)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		sort.Ints(counts)
+		for i, count := range counts {
+			assert.Equal(t, i, count)
+		}
+	})
+
+	t.Run("FailuresNeverShortenALockout", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisLoginAttemptStore(client)
+
+		_, err := store.AddFailure(ctx, "alice", time.Now(), time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, store.Lock(ctx, "alice", time.Now().Add(time.Hour)))
+
+		ttl, err := client.PTTL(ctx, redisLoginAttemptsPrefix+"alice").Result()
+		require.NoError(t, err)
+		assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 5)
+
+		_, err = store.AddFailure(ctx, "alice", time.Now(), time.Minute)
+		require.NoError(t, err)
+		ttl, err = client.PTTL(ctx, redisLoginAttemptsPrefix+"alice").Result()
+		require.NoError(t, err)
+		assert.Greater(t, ttl, time.Minute)
+
+		attempts, err := store.Get(ctx, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, 1, attempts.Failures, "the lockout cleared earlier failures")
+		assert.True(t, attempts.LockedUntil.After(time.Now()))
+	})
+
+	t.Run("RemoveFailureStopsAtZero", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisLoginAttemptStore(client)
+
+		// An expired record isn't brought back
+		require.NoError(t, store.RemoveFailure(ctx, "alice"))
+		exists, err := client.Exists(ctx, redisLoginAttemptsPrefix+"alice").Result()
+		require.NoError(t, err)
+		assert.Zero(t, exists)
+
+		_, err = store.AddFailure(ctx, "alice", time.Now(), time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, store.RemoveFailure(ctx, "alice"))
+		require.NoError(t, store.RemoveFailure(ctx, "alice"))
+
+		attempts, err := store.Get(ctx, "alice")
+		require.NoError(t, err)
+		assert.Zero(t, attempts.Failures)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := NewRedisLoginAttemptStore(newTestRedisClient(t))
+
+		_, err := store.AddFailure(ctx, "alice", time.Now(), time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, store.Delete(ctx, "alice"))
+
+		attempts, err := store.Get(ctx, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, LoginAttempts{}, attempts)
+	})
+}
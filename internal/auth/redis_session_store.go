@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisSessionPrefix is prepended to every session ID stored in Redis
+const redisSessionPrefix = "session:"
+
+// redisUserSessionsPrefix is prepended to the user ID of the sorted set
+// indexing a user's sessions by creation time
+const redisUserSessionsPrefix = "user_sessions:"
+
+// RedisSessionStore implements SessionStore on Redis keys expiring with their sessions
+type RedisSessionStore struct {
+	client *redis.Client
+	clock  Clock
+}
+
+// NewRedisSessionStore creates a new Redis-backed session store
+func NewRedisSessionStore(client *redis.Client) *RedisSessionStore {
+	return &RedisSessionStore{client: client, clock: SystemClock}
+}
+
+// SetClock replaces the clock the TTLs of sessions are computed with, e.g.
+// with a FakeClock in tests
+func (s *RedisSessionStore) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+// saveSessionScript stores a session and indexes it under its user, unless
+// it would replace an ended session with an active one. The index lives as
+// long as the user's longest-lived session.
+var saveSessionScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current and ARGV[5] == '1' and cjson.decode(current)['ended_at'] ~= ARGV[6] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[4])
+if redis.call('PTTL', KEYS[2]) < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// zeroEndedAt is how the end of an active session is encoded
+var zeroEndedAt, _ = time.Time{}.MarshalText()
+
+// Save stores the session as JSON until it expires and indexes it under its
+// user, leaving an ended one ended
+func (s *RedisSessionStore) Save(ctx context.Context, session Session) error {
+	ttl := session.ExpiresAt.Sub(s.clock.Now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	active := 0
+	if session.Active() {
+		active = 1
+	}
+
+	keys := []string{redisSessionPrefix + session.ID, redisUserSessionsPrefix + strconv.Itoa(session.UserID)}
+	return saveSessionScript.Run(ctx, s.client, keys,
+		data, max(ttl.Milliseconds(), 1), session.CreatedAt.UnixNano(), session.ID, active, string(zeroEndedAt)).Err()
+}
+
+// Get returns a session, or nil if there is none with that ID
+func (s *RedisSessionStore) Get(ctx context.Context, sessionID string) (*Session, error) {
+	data, err := s.client.Get(ctx, redisSessionPrefix+sessionID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListByUser returns the user's unexpired sessions, oldest first, pruning the
+// index of sessions that have expired
+func (s *RedisSessionStore) ListByUser(ctx context.Context, userID int) ([]Session, error) {
+	indexKey := redisUserSessionsPrefix + strconv.Itoa(userID)
+
+	sessionIDs, err := s.client.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil || len(sessionIDs) == 0 {
+		return nil, err
+	}
+
+	keys := make([]string, len(sessionIDs))
+	for i, sessionID := range sessionIDs {
+		keys[i] = redisSessionPrefix + sessionID
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	var expired []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, sessionIDs[i])
+			continue
+		}
+
+		var session Session
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if len(expired) > 0 {
+		if err := s.client.ZRem(ctx, indexKey, expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return sessions, nil
+}
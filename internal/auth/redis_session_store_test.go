@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisSessionStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("SaveGetAndList", func(t *testing.T) {
+		store := NewRedisSessionStore(newTestRedisClient(t))
+		now := time.Now().Truncate(time.Second)
+
+		require.NoError(t, store.Save(ctx, Session{ID: "expired", UserID: 1, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}))
+		require.NoError(t, store.Save(ctx, Session{ID: "newer", UserID: 1, Device: "iPhone", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+		require.NoError(t, store.Save(ctx, Session{ID: "older", UserID: 1, CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)}))
+		require.NoError(t, store.Save(ctx, Session{ID: "other", UserID: 2, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+		session, err := store.Get(ctx, "expired")
+		require.NoError(t, err)
+		assert.Nil(t, session)
+
+		session, err = store.Get(ctx, "newer")
+		require.NoError(t, err)
+		require.NotNil(t, session)
+		assert.Equal(t, "iPhone", session.Device)
+		assert.True(t, now.Equal(session.CreatedAt))
+
+		sessions, err := store.ListByUser(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, "older", sessions[0].ID)
+		assert.Equal(t, "newer", sessions[1].ID)
+	})
+
+	t.Run("EndedSessionsKeepTheirEnd", func(t *testing.T) {
+		store := NewRedisSessionStore(newTestRedisClient(t))
+		now := time.Now().Truncate(time.Second)
+
+		require.NoError(t, store.Save(ctx, Session{ID: "a", UserID: 1, CreatedAt: now, ExpiresAt: now.Add(time.Hour), EndedAt: now, EndReason: SessionSignedOut}))
+
+		session, err := store.Get(ctx, "a")
+		require.NoError(t, err)
+		require.NotNil(t, session)
+		assert.False(t, session.Active())
+		assert.Equal(t, SessionSignedOut, session.EndReason)
+
+		// An active copy read before the session ended doesn't bring it back
+		require.NoError(t, store.Save(ctx, Session{ID: "a", UserID: 1, CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(2 * time.Hour)}))
+		session, err = store.Get(ctx, "a")
+		require.NoError(t, err)
+		require.NotNil(t, session)
+		assert.False(t, session.Active())
+		assert.True(t, now.Add(time.Hour).Equal(session.ExpiresAt))
+	})
+
+	t.Run("ExpiredSessionsArePrunedFromTheIndex", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisSessionStore(client)
+		now := time.Now()
+
+		require.NoError(t, store.Save(ctx, Session{ID: "a", UserID: 1, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+		require.NoError(t, store.Save(ctx, Session{ID: "b", UserID: 1, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+		// As if session a's key had expired
+		require.NoError(t, client.Del(ctx, redisSessionPrefix+"a").Err())
+
+		sessions, err := store.ListByUser(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, "b", sessions[0].ID)
+
+		indexed, err := client.ZRange(ctx, redisUserSessionsPrefix+"1", 0, -1).Result()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, indexed)
+	})
+
+	t.Run("TheIndexOutlivesEverySession", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisSessionStore(client)
+		now := time.Now()
+
+		require.NoError(t, store.Save(ctx, Session{ID: "long", UserID: 1, CreatedAt: now, ExpiresAt: now.Add(2 * time.Hour)}))
+		require.NoError(t, store.Save(ctx, Session{ID: "short", UserID: 1, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+		ttl, err := client.TTL(ctx, redisUserSessionsPrefix+"1").Result()
+		require.NoError(t, err)
+		assert.InDelta(t, (2 * time.Hour).Seconds(), ttl.Seconds(), 5)
+	})
+}
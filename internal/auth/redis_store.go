@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisBlacklistPrefix is prepended to every token ID stored in Redis
+const redisBlacklistPrefix = "blacklist:"
+
+// redisUserEpochPrefix is prepended to every user ID with a revocation epoch
+const redisUserEpochPrefix = "revoked_before:"
+
+// RedisRevocationStore implements RevocationStore on top of Redis keys with TTL
+type RedisRevocationStore struct {
+	client *redis.Client
+}
+
+// NewRedisRevocationStore creates a new Redis-backed revocation store
+func NewRedisRevocationStore(client *redis.Client) *RedisRevocationStore {
+	return &RedisRevocationStore{client: client}
+}
+
+// Revoke stores the token ID in Redis with the token's remaining lifetime as TTL
+func (s *RedisRevocationStore) Revoke(ctx context.Context, token RevokedToken) error {
+	if token.TTL <= 0 {
+		return nil
+	}
+
+	return s.client.Set(ctx, redisBlacklistPrefix+token.TokenID, "1", token.TTL).Err()
+}
+
+// RevokeIfAbsent stores the token ID with SETNX, so only one caller wins
+func (s *RedisRevocationStore) RevokeIfAbsent(ctx context.Context, token RevokedToken) (bool, error) {
+	if token.TTL <= 0 {
+		return false, nil
+	}
+
+	return s.client.SetNX(ctx, redisBlacklistPrefix+token.TokenID, "1", token.TTL).Result()
+}
+
+// IsRevoked checks if a token ID is present in Redis
+func (s *RedisRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	result, err := s.client.Exists(ctx, redisBlacklistPrefix+tokenID).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return result > 0, nil
+}
+
+// AreRevoked checks several token IDs using a single pipelined round trip
+func (s *RedisRevocationStore) AreRevoked(ctx context.Context, tokenIDs []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(tokenIDs))
+	if len(tokenIDs) == 0 {
+		return result, nil
+	}
+
+	cmds := make([]*redis.IntCmd, len(tokenIDs))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, tokenID := range tokenIDs {
+			cmds[i] = pipe.Exists(ctx, redisBlacklistPrefix+tokenID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, tokenID := range tokenIDs {
+		result[tokenID] = cmds[i].Val() > 0
+	}
+
+	return result, nil
+}
+
+// List scans Redis for all blacklist keys and returns their token IDs
+func (s *RedisRevocationStore) List(ctx context.Context) ([]string, error) {
+	var tokenIDs []string
+
+	iter := s.client.Scan(ctx, 0, redisBlacklistPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		tokenIDs = append(tokenIDs, strings.TrimPrefix(iter.Val(), redisBlacklistPrefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokenIDs, nil
+}
+
+// Count returns the number of blacklist keys currently held in Redis
+func (s *RedisRevocationStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+
+	iter := s.client.Scan(ctx, 0, redisBlacklistPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// revokeUserScript stores a revocation epoch unless a later one is stored,
+// keeping the longer of the two expiries. The stored string is written back
+// as is, since Lua numbers would lose the microseconds when formatted.
+var revokeUserScript = redis.NewScript(`
+local epoch = ARGV[1]
+local current = redis.call('GET', KEYS[1])
+if current and tonumber(current) > tonumber(epoch) then
+	epoch = current
+end
+local ttl = tonumber(ARGV[2])
+if redis.call('PTTL', KEYS[1]) > ttl then
+	ttl = redis.call('PTTL', KEYS[1])
+end
+redis.call('SET', KEYS[1], epoch, 'PX', ttl)
+return 1
+`)
+
+// RevokeUser stores the user's revocation epoch as Unix microseconds with a
+// TTL, never moving it backwards or shortening its expiry
+func (s *RedisRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	key := redisUserEpochPrefix + strconv.Itoa(userID)
+	return revokeUserScript.Run(ctx, s.client, []string{key}, before.UnixMicro(), max(ttl.Milliseconds(), 1)).Err()
+}
+
+// UserRevokedBefore reads the user's revocation epoch from Redis
+func (s *RedisRevocationStore) UserRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	epoch, err := s.client.Get(ctx, redisUserEpochPrefix+strconv.Itoa(userID)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return time.UnixMicro(epoch), nil
+}
+
+// RevokedUsers scans Redis for the users holding a revocation epoch
+func (s *RedisRevocationStore) RevokedUsers(ctx context.Context) ([]int, error) {
+	var userIDs []int
+
+	iter := s.client.Scan(ctx, 0, redisUserEpochPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		userID, err := strconv.Atoi(strings.TrimPrefix(iter.Val(), redisUserEpochPrefix))
+		if err != nil {
+			continue
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
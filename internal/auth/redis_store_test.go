@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRedisClient returns a client of the scratch Redis named by
+// REDIS_TEST_ADDR with its database emptied, skipping the test without one
+func newTestRedisClient(t *testing.T) *redis.Client {
+	t.Helper()
+
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+
+	require.NoError(t, client.FlushDB(context.Background()).Err())
+	return client
+}
+
+func TestRedisRevocationStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Contract", func(t *testing.T) {
+		testRevocationStoreContract(t, func(t *testing.T) RevocationStore {
+			return NewRedisRevocationStore(newTestRedisClient(t))
+		})
+	})
+
+	t.Run("EntriesExpireWithTheirTokens", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisRevocationStore(client)
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", TTL: time.Hour}))
+		require.NoError(t, store.RevokeUser(ctx, 1, time.Now(), time.Hour))
+
+		for _, key := range []string{redisBlacklistPrefix + "a", redisUserEpochPrefix + "1"} {
+			ttl, err := client.TTL(ctx, key).Result()
+			require.NoError(t, err)
+			assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 5, key)
+		}
+	})
+
+	t.Run("EpochExpiryIsNeverShortened", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisRevocationStore(client)
+
+		require.NoError(t, store.RevokeUser(ctx, 1, time.Now(), time.Hour))
+		require.NoError(t, store.RevokeUser(ctx, 1, time.Now(), time.Minute))
+
+		ttl, err := client.PTTL(ctx, redisUserEpochPrefix+"1").Result()
+		require.NoError(t, err)
+		assert.Greater(t, ttl, time.Minute)
+	})
+
+	t.Run("RevokeIfAbsentKeepsTheFirstExpiry", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisRevocationStore(client)
+
+		_, err := store.RevokeIfAbsent(ctx, RevokedToken{TokenID: "a", TTL: time.Hour})
+		require.NoError(t, err)
+		_, err = store.RevokeIfAbsent(ctx, RevokedToken{TokenID: "a", TTL: time.Minute})
+		require.NoError(t, err)
+
+		ttl, err := client.TTL(ctx, redisBlacklistPrefix+"a").Result()
+		require.NoError(t, err)
+		assert.Greater(t, ttl, time.Minute)
+	})
+
+	t.Run("EpochsAreMicroseconds", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisRevocationStore(client)
+
+		epoch := time.Now().Truncate(time.Microsecond)
+		require.NoError(t, store.RevokeUser(ctx, 1, epoch, time.Hour))
+
+		stored, err := client.Get(ctx, redisUserEpochPrefix+"1").Result()
+		require.NoError(t, err)
+		assert.Equal(t, strconv.FormatInt(epoch.UnixMicro(), 10), stored)
+
+		// Small values aren't taken for Unix seconds
+		require.NoError(t, client.Set(ctx, redisUserEpochPrefix+"2", 1_000_000, time.Hour).Err())
+		before, err := store.UserRevokedBefore(ctx, 2)
+		require.NoError(t, err)
+		assert.True(t, time.Unix(1, 0).Equal(before), "got %s", before)
+	})
+
+	t.Run("MalformedEpochKeysAreSkipped", func(t *testing.T) {
+		client := newTestRedisClient(t)
+		store := NewRedisRevocationStore(client)
+
+		require.NoError(t, store.RevokeUser(ctx, 1, time.Now(), time.Hour))
+		require.NoError(t, client.Set(ctx, redisUserEpochPrefix+"not-a-user", 1, time.Hour).Err())
+
+		userIDs, err := store.RevokedUsers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1}, userIDs)
+	})
+
+	t.Run("CommandsAreBoundedByTheCallersContext", func(t *testing.T) {
+		store := NewRedisRevocationStore(newTestRedisClient(t))
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := store.IsRevoked(canceled, "a")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// RevokedToken describes a single entry held by a RevocationStore
+type RevokedToken struct {
+	TokenID string
+	UserID  int
+	Reason  string
+	TTL     time.Duration
+}
+
+// RevocationStore persists revoked token IDs until the tokens they belong to expire
+type RevocationStore interface {
+	// Revoke marks a token ID as revoked for token.TTL
+	Revoke(ctx context.Context, token RevokedToken) error
+
+	// RevokeIfAbsent atomically marks a token ID as revoked for token.TTL
+	// unless it already is, reporting whether this call revoked it
+	RevokeIfAbsent(ctx context.Context, token RevokedToken) (bool, error)
+
+	// IsRevoked reports whether a token ID is currently revoked
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+
+	// AreRevoked reports the revocation status of several token IDs in one call
+	AreRevoked(ctx context.Context, tokenIDs []string) (map[string]bool, error)
+
+	// List returns the IDs of all currently revoked tokens
+	List(ctx context.Context) ([]string, error)
+
+	// Count returns the number of currently revoked tokens
+	Count(ctx context.Context) (int64, error)
+
+	// RevokeUser invalidates every token issued to userID at or before the
+	// given time. The epoch is kept for ttl, which must cover the lifetime of
+	// the longest-lived token. The epoch never moves backwards and its expiry
+	// is never shortened, whatever order concurrent calls land in.
+	RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error
+
+	// UserRevokedBefore returns the user's revocation epoch, or the zero time if there is none
+	UserRevokedBefore(ctx context.Context, userID int) (time.Time, error)
+
+	// RevokedUsers returns the IDs of all users with a revocation epoch
+	RevokedUsers(ctx context.Context) ([]int, error)
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRevocationStoreContract checks the behavior every RevocationStore
+// shares, creating an empty store with newStore for each subtest
+func testRevocationStoreContract(t *testing.T, newStore func(t *testing.T) RevocationStore) {
+	ctx := context.Background()
+
+	t.Run("RevokeAndLookup", func(t *testing.T) {
+		store := newStore(t)
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", UserID: 1, TTL: time.Minute, Reason: "logout"}))
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "b", TTL: time.Minute}))
+
+		revoked, err := store.IsRevoked(ctx, "a")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		statuses, err := store.AreRevoked(ctx, []string{"a", "b", "c"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"a": true, "b": true, "c": false}, statuses)
+
+		tokenIDs, err := store.List(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"a", "b"}, tokenIDs)
+
+		count, err := store.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("NonPositiveTTLIsIgnored", func(t *testing.T) {
+		store := newStore(t)
+
+		require.NoError(t, store.Revoke(ctx, RevokedToken{TokenID: "a", TTL: 0}))
+		revoked, err := store.IsRevoked(ctx, "a")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		revoked, err = store.RevokeIfAbsent(ctx, RevokedToken{TokenID: "a", TTL: -time.Minute})
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		require.NoError(t, store.RevokeUser(ctx, 1, time.Now(), 0))
+		before, err := store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, before.IsZero())
+	})
+
+	t.Run("RevokeIfAbsent", func(t *testing.T) {
+		store := newStore(t)
+
+		revoked, err := store.RevokeIfAbsent(ctx, RevokedToken{TokenID: "a", TTL: time.Minute})
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		revoked, err = store.RevokeIfAbsent(ctx, RevokedToken{TokenID: "a", TTL: time.Minute})
+		require.NoError(t, err)
+		assert.False(t, revoked, "already revoked")
+
+		isRevoked, err := store.IsRevoked(ctx, "a")
+		require.NoError(t, err)
+		assert.True(t, isRevoked)
+	})
+
+	t.Run("UserEpochs", func(t *testing.T) {
+		store := newStore(t)
+
+		before, err := store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, before.IsZero())
+
+		// Epochs are compared with token issue times to the microsecond
+		epoch := time.Now().Truncate(time.Microsecond).Add(123 * time.Microsecond)
+		require.NoError(t, store.RevokeUser(ctx, 1, epoch, time.Hour))
+		require.NoError(t, store.RevokeUser(ctx, 2, epoch, time.Hour))
+
+		before, err = store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, epoch.Equal(before), "want %s, got %s", epoch, before)
+
+		userIDs, err := store.RevokedUsers(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []int{1, 2}, userIDs)
+	})
+
+	t.Run("UserEpochNeverMovesBackwards", func(t *testing.T) {
+		store := newStore(t)
+
+		// A slower logout-everywhere landing last keeps the later epoch
+		epoch := time.Now().Truncate(time.Microsecond)
+		require.NoError(t, store.RevokeUser(ctx, 1, epoch, time.Hour))
+		require.NoError(t, store.RevokeUser(ctx, 1, epoch.Add(-time.Minute), time.Hour))
+
+		before, err := store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, epoch.Equal(before), "want %s, got %s", epoch, before)
+
+		later := epoch.Add(time.Second)
+		require.NoError(t, store.RevokeUser(ctx, 1, later, time.Hour))
+		before, err = store.UserRevokedBefore(ctx, 1)
+		require.NoError(t, err)
+		assert.True(t, later.Equal(before), "want %s, got %s", later, before)
+	})
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidScope is returned when a client requests a scope it was not granted
+var ErrInvalidScope = errors.New("invalid scope")
+
+// ParseScope splits a space-delimited scope string, dropping duplicates
+func ParseScope(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// FormatScope joins scopes into the space-delimited form of the "scope" claim
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// narrowScope returns the requested scopes, or all granted ones when none are
+// requested. Requesting a scope outside the grant fails with ErrInvalidScope.
+func narrowScope(granted, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+
+	for _, s := range requested {
+		if !slices.Contains(granted, s) {
+			return nil, fmt.Errorf("%w: %q was not granted", ErrInvalidScope, s)
+		}
+	}
+	return requested, nil
+}
+
+// intersectScopes returns the scopes of a that are also in b, in the order of a
+func intersectScopes(a, b []string) []string {
+	var common []string
+	for _, s := range a {
+		if slices.Contains(b, s) {
+			common = append(common, s)
+		}
+	}
+	return common
+}
+
+// Scopes returns the scopes the token was issued with
+func (c *JWTClaims) Scopes() []string {
+	return ParseScope(c.Scope)
+}
+
+// HasScope reports whether the token was issued with the given scope
+func (c *JWTClaims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
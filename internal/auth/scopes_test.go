@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScope(t *testing.T) {
+	assert.Equal(t, []string{"read", "write"}, ParseScope("  read write read "))
+	assert.Nil(t, ParseScope(""))
+	assert.Equal(t, "read write", FormatScope([]string{"read", "write"}))
+}
+
+func TestScopedTokens(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+		RoleScopes: map[string][]string{
+			"user": {"profile", "read", "write"},
+		},
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	t.Run("login without scopes grants the role's scopes", func(t *testing.T) {
+		accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+		require.NoError(t, err)
+
+		claims, err := jwtManager.VerifyToken(ctx, accessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "profile read write", claims.Scope)
+		assert.True(t, claims.HasScope("write"))
+
+		claims, err = jwtManager.VerifyToken(ctx, refreshToken)
+		require.NoError(t, err)
+		assert.Equal(t, "profile read write", claims.Scope)
+	})
+
+	t.Run("login can narrow but not widen the scopes", func(t *testing.T) {
+		accessToken, _, err := jwtManager.GenerateScopedTokens(ctx, user, []string{"read"})
+		require.NoError(t, err)
+
+		claims, err := jwtManager.VerifyToken(ctx, accessToken)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"read"}, claims.Scopes())
+		assert.False(t, claims.HasScope("write"))
+
+		_, _, err = jwtManager.GenerateScopedTokens(ctx, user, []string{"read", "admin"})
+		assert.ErrorIs(t, err, ErrInvalidScope)
+	})
+
+	t.Run("roles without scopes get none", func(t *testing.T) {
+		guest := &models.User{ID: 2, Username: "guest", Role: "guest"}
+		accessToken, _, err := jwtManager.GenerateTokens(ctx, guest)
+		require.NoError(t, err)
+
+		claims, err := jwtManager.VerifyToken(ctx, accessToken)
+		require.NoError(t, err)
+		assert.Empty(t, claims.Scope)
+
+		_, _, err = jwtManager.GenerateScopedTokens(ctx, guest, []string{"read"})
+		assert.ErrorIs(t, err, ErrInvalidScope)
+	})
+
+	t.Run("refresh never escalates beyond the login grant", func(t *testing.T) {
+		_, refreshToken, err := jwtManager.GenerateScopedTokens(ctx, user, []string{"profile", "read"})
+		require.NoError(t, err)
+
+		// Asking for a scope outside the grant fails without spending the refresh token
+		_, _, err = jwtManager.RefreshScopedToken(ctx, refreshToken, []string{"write"})
+		assert.ErrorIs(t, err, ErrInvalidScope)
+
+		// Narrowing the access token leaves the grant of the new refresh token intact
+		accessToken, refreshToken, err := jwtManager.RefreshScopedToken(ctx, refreshToken, []string{"read"})
+		require.NoError(t, err)
+
+		claims, err := jwtManager.VerifyToken(ctx, accessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "read", claims.Scope)
+
+		claims, err = jwtManager.VerifyToken(ctx, refreshToken)
+		require.NoError(t, err)
+		assert.Equal(t, "profile read", claims.Scope)
+
+		// A plain refresh restores the full grant, and no more
+		accessToken, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+		require.NoError(t, err)
+
+		claims, err = jwtManager.VerifyToken(ctx, accessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "profile read", claims.Scope)
+	})
+
+	t.Run("scope cannot be overwritten by custom claims", func(t *testing.T) {
+		assert.True(t, IsReservedClaim("scope"))
+	})
+}
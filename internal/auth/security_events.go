@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"log"
+	"time"
+)
+
+// SecurityEventRefreshTokenReuse is reported when an already rotated refresh
+// token is presented again; otherwise revoked refresh tokens are just rejected
+const SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+
+// SecurityEvent describes a suspicious occurrence detected by the JWTManager
+type SecurityEvent struct {
+	Type     string
+	UserID   int
+	Username string
+	TokenID  string
+	FamilyID string
+	Time     time.Time
+}
+
+// SecurityEventHandler receives security events as they are detected
+type SecurityEventHandler func(event SecurityEvent)
+
+// logSecurityEvent is the default SecurityEventHandler
+func logSecurityEvent(event SecurityEvent) {
+	log.Printf("SECURITY: %s for user %d (%s), token %s, family %s",
+		event.Type, event.UserID, event.Username, event.TokenID, event.FamilyID)
+}
@@ -0,0 +1,366 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrSessionNotFound is returned for sessions that don't exist, have ended or
+	// belong to another user
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionLimitReached is returned for logins beyond the session limit
+	// when the limit policy rejects them
+	ErrSessionLimitReached = errors.New("session limit reached")
+
+	// ErrSessionEvicted is returned for tokens of a session evicted by a newer
+	// login; it also matches ErrTokenBlacklisted
+	ErrSessionEvicted = fmt.Errorf("%w: session evicted", ErrTokenBlacklisted)
+
+	// ErrSessionExpired is returned when refreshing a session that was idle or
+	// open for too long
+	ErrSessionExpired = errors.New("session expired")
+
+	// ErrSessionIdleTimeout is returned when refreshing a session that wasn't
+	// refreshed within the idle timeout; it also matches ErrSessionExpired
+	ErrSessionIdleTimeout = fmt.Errorf("%w: idle timeout", ErrSessionExpired)
+
+	// ErrSessionLifetimeExceeded is returned when refreshing a session older
+	// than the maximum lifetime; it also matches ErrSessionExpired
+	ErrSessionLifetimeExceeded = fmt.Errorf("%w: maximum lifetime reached", ErrSessionExpired)
+
+	// ErrSessionStoreUnavailable is returned when a session can't be listed,
+	// recorded or ended because the session store failed
+	ErrSessionStoreUnavailable = errors.New("session store unavailable")
+)
+
+// Session end reasons
+const (
+	SessionSignedOut           = "signed out"
+	SessionSignedOutEverywhere = "signed out everywhere"
+	SessionRevokedByClient     = "revoked by client"
+	SessionTokenReuse          = "refresh token reuse"
+	SessionEvicted             = "evicted by a newer login"
+	SessionIdleTimeout         = "idle timeout"
+	SessionLifetimeExceeded    = "maximum lifetime reached"
+)
+
+// Session limit policies, deciding what a login beyond the limit does
+const (
+	SessionLimitEvictOldest = "evict-oldest"
+	SessionLimitReject      = "reject"
+)
+
+// SessionLimits caps the simultaneous sessions of an account. The limit of a
+// username takes precedence over that of the user's role, which takes
+// precedence over the default; zero means unlimited.
+type SessionLimits struct {
+	Default int
+	Roles   map[string]int
+	Users   map[string]int
+	Policy  string // evict-oldest (the default) or reject
+}
+
+// Limit returns the maximum number of sessions of the user, 0 for unlimited
+func (l SessionLimits) Limit(user *models.User) int {
+	if limit, ok := l.Users[user.Username]; ok {
+		return limit
+	}
+	if limit, ok := l.Roles[user.Role]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// Session is one login of a user on one device. All access and refresh
+// tokens rotated from the login carry its ID in the "sid" claim.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	FamilyID   string    `json:"family_id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"` // last login or refresh
+	ExpiresAt  time.Time `json:"expires_at"`   // when its last refresh token expires
+	EndedAt    time.Time `json:"ended_at"`
+	EndReason  string    `json:"end_reason,omitempty"`
+}
+
+// Active reports whether the session hasn't been ended
+func (s *Session) Active() bool {
+	return s.EndedAt.IsZero()
+}
+
+// SessionStore persists sessions until they expire. Ended sessions are kept
+// until then too, so tokens of an ended session can be told apart.
+type SessionStore interface {
+	// Save creates or replaces a session, keeping it until session.ExpiresAt.
+	// It atomically refuses to replace an ended session with an active one,
+	// so a refresh racing the session's end can't bring it back.
+	Save(ctx context.Context, session Session) error
+
+	// Get returns a session, or nil if there is none with that ID
+	Get(ctx context.Context, sessionID string) (*Session, error)
+
+	// ListByUser returns the user's unexpired sessions, oldest first
+	ListByUser(ctx context.Context, userID int) ([]Session, error)
+}
+
+// SessionDevice describes the client a login comes from
+type SessionDevice struct {
+	UserAgent string
+	IP        string
+	Label     string // e.g. "iPhone"; derived from the user agent when empty
+}
+
+type sessionDeviceKey struct{}
+
+// WithSessionDevice returns a context recording the login's client in the
+// session created by GenerateTokens
+func WithSessionDevice(ctx context.Context, device SessionDevice) context.Context {
+	return context.WithValue(ctx, sessionDeviceKey{}, device)
+}
+
+// sessionDeviceFrom returns the client stored by WithSessionDevice
+func sessionDeviceFrom(ctx context.Context) SessionDevice {
+	device, _ := ctx.Value(sessionDeviceKey{}).(SessionDevice)
+	if device.Label == "" {
+		device.Label = DeviceLabel(device.UserAgent)
+	}
+	return device
+}
+
+// deviceLabels maps user agent fragments to device labels, most specific first
+var deviceLabels = []struct {
+	fragment string
+	label    string
+}{
+	{"iPhone", "iPhone"},
+	{"iPad", "iPad"},
+	{"Android", "Android"},
+	{"Macintosh", "Mac"},
+	{"Windows", "Windows"},
+	{"CrOS", "Chromebook"},
+	{"Linux", "Linux"},
+	{"curl/", "curl"},
+}
+
+// DeviceLabel returns a short name for the device a user agent runs on
+func DeviceLabel(userAgent string) string {
+	for _, device := range deviceLabels {
+		if strings.Contains(userAgent, device.fragment) {
+			return device.label
+		}
+	}
+	return "Unknown device"
+}
+
+// SetSessionStore enables the session registry: every login records a
+// session and its tokens carry the session ID
+func (m *JWTManager) SetSessionStore(store SessionStore) {
+	m.sessions = store
+}
+
+// SetSessionLimits caps the simultaneous sessions of each account. It only
+// applies with a session store.
+func (m *JWTManager) SetSessionLimits(limits SessionLimits) error {
+	switch limits.Policy {
+	case "":
+		limits.Policy = SessionLimitEvictOldest
+	case SessionLimitEvictOldest, SessionLimitReject:
+	default:
+		return fmt.Errorf("unknown session limit policy %q", limits.Policy)
+	}
+
+	m.sessionLimits = limits
+	return nil
+}
+
+// Sessions returns the user's active sessions, oldest first. Sessions past the
+// idle timeout or maximum lifetime are left out even before a refresh ends them.
+func (m *JWTManager) Sessions(ctx context.Context, userID int) ([]Session, error) {
+	if m.sessions == nil {
+		return nil, nil
+	}
+
+	all, err := m.sessions.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSessionStoreUnavailable, err)
+	}
+
+	now := m.clock.Now()
+	sessions := make([]Session, 0, len(all))
+	for _, session := range all {
+		if session.Active() && !m.sessionTimedOut(&session, now) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the user's sessions, revoking every access and
+// refresh token issued to it
+func (m *JWTManager) RevokeSession(ctx context.Context, userID int, sessionID, reason string) error {
+	if m.sessions == nil {
+		return ErrSessionNotFound
+	}
+
+	session, err := m.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSessionStoreUnavailable, err)
+	}
+	if session == nil || session.UserID != userID || !session.Active() {
+		return ErrSessionNotFound
+	}
+
+	return m.endSession(ctx, session, reason)
+}
+
+// enforceSessionLimit makes room for a new session of the user, evicting
+// their oldest sessions or refusing the login as the limit policy says.
+// Listing and evicting isn't atomic in the store: the caller serializes the
+// user's logins within this process, but instances sharing a session store
+// can briefly let a user exceed the limit by logging in on several at once.
+func (m *JWTManager) enforceSessionLimit(ctx context.Context, user *models.User) error {
+	limit := m.sessionLimits.Limit(user)
+	if limit <= 0 {
+		return nil
+	}
+
+	sessions, err := m.Sessions(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	excess := len(sessions) - limit + 1
+	if excess <= 0 {
+		return nil
+	}
+	if m.sessionLimits.Policy == SessionLimitReject {
+		return ErrSessionLimitReached
+	}
+
+	// Sessions are listed oldest first
+	for i := 0; i < excess; i++ {
+		if err := m.endSession(ctx, &sessions[i], SessionEvicted); err != nil {
+			return fmt.Errorf("could not evict session %s: %w", sessions[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// sessionRevocation refines ErrTokenBlacklisted for tokens of an evicted session
+func (m *JWTManager) sessionRevocation(ctx context.Context, claims *JWTClaims) error {
+	if m.sessions == nil || claims.SessionID == "" {
+		return ErrTokenBlacklisted
+	}
+
+	session, err := m.sessions.Get(ctx, claims.SessionID)
+	if err == nil && session != nil && session.EndReason == SessionEvicted {
+		return ErrSessionEvicted
+	}
+	return ErrTokenBlacklisted
+}
+
+// checkSessionAge enforces the idle timeout and maximum lifetime on the
+// refresh token's session and returns the login time to carry into the
+// rotated tokens. The refresh token was issued at the session's last login or
+// refresh; tokens issued before "auth_time" existed count their lifetime from
+// their issue time instead.
+func (m *JWTManager) checkSessionAge(ctx context.Context, claims *JWTClaims) (*jwt.NumericDate, error) {
+	authTime := claims.AuthTime
+	if authTime == nil {
+		authTime = claims.IssuedAt
+	}
+
+	now := m.clock.Now()
+	if m.idleTimeout > 0 && claims.IssuedAt != nil && now.Sub(claims.IssuedAt.Time) > m.idleTimeout {
+		m.markSessionEnded(ctx, claims.SessionID, SessionIdleTimeout)
+		return nil, ErrSessionIdleTimeout
+	}
+	if m.maxLifetime > 0 && authTime != nil && now.Sub(authTime.Time) > m.maxLifetime {
+		m.markSessionEnded(ctx, claims.SessionID, SessionLifetimeExceeded)
+		return nil, ErrSessionLifetimeExceeded
+	}
+
+	return authTime, nil
+}
+
+// sessionTimedOut reports whether the session can no longer be refreshed
+func (m *JWTManager) sessionTimedOut(session *Session, now time.Time) bool {
+	return (m.idleTimeout > 0 && now.Sub(session.LastSeenAt) > m.idleTimeout) ||
+		(m.maxLifetime > 0 && now.Sub(session.CreatedAt) > m.maxLifetime)
+}
+
+// recordSession saves the session started by a login
+func (m *JWTManager) recordSession(ctx context.Context, claims JWTClaims) error {
+	device := sessionDeviceFrom(ctx)
+	now := m.clock.Now()
+
+	return m.sessions.Save(ctx, Session{
+		ID:         claims.SessionID,
+		UserID:     claims.UserID,
+		FamilyID:   claims.FamilyID,
+		Device:     device.Label,
+		UserAgent:  device.UserAgent,
+		IP:         device.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(m.config.RefreshTokenExpiration + m.config.JWTLeeway),
+	})
+}
+
+// touchSession extends a session whose refresh token was just rotated. The
+// store keeps the session ended if it ends between the Get and the Save.
+func (m *JWTManager) touchSession(ctx context.Context, sessionID string) error {
+	session, err := m.sessions.Get(ctx, sessionID)
+	if err != nil || session == nil || !session.Active() {
+		return err
+	}
+
+	now := m.clock.Now()
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(m.config.RefreshTokenExpiration + m.config.JWTLeeway)
+	return m.sessions.Save(ctx, *session)
+}
+
+// endSession revokes the session's token family and marks it ended
+func (m *JWTManager) endSession(ctx context.Context, session *Session, reason string) error {
+	if err := m.RevokeFamily(ctx, session.FamilyID, session.UserID, reason); err != nil {
+		return err
+	}
+
+	session.EndedAt = m.clock.Now()
+	session.EndReason = reason
+	if err := m.sessions.Save(ctx, *session); err != nil {
+		return fmt.Errorf("%w: %w", ErrSessionStoreUnavailable, err)
+	}
+	return nil
+}
+
+// markSessionEnded records that the session's tokens were revoked by other means
+func (m *JWTManager) markSessionEnded(ctx context.Context, sessionID, reason string) {
+	if m.sessions == nil || sessionID == "" {
+		return
+	}
+
+	session, err := m.sessions.Get(ctx, sessionID)
+	if err == nil && session != nil && session.Active() {
+		session.EndedAt = m.clock.Now()
+		session.EndReason = reason
+		err = m.sessions.Save(ctx, *session)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to end session %s: %v", sessionID, err)
+	}
+}
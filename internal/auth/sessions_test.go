@@ -0,0 +1,454 @@
+package auth
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	iPhoneUserAgent = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15"
+	iPadUserAgent   = "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15"
+)
+
+func newSessionTestManager(t *testing.T) *JWTManager {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+	jwtManager, err := NewJWTManager(cfg, newTestRevocationStore(t))
+	require.NoError(t, err)
+
+	jwtManager.SetSessionStore(NewMemorySessionStore())
+	return jwtManager
+}
+
+// overlapSessionStore records whether session listings ever overlap
+type overlapSessionStore struct {
+	SessionStore
+	listing    atomic.Int32
+	overlapped atomic.Bool
+}
+
+func (s *overlapSessionStore) ListByUser(ctx context.Context, userID int) ([]Session, error) {
+	if s.listing.Add(1) > 1 {
+		s.overlapped.Store(true)
+	}
+	defer s.listing.Add(-1)
+
+	// Give concurrent logins the chance to overlap
+	runtime.Gosched()
+	return s.SessionStore.ListByUser(ctx, userID)
+}
+
+// endingSessionStore ends a session right after the next Get has read it,
+// as a sign-out racing a refresh would
+type endingSessionStore struct {
+	SessionStore
+	armed atomic.Bool
+	end   func()
+}
+
+func (s *endingSessionStore) Get(ctx context.Context, sessionID string) (*Session, error) {
+	session, err := s.SessionStore.Get(ctx, sessionID)
+	if s.armed.CompareAndSwap(true, false) {
+		s.end()
+	}
+	return session, err
+}
+
+func loginFrom(t *testing.T, jwtManager *JWTManager, user *models.User, userAgent string) (string, string) {
+	ctx := WithSessionDevice(context.Background(), SessionDevice{UserAgent: userAgent, IP: "203.0.113.7"})
+	accessToken, refreshToken, err := jwtManager.GenerateTokens(ctx, user)
+	require.NoError(t, err)
+	return accessToken, refreshToken
+}
+
+func TestSessionRegistry(t *testing.T) {
+	jwtManager := newSessionTestManager(t)
+	ctx := context.Background()
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+	iPhoneAccess, iPhoneRefresh := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+	iPadAccess, iPadRefresh := loginFrom(t, jwtManager, user, iPadUserAgent)
+
+	sessions, err := jwtManager.Sessions(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "iPhone", sessions[0].Device)
+	assert.Equal(t, "iPad", sessions[1].Device)
+	assert.Equal(t, "203.0.113.7", sessions[0].IP)
+	assert.Equal(t, iPadUserAgent, sessions[1].UserAgent)
+
+	// Tokens carry the ID of their session
+	iPadClaims, err := jwtManager.VerifyToken(ctx, iPadAccess)
+	require.NoError(t, err)
+	assert.Equal(t, sessions[1].ID, iPadClaims.SessionID)
+
+	// Another user can't see or sign out the sessions
+	otherSessions, err := jwtManager.Sessions(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, otherSessions)
+	err = jwtManager.RevokeSession(ctx, 2, sessions[1].ID, SessionSignedOut)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// Signing out the iPad revokes its access and refresh tokens together
+	require.NoError(t, jwtManager.RevokeSession(ctx, user.ID, sessions[1].ID, SessionSignedOut))
+
+	_, err = jwtManager.VerifyToken(ctx, iPadAccess)
+	assert.ErrorIs(t, err, ErrTokenBlacklisted)
+	_, err = jwtManager.VerifyToken(ctx, iPadRefresh)
+	assert.ErrorIs(t, err, ErrTokenBlacklisted)
+
+	_, err = jwtManager.VerifyToken(ctx, iPhoneAccess)
+	assert.NoError(t, err)
+
+	sessions, err = jwtManager.Sessions(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "iPhone", sessions[0].Device)
+
+	err = jwtManager.RevokeSession(ctx, user.ID, iPadClaims.SessionID, SessionSignedOut)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// Refreshing keeps the session and its ID
+	before := sessions[0]
+	accessToken, _, err := jwtManager.RefreshToken(ctx, iPhoneRefresh)
+	require.NoError(t, err)
+
+	claims, err := jwtManager.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, before.ID, claims.SessionID)
+
+	sessions, err = jwtManager.Sessions(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.False(t, sessions[0].LastSeenAt.Before(before.LastSeenAt))
+	assert.Equal(t, before.CreatedAt, sessions[0].CreatedAt)
+}
+
+func TestSessionsEndWithTheirTokens(t *testing.T) {
+	jwtManager := newSessionTestManager(t)
+	ctx := context.Background()
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	t.Run("revoking a refresh token ends its session", func(t *testing.T) {
+		_, refreshToken := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+		require.NoError(t, jwtManager.RevokeToken(ctx, refreshToken))
+
+		sessions, err := jwtManager.Sessions(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+
+	t.Run("logging out ends its session", func(t *testing.T) {
+		accessToken, refreshToken := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+		require.NoError(t, jwtManager.BlacklistToken(ctx, accessToken))
+
+		sessions, err := jwtManager.Sessions(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+
+		// The refresh token of the login is revoked with it
+		_, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+		assert.Equal(t, ErrTokenBlacklisted, err)
+	})
+
+	t.Run("refresh token reuse ends its session", func(t *testing.T) {
+		_, refreshToken := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+		_, _, err := jwtManager.RefreshToken(ctx, refreshToken)
+		require.NoError(t, err)
+		_, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+		require.ErrorIs(t, err, ErrRefreshTokenReused)
+
+		sessions, err := jwtManager.Sessions(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+
+	t.Run("logging out everywhere ends every session", func(t *testing.T) {
+		loginFrom(t, jwtManager, user, iPhoneUserAgent)
+		loginFrom(t, jwtManager, user, iPadUserAgent)
+		require.NoError(t, jwtManager.RevokeAllForUser(ctx, user.ID))
+
+		sessions, err := jwtManager.Sessions(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+}
+
+func TestRefreshRacingSignOutKeepsTheSessionEnded(t *testing.T) {
+	jwtManager := newSessionTestManager(t)
+	store := &endingSessionStore{SessionStore: NewMemorySessionStore()}
+	jwtManager.SetSessionStore(store)
+	ctx := context.Background()
+
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+	_, refreshToken := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+	sessions, err := jwtManager.Sessions(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+
+	// The session ends after the refresh read it but before it saved it back
+	store.end = func() {
+		require.NoError(t, jwtManager.RevokeSession(ctx, user.ID, sessions[0].ID, SessionSignedOut))
+	}
+	store.armed.Store(true)
+	accessToken, _, err := jwtManager.RefreshToken(ctx, refreshToken)
+	require.NoError(t, err)
+
+	session, err := store.SessionStore.Get(ctx, sessions[0].ID)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.False(t, session.Active())
+	assert.Equal(t, SessionSignedOut, session.EndReason)
+
+	sessions, err = jwtManager.Sessions(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	_, err = jwtManager.VerifyToken(ctx, accessToken)
+	assert.ErrorIs(t, err, ErrTokenBlacklisted)
+}
+
+func TestMemorySessionStoreExpiry(t *testing.T) {
+	store := NewMemorySessionStore()
+	ctx := context.Background()
+	now := time.Now()
+
+	require.NoError(t, store.Save(ctx, Session{ID: "expired", UserID: 1, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}))
+	require.NoError(t, store.Save(ctx, Session{ID: "newer", UserID: 1, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, store.Save(ctx, Session{ID: "older", UserID: 1, CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)}))
+
+	session, err := store.Get(ctx, "expired")
+	require.NoError(t, err)
+	assert.Nil(t, session)
+
+	sessions, err := store.ListByUser(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "older", sessions[0].ID)
+	assert.Equal(t, "newer", sessions[1].ID)
+}
+
+func TestDeviceLabel(t *testing.T) {
+	assert.Equal(t, "iPhone", DeviceLabel(iPhoneUserAgent))
+	assert.Equal(t, "iPad", DeviceLabel(iPadUserAgent))
+	assert.Equal(t, "Android", DeviceLabel("Mozilla/5.0 (Linux; Android 14; Pixel 8)"))
+	assert.Equal(t, "Mac", DeviceLabel("Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0)"))
+	assert.Equal(t, "Unknown device", DeviceLabel(""))
+}
+
+func TestSessionLimits(t *testing.T) {
+	ctx := context.Background()
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	t.Run("limits by username, then role, then default", func(t *testing.T) {
+		limits := SessionLimits{
+			Default: 5,
+			Roles:   map[string]int{"user": 3, "service": 0},
+			Users:   map[string]int{"kiosk": 1},
+		}
+		assert.Equal(t, 3, limits.Limit(user))
+		assert.Equal(t, 1, limits.Limit(&models.User{Username: "kiosk", Role: "user"}))
+		assert.Equal(t, 0, limits.Limit(&models.User{Username: "reporting", Role: "service"}))
+		assert.Equal(t, 5, limits.Limit(&models.User{Username: "admin", Role: "admin"}))
+	})
+
+	t.Run("the oldest session is evicted", func(t *testing.T) {
+		jwtManager := newSessionTestManager(t)
+		require.NoError(t, jwtManager.SetSessionLimits(SessionLimits{Roles: map[string]int{"user": 2}}))
+
+		oldestAccess, oldestRefresh := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+		secondAccess, _ := loginFrom(t, jwtManager, user, iPadUserAgent)
+		newestAccess, _ := loginFrom(t, jwtManager, user, "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0)")
+
+		sessions, err := jwtManager.Sessions(ctx, user.ID)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, []string{"iPad", "Mac"}, []string{sessions[0].Device, sessions[1].Device})
+
+		// The evicted device gets a distinct error, which still counts as revoked
+		_, err = jwtManager.VerifyToken(ctx, oldestAccess)
+		assert.ErrorIs(t, err, ErrSessionEvicted)
+		assert.ErrorIs(t, err, ErrTokenBlacklisted)
+
+		// Its refresh token is refused without being treated as stolen
+		var events []SecurityEvent
+		jwtManager.OnSecurityEvent(func(event SecurityEvent) { events = append(events, event) })
+		_, _, err = jwtManager.RefreshToken(ctx, oldestRefresh)
+		assert.ErrorIs(t, err, ErrSessionEvicted)
+		assert.Empty(t, events)
+
+		for _, token := range []string{secondAccess, newestAccess} {
+			_, err = jwtManager.VerifyToken(ctx, token)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("logins beyond the limit are rejected", func(t *testing.T) {
+		jwtManager := newSessionTestManager(t)
+		require.NoError(t, jwtManager.SetSessionLimits(SessionLimits{Default: 1, Policy: SessionLimitReject}))
+
+		accessToken, _ := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+
+		deviceCtx := WithSessionDevice(ctx, SessionDevice{UserAgent: iPadUserAgent})
+		_, _, err := jwtManager.GenerateTokens(deviceCtx, user)
+		assert.ErrorIs(t, err, ErrSessionLimitReached)
+
+		_, err = jwtManager.VerifyToken(ctx, accessToken)
+		assert.NoError(t, err)
+
+		// Signing out frees the slot
+		sessions, err := jwtManager.Sessions(ctx, user.ID)
+		require.NoError(t, err)
+		require.NoError(t, jwtManager.RevokeSession(ctx, user.ID, sessions[0].ID, SessionSignedOut))
+
+		_, err = jwtManager.VerifyToken(ctx, accessToken)
+		assert.ErrorIs(t, err, ErrTokenBlacklisted)
+		assert.NotErrorIs(t, err, ErrSessionEvicted)
+
+		_, _, err = jwtManager.GenerateTokens(deviceCtx, user)
+		assert.NoError(t, err)
+	})
+
+	t.Run("concurrent logins stay within the limit", func(t *testing.T) {
+		jwtManager := newSessionTestManager(t)
+		store := &overlapSessionStore{SessionStore: NewMemorySessionStore()}
+		jwtManager.SetSessionStore(store)
+		require.NoError(t, jwtManager.SetSessionLimits(SessionLimits{Default: 2}))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := jwtManager.GenerateTokens(ctx, user)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		sessions, err := jwtManager.Sessions(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Len(t, sessions, 2)
+		assert.False(t, store.overlapped.Load(), "logins of the same user overlapped")
+	})
+
+	t.Run("unknown policies are rejected", func(t *testing.T) {
+		jwtManager := newSessionTestManager(t)
+		assert.Error(t, jwtManager.SetSessionLimits(SessionLimits{Policy: "evict-newest"}))
+	})
+}
+
+func newSessionAgeTestManager(t *testing.T, idleTimeout, maxLifetime time.Duration) (*JWTManager, *FakeClock) {
+	cfg := &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: 30 * 24 * time.Hour,
+		Sessions:               &config.SessionConfig{IdleTimeout: idleTimeout, MaxLifetime: maxLifetime},
+	}
+	jwtManager, clock := newFakeClockManager(t, cfg)
+
+	sessions := NewMemorySessionStore()
+	sessions.SetClock(clock)
+	jwtManager.SetSessionStore(sessions)
+	return jwtManager, clock
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	jwtManager, clock := newSessionAgeTestManager(t, 8*time.Hour, 0)
+	ctx := context.Background()
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	_, refreshToken := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+
+	// Refreshing within the idle timeout keeps the session going
+	var err error
+	for i := 0; i < 3; i++ {
+		clock.Advance(7 * time.Hour)
+		_, refreshToken, err = jwtManager.RefreshToken(ctx, refreshToken)
+		require.NoError(t, err)
+	}
+
+	// The session drops out of the list as soon as it is idle for too long
+	clock.Advance(8*time.Hour + time.Second)
+	sessions, err := jwtManager.Sessions(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	var events []SecurityEvent
+	jwtManager.OnSecurityEvent(func(event SecurityEvent) { events = append(events, event) })
+
+	_, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+	assert.ErrorIs(t, err, ErrSessionIdleTimeout)
+	assert.ErrorIs(t, err, ErrSessionExpired)
+
+	// Retrying gives the same answer and isn't mistaken for token theft
+	_, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+	assert.ErrorIs(t, err, ErrSessionIdleTimeout)
+	assert.Empty(t, events)
+}
+
+func TestSessionMaxLifetime(t *testing.T) {
+	jwtManager, clock := newSessionAgeTestManager(t, 8*time.Hour, 24*time.Hour)
+	ctx := context.Background()
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	loginTime := clock.Now()
+	_, refreshToken := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+
+	// The login time is carried through every refresh
+	var accessToken string
+	var err error
+	for i := 0; i < 3; i++ {
+		clock.Advance(7 * time.Hour)
+		accessToken, refreshToken, err = jwtManager.RefreshToken(ctx, refreshToken)
+		require.NoError(t, err)
+
+		claims, err := jwtManager.VerifyToken(ctx, accessToken)
+		require.NoError(t, err)
+		require.NotNil(t, claims.AuthTime)
+		assert.Equal(t, loginTime.Unix(), claims.AuthTime.Unix())
+	}
+
+	// Frequent refreshes can't keep the session open past its lifetime
+	clock.Advance(4 * time.Hour)
+	_, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+	assert.ErrorIs(t, err, ErrSessionLifetimeExceeded)
+	assert.ErrorIs(t, err, ErrSessionExpired)
+
+	sessions, err := jwtManager.Sessions(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	// Logging in again starts a new lifetime
+	_, refreshToken = loginFrom(t, jwtManager, user, iPhoneUserAgent)
+	clock.Advance(time.Hour)
+	_, _, err = jwtManager.RefreshToken(ctx, refreshToken)
+	assert.NoError(t, err)
+}
+
+func TestSessionAgeLimitsDisabled(t *testing.T) {
+	jwtManager, clock := newSessionAgeTestManager(t, 0, 0)
+	ctx := context.Background()
+	user := &models.User{ID: 1, Username: "testuser", Role: "user"}
+
+	_, refreshToken := loginFrom(t, jwtManager, user, iPhoneUserAgent)
+
+	var err error
+	for i := 0; i < 3; i++ {
+		clock.Advance(20 * 24 * time.Hour)
+		_, refreshToken, err = jwtManager.RefreshToken(ctx, refreshToken)
+		require.NoError(t, err)
+	}
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
@@ -11,8 +15,9 @@ import (
 
 // AuthHandler handles authentication-related requests
 type AuthHandler struct {
-	jwtManager  *auth.JWTManager
-	userService *models.UserService
+	jwtManager   *auth.JWTManager
+	userService  *models.UserService
+	loginLimiter *auth.LoginLimiter
 }
 
 // NewAuthHandler creates a new authentication handler
@@ -23,10 +28,22 @@ func NewAuthHandler(jwtManager *auth.JWTManager, userService *models.UserService
 	}
 }
 
+// SetLoginLimiter enables brute-force protection of the login endpoint
+func (h *AuthHandler) SetLoginLimiter(limiter *auth.LoginLimiter) {
+	h.loginLimiter = limiter
+}
+
 // LoginRequest represents the login request body
 type LoginRequest struct {
 	Username string `json:"username" example:"admin"`
 	Password string `json:"password" example:"admin123"`
+	Scope    string `json:"scope,omitempty" example:"profile read"` // space-delimited, defaults to every scope of the user's role
+	Device   string `json:"device,omitempty" example:"Work laptop"` // label shown in the session list, derived from the user agent when empty
+}
+
+// RefreshRequest represents the optional refresh request body
+type RefreshRequest struct {
+	Scope string `json:"scope,omitempty" form:"scope" example:"read"` // space-delimited, defaults to the scopes granted at login
 }
 
 // TokenResponse represents the response for token requests
@@ -44,14 +61,19 @@ type ErrorResponse struct {
 
 // Login handles the login request
 // @Summary Login to the system
-// @Description Authenticate user and get JWT tokens
+// @Description Authenticate user and get JWT tokens. Failed attempts slow down further attempts on the same username and eventually lock it, whether or not it exists.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body LoginRequest true "Login request"
 // @Success 200 {object} TokenResponse "Successful login"
-// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 400 {object} ErrorResponse "Invalid request or scope"
 // @Failure 401 {object} ErrorResponse "Invalid credentials"
+// @Failure 409 {object} ErrorResponse "Session limit reached"
+// @Failure 423 {object} ErrorResponse "Username locked after too many failed attempts"
+// @Failure 429 {object} ErrorResponse "Too many attempts, retry after the Retry-After header"
+// @Failure 503 {object} ErrorResponse "Revocation, session or role store unavailable"
+// @Header 423,429 {integer} Retry-After "Seconds until the next attempt is allowed"
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
@@ -66,9 +88,20 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// Count the attempt, refusing it on locked usernames and IPs, before looking at the password
+	if h.loginLimiter != nil {
+		if err := h.loginLimiter.Reserve(c.Request.Context(), req.Username, c.ClientIP()); err != nil {
+			respondLoginBlocked(c, err)
+			return
+		}
+	}
+
 	// Check if user exists
 	user, exists := h.userService.GetUserByUsername(c.Request.Context(), req.Username)
 	if !exists {
+		// Spend the time of a password check, so timing doesn't reveal the username is unknown
+		models.VerifyDummyPassword(req.Password)
+		h.recordLoginFailure(c, req.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid credentials"})
 		return
 	}
@@ -76,13 +109,37 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	// Verify password
 	valid, err := models.VerifyPassword(req.Password, user.Password)
 	if err != nil || !valid {
+		h.recordLoginFailure(c, req.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid credentials"})
 		return
 	}
 
-	// Generate tokens
-	accessToken, refreshToken, err := h.jwtManager.GenerateTokens(user)
+	if h.loginLimiter != nil {
+		if err := h.loginLimiter.RecordSuccess(c.Request.Context(), req.Username, c.ClientIP()); err != nil {
+			log.Printf("Warning: failed to reset login attempts of %q: %v", req.Username, err)
+		}
+	}
+
+	// Generate tokens, recording the session of the device logging in
+	ctx := auth.WithSessionDevice(c.Request.Context(), auth.SessionDevice{
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+		Label:     req.Device,
+	})
+	accessToken, refreshToken, err := h.jwtManager.GenerateScopedTokens(ctx, user, auth.ParseScope(req.Scope))
 	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "requested scope exceeds the scopes granted to the user"})
+			return
+		}
+		if errors.Is(err, auth.ErrSessionLimitReached) {
+			c.JSON(http.StatusConflict, gin.H{"message": "too many active sessions, sign out another device first"})
+			return
+		}
+		if isStoreUnavailable(err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": "login unavailable, please retry"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to generate tokens"})
 		return
 	}
@@ -96,15 +153,44 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	})
 }
 
+// recordLoginFailure records a failed login, the same way for unknown
+// usernames as for wrong passwords
+func (h *AuthHandler) recordLoginFailure(c *gin.Context, username string) {
+	if h.loginLimiter == nil {
+		return
+	}
+	if err := h.loginLimiter.RecordFailure(c.Request.Context(), username, c.ClientIP()); err != nil {
+		log.Printf("Warning: failed to record login failure of %q: %v", username, err)
+	}
+}
+
+// respondLoginBlocked answers a login refused by the login limiter, telling
+// the client when to retry
+func respondLoginBlocked(c *gin.Context, err error) {
+	var blocked *auth.LoginBlockedError
+	if errors.As(err, &blocked) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+	}
+
+	if errors.Is(err, auth.ErrAccountLocked) {
+		c.JSON(http.StatusLocked, gin.H{"message": "too many failed login attempts, try again later"})
+		return
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"message": "too many login attempts, slow down"})
+}
+
 // RefreshToken handles token refresh requests
 // @Summary Refresh access token
-// @Description Get a new access token using a refresh token
+// @Description Get a new access token and a rotated refresh token using a refresh token. Each refresh token can only be used once. The access token can be narrowed to a subset of the scopes granted at login. Sessions idle for longer than the idle timeout, or older than the maximum lifetime, can't be refreshed.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Success 200 {object} TokenResponse "New access token"
+// @Param request body RefreshRequest false "Refresh request"
+// @Success 200 {object} TokenResponse "New access and refresh tokens"
+// @Failure 400 {object} ErrorResponse "Invalid request or scope"
 // @Failure 401 {object} ErrorResponse "Invalid refresh token"
+// @Failure 503 {object} ErrorResponse "Revocation store unavailable"
 // @Router /auth/refresh [post]
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	// Extract refresh token from Authorization header
@@ -122,28 +208,59 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 
 	refreshTokenString := parts[1]
 
-	// Generate a new access token
-	accessToken, err := h.jwtManager.RefreshToken(refreshTokenString)
+	// The body is optional, it only narrows the scopes of the new access token
+	var req RefreshRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request"})
+			return
+		}
+	}
+
+	// Rotate the refresh token into a new token pair
+	accessToken, refreshToken, err := h.jwtManager.RefreshScopedToken(c.Request.Context(), refreshTokenString, auth.ParseScope(req.Scope))
 	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "requested scope exceeds the scopes granted at login"})
+			return
+		}
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "refresh token reuse detected, please login again"})
+			return
+		}
+		if errors.Is(err, auth.ErrSessionEvicted) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "session evicted by a newer login, please login again"})
+			return
+		}
+		if errors.Is(err, auth.ErrSessionExpired) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "session expired, please login again"})
+			return
+		}
+		if isStoreUnavailable(err) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": "token verification unavailable, please retry"})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid refresh token"})
 		return
 	}
 
-	// Return the new access token
+	// Return the new token pair
 	c.JSON(http.StatusOK, TokenResponse{
-		AccessToken: accessToken,
-		TokenType:   "Bearer",
-		ExpiresIn:   900, // 15 minutes in seconds
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    900, // 15 minutes in seconds
 	})
 }
 
 // Logout handles logout requests (token revocation)
 // @Summary Logout from the system
-// @Description Revoke the current token
+// @Description Revoke the current token and end its session, revoking the refresh token of the same login
 // @Tags auth
 // @Security BearerAuth
 // @Success 200 {object} map[string]string "Successfully logged out"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 503 {object} ErrorResponse "Revocation store unavailable"
 // @Router /auth/logout [post]
 func (h *AuthHandler) Logout(c *gin.Context) {
 	// Extract token from Authorization header
@@ -162,7 +279,15 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	tokenString := parts[1]
 
 	// Blacklist the token
-	err := h.jwtManager.BlacklistToken(tokenString)
+	err := h.jwtManager.BlacklistToken(c.Request.Context(), tokenString)
+	if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenExpired) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid or expired token"})
+		return
+	}
+	if isStoreUnavailable(err) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "logout unavailable, please retry"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to logout"})
 		return
@@ -171,6 +296,39 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "successfully logged out"})
 }
 
+// LogoutAll handles requests to revoke every token of the current user
+// @Summary Logout from all devices
+// @Description Revoke every access and refresh token issued to the current user
+// @Tags auth
+// @Security BearerAuth
+// @Success 200 {object} map[string]string "Successfully logged out from all devices"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 503 {object} ErrorResponse "Revocation store unavailable"
+// @Router /auth/logout-all [post]
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	// Get user claims from context (set by auth middleware)
+	claims, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+		return
+	}
+
+	userClaims := claims.(*auth.JWTClaims)
+
+	// Revoke every token issued to the user so far
+	err := h.jwtManager.RevokeAllForUser(c.Request.Context(), userClaims.UserID)
+	if isStoreUnavailable(err) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "logout unavailable, please retry"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "successfully logged out from all devices"})
+}
+
 // Protected is a handler for a protected resource
 // @Summary Get protected resource
 // @Description Access a protected resource requiring authentication
@@ -214,3 +372,14 @@ func (h *AuthHandler) AdminOnly(c *gin.Context) {
 		"message": "This is an admin-only resource",
 	})
 }
+
+// isStoreUnavailable reports whether err is a revocation store call that
+// missed its deadline, or one refused because the store is down, or a failed
+// call to the session or role store
+func isStoreUnavailable(err error) bool {
+	var timeoutErr *auth.StoreTimeoutError
+	return errors.As(err, &timeoutErr) ||
+		errors.Is(err, auth.ErrRevocationStoreUnavailable) ||
+		errors.Is(err, auth.ErrSessionStoreUnavailable) ||
+		errors.Is(err, auth.ErrRoleStoreUnavailable)
+}
@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/config"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
+	"github.com/anhbkpro/jwt-blacklist-go/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// flakyRevocationStore is an in-memory revocation store that can be taken down
+type flakyRevocationStore struct {
+	*auth.MemoryRevocationStore
+	down atomic.Bool
+}
+
+var errStoreDown = errors.New("connection refused")
+
+func (s *flakyRevocationStore) Revoke(ctx context.Context, token auth.RevokedToken) error {
+	if s.down.Load() {
+		return errStoreDown
+	}
+	return s.MemoryRevocationStore.Revoke(ctx, token)
+}
+
+func (s *flakyRevocationStore) RevokeIfAbsent(ctx context.Context, token auth.RevokedToken) (bool, error) {
+	if s.down.Load() {
+		return false, errStoreDown
+	}
+	return s.MemoryRevocationStore.RevokeIfAbsent(ctx, token)
+}
+
+func (s *flakyRevocationStore) AreRevoked(ctx context.Context, tokenIDs []string) (map[string]bool, error) {
+	if s.down.Load() {
+		return nil, errStoreDown
+	}
+	return s.MemoryRevocationStore.AreRevoked(ctx, tokenIDs)
+}
+
+func (s *flakyRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, ttl time.Duration) error {
+	if s.down.Load() {
+		return errStoreDown
+	}
+	return s.MemoryRevocationStore.RevokeUser(ctx, userID, before, ttl)
+}
+
+func (s *flakyRevocationStore) UserRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	if s.down.Load() {
+		return time.Time{}, errStoreDown
+	}
+	return s.MemoryRevocationStore.UserRevokedBefore(ctx, userID)
+}
+
+// downSessionStore is a session store whose writes fail
+type downSessionStore struct {
+	*auth.MemorySessionStore
+}
+
+func (s downSessionStore) Save(ctx context.Context, session auth.Session) error {
+	return errStoreDown
+}
+
+// unreadableSessionStore is a session store whose reads fail
+type unreadableSessionStore struct {
+	*auth.MemorySessionStore
+}
+
+func (s unreadableSessionStore) Get(ctx context.Context, id string) (*auth.Session, error) {
+	return nil, errStoreDown
+}
+
+func (s unreadableSessionStore) ListByUser(ctx context.Context, userID int) ([]auth.Session, error) {
+	return nil, errStoreDown
+}
+
+func newTestConfig() *config.Config {
+	return &config.Config{
+		JWTSecret:              "test-secret-key",
+		AccessTokenExpiration:  15 * time.Minute,
+		RefreshTokenExpiration: time.Hour,
+	}
+}
+
+func newTestJWTManager(t *testing.T, store auth.RevocationStore) *auth.JWTManager {
+	jwtManager, err := auth.NewJWTManager(newTestConfig(), store)
+	require.NoError(t, err)
+	jwtManager.SetSessionStore(auth.NewMemorySessionStore())
+	return jwtManager
+}
+
+func newTestUserService(t *testing.T) *models.UserService {
+	hash, err := models.HashPassword("secret123")
+	require.NoError(t, err)
+
+	return models.NewUserService(&models.InMemoryUserRepository{Users: map[string]*models.User{
+		"alice": {ID: 1, Username: "alice", Password: hash, Role: "user"},
+	}})
+}
+
+// serve sends a request to the router and returns the recorded response
+func serve(r http.Handler, method, target, token string, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// withClaims stands in for the auth middleware, authenticating every request as claims
+func withClaims(claims *auth.JWTClaims) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("user", claims)
+		c.Next()
+	}
+}
+
+func TestUnavailableStoreAnswers503(t *testing.T) {
+	store := &flakyRevocationStore{MemoryRevocationStore: auth.NewMemoryRevocationStore(0, 0)}
+	breaker := auth.NewBreakerRevocationStore(store, 1, time.Hour)
+	jwtManager := newTestJWTManager(t, breaker)
+	require.NoError(t, jwtManager.SetSessionLimits(auth.SessionLimits{Default: 1, Policy: auth.SessionLimitEvictOldest}))
+
+	user := &models.User{ID: 1, Username: "alice", Role: "user"}
+	accessToken, refreshToken, err := jwtManager.GenerateTokens(context.Background(), user)
+	require.NoError(t, err)
+	claims, err := jwtManager.VerifyToken(context.Background(), accessToken)
+	require.NoError(t, err)
+
+	// Take the store down and let the breaker open
+	store.down.Store(true)
+	_, err = breaker.AreRevoked(context.Background(), []string{"trip"})
+	require.Error(t, err)
+	require.Equal(t, "open", breaker.Stats().State)
+
+	authHandler := NewAuthHandler(jwtManager, newTestUserService(t))
+	oauthHandler := NewOAuthHandler(jwtManager, auth.NewClientRegistry(map[string]string{"gateway": "s3cret"}))
+
+	r := gin.New()
+	r.POST("/api/auth/login", authHandler.Login)
+	r.POST("/api/auth/refresh", authHandler.RefreshToken)
+	r.POST("/api/auth/logout", authHandler.Logout)
+	r.POST("/api/auth/logout-all", withClaims(claims), authHandler.LogoutAll)
+	r.DELETE("/api/auth/sessions/:id", withClaims(claims), authHandler.RevokeSession)
+	r.POST("/api/oauth/introspect", oauthHandler.Introspect)
+
+	t.Run("login that would evict the oldest session", func(t *testing.T) {
+		w := serve(r, http.MethodPost, "/api/auth/login", "", `{"username":"alice","password":"secret123"}`, nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("login while the session store is down", func(t *testing.T) {
+		jwtManager := newTestJWTManager(t, auth.NewMemoryRevocationStore(0, 0))
+		jwtManager.SetSessionStore(downSessionStore{MemorySessionStore: auth.NewMemorySessionStore()})
+
+		r := gin.New()
+		r.POST("/api/auth/login", NewAuthHandler(jwtManager, newTestUserService(t)).Login)
+		w := serve(r, http.MethodPost, "/api/auth/login", "", `{"username":"alice","password":"secret123"}`, nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("sessions while the session store is down", func(t *testing.T) {
+		jwtManager := newTestJWTManager(t, auth.NewMemoryRevocationStore(0, 0))
+		jwtManager.SetSessionStore(unreadableSessionStore{MemorySessionStore: auth.NewMemorySessionStore()})
+		authHandler := NewAuthHandler(jwtManager, newTestUserService(t))
+
+		r := gin.New()
+		r.GET("/api/auth/sessions", withClaims(claims), authHandler.Sessions)
+		r.DELETE("/api/auth/sessions/:id", withClaims(claims), authHandler.RevokeSession)
+
+		w := serve(r, http.MethodGet, "/api/auth/sessions", "", "", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		w = serve(r, http.MethodDelete, "/api/auth/sessions/"+claims.SessionID, "", "", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("refresh keeps the refresh token usable", func(t *testing.T) {
+		w := serve(r, http.MethodPost, "/api/auth/refresh", refreshToken, "", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		w := serve(r, http.MethodPost, "/api/auth/logout", accessToken, "", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("logout everywhere", func(t *testing.T) {
+		w := serve(r, http.MethodPost, "/api/auth/logout-all", "", "", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("signing out a session", func(t *testing.T) {
+		w := serve(r, http.MethodDelete, "/api/auth/sessions/"+claims.SessionID, "", "", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("introspection doesn't call the token inactive", func(t *testing.T) {
+		form := url.Values{"token": {accessToken}}
+		req := httptest.NewRequest(http.MethodPost, "/api/oauth/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("gateway", "s3cret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		var response OAuthErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "temporarily_unavailable", response.Error)
+	})
+}
+
+func TestLoginProtection(t *testing.T) {
+	clock := auth.NewFakeClock(time.Now())
+	store := auth.NewMemoryLoginAttemptStore()
+	store.SetClock(clock)
+	limiter := auth.NewLoginLimiter(store, auth.LoginLimits{
+		MaxFailures:     4,
+		Window:          15 * time.Minute,
+		LockoutDuration: 15 * time.Minute,
+		DelayAfter:      2,
+		BaseDelay:       2 * time.Second,
+	})
+	limiter.SetClock(clock)
+
+	authHandler := NewAuthHandler(newTestJWTManager(t, auth.NewMemoryRevocationStore(0, 0)), newTestUserService(t))
+	authHandler.SetLoginLimiter(limiter)
+
+	r := gin.New()
+	r.POST("/api/auth/login", authHandler.Login)
+
+	type answer struct {
+		Code       int
+		RetryAfter string
+		Body       string
+	}
+
+	// guessPasswords makes the same wrong guesses at username, waiting as told to
+	guessPasswords := func(username string) []answer {
+		var answers []answer
+		login := func() {
+			w := serve(r, http.MethodPost, "/api/auth/login", "", `{"username":"`+username+`","password":"wrong"}`, nil)
+			answers = append(answers, answer{Code: w.Code, RetryAfter: w.Header().Get("Retry-After"), Body: w.Body.String()})
+		}
+
+		login()
+		login()
+		login() // too soon
+		clock.Advance(2 * time.Second)
+		login()
+		login() // too soon
+		clock.Advance(4 * time.Second)
+		login() // the last allowed failure locks the username
+		login()
+		return answers
+	}
+
+	existing := guessPasswords("alice")
+	var codes []int
+	for _, answer := range existing {
+		codes = append(codes, answer.Code)
+	}
+	assert.Equal(t, []int{401, 401, 429, 401, 429, 401, 423}, codes)
+	assert.Equal(t, "2", existing[2].RetryAfter)
+	assert.Equal(t, "4", existing[4].RetryAfter)
+	assert.Equal(t, "900", existing[6].RetryAfter)
+
+	// Nothing tells an unknown username apart from an existing one
+	assert.Equal(t, existing, guessPasswords("mallory"))
+
+	// The right password doesn't get past the lockout either
+	w := serve(r, http.MethodPost, "/api/auth/login", "", `{"username":"alice","password":"secret123"}`, nil)
+	assert.Equal(t, http.StatusLocked, w.Code)
+
+	clock.Advance(15 * time.Minute)
+	w = serve(r, http.MethodPost, "/api/auth/login", "", `{"username":"alice","password":"secret123"}`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLogoutRejectsInvalidTokens(t *testing.T) {
+	authHandler := NewAuthHandler(newTestJWTManager(t, auth.NewMemoryRevocationStore(0, 0)), newTestUserService(t))
+
+	r := gin.New()
+	r.POST("/api/auth/logout", authHandler.Logout)
+
+	// A token of another issuer's key
+	otherCfg := newTestConfig()
+	otherCfg.JWTSecret = "another-secret-key"
+	other, err := auth.NewJWTManager(otherCfg, auth.NewMemoryRevocationStore(0, 0))
+	require.NoError(t, err)
+	foreignToken, _, err := other.GenerateTokens(context.Background(), &models.User{ID: 1, Username: "alice", Role: "user"})
+	require.NoError(t, err)
+
+	for name, token := range map[string]string{
+		"garbage":     "garbage",
+		"not a jwt":   "a.b.c",
+		"foreign key": foreignToken,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := serve(r, http.MethodPost, "/api/auth/logout", token, "", nil)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		})
+	}
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	// An HMAC key is a shared secret, so third parties can't verify its tokens
+	jwtManager := newTestJWTManager(t, auth.NewMemoryRevocationStore(0, 0))
+	_, err := jwtManager.Keyring().Rotate(auth.AlgorithmEdDSA, 0, time.Hour)
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.GET("/.well-known/openid-configuration", NewWellKnownHandler(jwtManager, "https://auth.example.com/").OpenIDConfiguration)
+
+	w := serve(r, http.MethodGet, "/.well-known/openid-configuration", "", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &document))
+	assert.Equal(t, "https://auth.example.com", document["issuer"])
+	assert.Equal(t, []interface{}{auth.AlgorithmEdDSA}, document["id_token_signing_alg_values_supported"])
+
+	// Logins aren't RFC 6749 token requests, so no token endpoint is advertised
+	assert.NotContains(t, document, "token_endpoint")
+	assert.NotContains(t, document, "grant_types_supported")
+	assert.NotContains(t, document, "response_types_supported")
+}
+
+func TestRevocationRequiresClientCredentials(t *testing.T) {
+	jwtManager := newTestJWTManager(t, auth.NewMemoryRevocationStore(0, 0))
+	accessToken, _, err := jwtManager.GenerateTokens(context.Background(), &models.User{ID: 1, Username: "alice", Role: "user"})
+	require.NoError(t, err)
+
+	oauthHandler := NewOAuthHandler(jwtManager, auth.NewClientRegistry(map[string]string{"gateway": "s3cret"}))
+	wellKnownHandler := NewWellKnownHandler(jwtManager, "https://auth.example.com")
+	r := gin.New()
+	r.POST("/api/oauth/revoke", oauthHandler.Revoke)
+	r.GET("/.well-known/openid-configuration", wellKnownHandler.OpenIDConfiguration)
+
+	revoke := func(authenticate bool) int {
+		form := url.Values{"token": {accessToken}}
+		req := httptest.NewRequest(http.MethodPost, "/api/oauth/revoke", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if authenticate {
+			req.SetBasicAuth("gateway", "s3cret")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	revocationAuthMethods := func() []interface{} {
+		w := serve(r, http.MethodGet, "/.well-known/openid-configuration", "", "", nil)
+		var document map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &document))
+		return document["revocation_endpoint_auth_methods_supported"].([]interface{})
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, revoke(false))
+	assert.NotContains(t, revocationAuthMethods(), "none")
+	assert.Equal(t, http.StatusOK, revoke(true))
+
+	// Public clients have to be enabled explicitly
+	oauthHandler.SetPublicClients(true)
+	wellKnownHandler.SetPublicClients(true)
+	assert.Equal(t, http.StatusOK, revoke(false))
+	assert.Contains(t, revocationAuthMethods(), "none")
+}
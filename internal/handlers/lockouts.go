@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// LockoutHandler lifts the lockouts of the login limiter
+type LockoutHandler struct {
+	limiter *auth.LoginLimiter
+}
+
+// NewLockoutHandler creates a new lockout handler
+func NewLockoutHandler(limiter *auth.LoginLimiter) *LockoutHandler {
+	return &LockoutHandler{limiter: limiter}
+}
+
+// UnlockUser handles requests lifting the lockout of a username
+// @Summary Unlock a username
+// @Description Clear the failed login attempts and lockout of a username. Any username is accepted, whether or not it exists.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} map[string]string "Username unlocked"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /admin/lockouts/users/{username} [delete]
+func (h *LockoutHandler) UnlockUser(c *gin.Context) {
+	if err := h.limiter.UnlockUser(c.Request.Context(), c.Param("username")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to unlock username"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "username unlocked"})
+}
+
+// UnlockIP handles requests lifting the lockout of a client IP
+// @Summary Unlock a client IP
+// @Description Clear the failed login attempts and lockout of a client IP address
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param ip path string true "Client IP address"
+// @Success 200 {object} map[string]string "IP address unlocked"
+// @Failure 400 {object} ErrorResponse "Invalid IP address"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /admin/lockouts/ips/{ip} [delete]
+func (h *LockoutHandler) UnlockIP(c *gin.Context) {
+	ip, err := netip.ParseAddr(c.Param("ip"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid IP address"})
+		return
+	}
+
+	if err := h.limiter.UnlockIP(c.Request.Context(), ip.Unmap().String()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to unlock IP address"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "IP address unlocked"})
+}
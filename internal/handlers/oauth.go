@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OAuthHandler serves the OAuth 2.0 endpoints used by other services
+type OAuthHandler struct {
+	jwtManager    *auth.JWTManager
+	clients       *auth.ClientRegistry
+	publicClients bool
+}
+
+// NewOAuthHandler creates a new OAuth handler
+func NewOAuthHandler(jwtManager *auth.JWTManager, clients *auth.ClientRegistry) *OAuthHandler {
+	return &OAuthHandler{
+		jwtManager: jwtManager,
+		clients:    clients,
+	}
+}
+
+// SetPublicClients lets callers without client credentials revoke tokens,
+// for public clients such as browser and mobile apps that can't keep a secret
+func (h *OAuthHandler) SetPublicClients(allowed bool) {
+	h.publicClients = allowed
+}
+
+// TokenRequest represents an introspection or revocation request body,
+// sent either form-encoded or as JSON
+type TokenRequest struct {
+	Token         string `form:"token" json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	TokenTypeHint string `form:"token_type_hint" json:"token_type_hint" example:"refresh_token"`
+	ClientID      string `form:"client_id" json:"client_id" example:"gateway"`
+	ClientSecret  string `form:"client_secret" json:"client_secret" example:"change-me"`
+}
+
+// IntrospectionResponse represents the RFC 7662 introspection response
+type IntrospectionResponse struct {
+	Active    bool     `json:"active" example:"true"`
+	Subject   string   `json:"sub,omitempty" example:"1"`
+	Issuer    string   `json:"iss,omitempty" example:"http://localhost:8080"`
+	Audience  []string `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty" example:"1700000900"`
+	IssuedAt  int64    `json:"iat,omitempty" example:"1700000000"`
+	NotBefore int64    `json:"nbf,omitempty" example:"1700000000"`
+	TokenID   string   `json:"jti,omitempty" example:"6f1c2d..."`
+	TokenType string   `json:"token_type,omitempty" example:"Bearer"`
+	TokenUse  string   `json:"token_use,omitempty" example:"access"` // extension: "access" or "refresh"
+	Username  string   `json:"username,omitempty" example:"admin"`
+	Scope     string   `json:"scope,omitempty" example:"profile read"`
+}
+
+// OAuthErrorResponse represents an RFC 6749 error response
+type OAuthErrorResponse struct {
+	Error string `json:"error" example:"invalid_client"`
+}
+
+// Introspect handles RFC 7662 token introspection requests
+// @Summary Introspect a token
+// @Description Report whether a token is currently active, including revocation, together with its claims. The calling client authenticates with HTTP Basic or client_id/client_secret parameters.
+// @Tags oauth
+// @Accept x-www-form-urlencoded,json
+// @Produce json
+// @Security BasicAuth
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} IntrospectionResponse "Introspection result"
+// @Failure 400 {object} OAuthErrorResponse "Invalid request"
+// @Failure 401 {object} OAuthErrorResponse "Invalid client"
+// @Failure 503 {object} OAuthErrorResponse "Revocation store unavailable"
+// @Router /oauth/introspect [post]
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	req, ok := h.bindTokenRequest(c, true)
+	if !ok {
+		return
+	}
+
+	// Tokens that fail verification for any reason, revocation included, are simply inactive
+	claims, err := h.jwtManager.VerifyToken(c.Request.Context(), req.Token)
+	if isStoreUnavailable(err) {
+		// An unavailable store says nothing about the token, so don't report it as inactive
+		c.JSON(http.StatusServiceUnavailable, OAuthErrorResponse{Error: "temporarily_unavailable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	c.JSON(http.StatusOK, IntrospectionResponse{
+		Active:    true,
+		Subject:   claims.Subject,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		ExpiresAt: numericDate(claims.ExpiresAt),
+		IssuedAt:  numericDate(claims.IssuedAt),
+		NotBefore: numericDate(claims.NotBefore),
+		TokenID:   claims.TokenID,
+		TokenType: "Bearer",
+		TokenUse:  claims.TokenType,
+		Username:  claims.Username,
+		Scope:     claims.Scope,
+	})
+}
+
+// Revoke handles RFC 7009 token revocation requests
+// @Summary Revoke a token
+// @Description Revoke an access or refresh token held by the client. Revoking a refresh token also revokes every access token minted from it. Unknown, invalid and already revoked tokens are answered with 200 as well. The calling client authenticates like for introspection, unless public clients are enabled, in which case credentials are optional but must be valid when presented.
+// @Tags oauth
+// @Accept x-www-form-urlencoded,json
+// @Produce json
+// @Security BasicAuth
+// @Param token formData string true "Token to revoke"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 "Token revoked or unknown"
+// @Failure 400 {object} OAuthErrorResponse "Invalid request"
+// @Failure 401 {object} OAuthErrorResponse "Invalid client"
+// @Failure 503 {object} OAuthErrorResponse "Revocation store unavailable"
+// @Router /oauth/revoke [post]
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	req, ok := h.bindTokenRequest(c, !h.publicClients)
+	if !ok {
+		return
+	}
+
+	// Self-contained tokens carry their own type, so token_type_hint never
+	// changes where the token is looked up and is accepted only for compliance
+	if err := h.jwtManager.RevokeToken(c.Request.Context(), req.Token); err != nil {
+		log.Printf("Failed to revoke token: %v", err)
+		c.JSON(http.StatusServiceUnavailable, OAuthErrorResponse{Error: "temporarily_unavailable"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// bindTokenRequest parses the request and authenticates the calling client,
+// writing the RFC 6749 error response when either fails. Credentials may only
+// be omitted when requireClient is not set.
+func (h *OAuthHandler) bindTokenRequest(c *gin.Context, requireClient bool) (*TokenRequest, bool) {
+	var req TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_request"})
+		return nil, false
+	}
+
+	// HTTP Basic credentials take precedence over body parameters
+	clientID, clientSecret, hasBasic := c.Request.BasicAuth()
+	if !hasBasic {
+		clientID, clientSecret = req.ClientID, req.ClientSecret
+	}
+
+	presented := hasBasic || clientID != "" || clientSecret != ""
+	if (requireClient || presented) && !h.clients.Authenticate(clientID, clientSecret) {
+		log.Printf("Rejected OAuth request from unknown client %q", clientID)
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, OAuthErrorResponse{Error: "invalid_client"})
+		return nil, false
+	}
+
+	if req.Token == "" {
+		c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_request"})
+		return nil, false
+	}
+
+	return &req, true
+}
+
+// numericDate converts an optional JWT date to Unix seconds
+func numericDate(date *jwt.NumericDate) int64 {
+	if date == nil {
+		return 0
+	}
+	return date.Unix()
+}
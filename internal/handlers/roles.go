@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// RoleHandler manages the roles assigned to users
+type RoleHandler struct {
+	authorizer *auth.Authorizer
+}
+
+// NewRoleHandler creates a new role handler
+func NewRoleHandler(authorizer *auth.Authorizer) *RoleHandler {
+	return &RoleHandler{authorizer: authorizer}
+}
+
+// UserRolesRequest represents the request body replacing a user's roles
+type UserRolesRequest struct {
+	Roles []string `json:"roles" example:"editor"`
+}
+
+// UserRolesResponse represents a user's roles and the permissions they grant
+type UserRolesResponse struct {
+	UserID      int      `json:"user_id" example:"2"`
+	Roles       []string `json:"roles" example:"editor,user"`
+	Permissions []string `json:"permissions" example:"profile:read,reports:read,reports:write"`
+}
+
+// GetUserRoles handles requests for a user's roles
+// @Summary Get a user's roles
+// @Description List the roles of a user, including inherited ones, and the permissions they grant
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} UserRolesResponse "Roles and permissions"
+// @Failure 400 {object} ErrorResponse "Invalid user ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /admin/users/{id}/roles [get]
+func (h *RoleHandler) GetUserRoles(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user ID"})
+		return
+	}
+
+	h.respondWithRoles(c, userID)
+}
+
+// SetUserRoles handles requests replacing a user's roles
+// @Summary Set a user's roles
+// @Description Replace the roles assigned to a user. The change applies to tokens already issued to them.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param request body UserRolesRequest true "Roles to assign"
+// @Success 200 {object} UserRolesResponse "Updated roles and permissions"
+// @Failure 400 {object} ErrorResponse "Invalid request or unknown role"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /admin/users/{id}/roles [put]
+func (h *RoleHandler) SetUserRoles(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user ID"})
+		return
+	}
+
+	var req UserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request"})
+		return
+	}
+
+	if err := h.authorizer.SetUserRoles(c.Request.Context(), userID, req.Roles); err != nil {
+		if errors.Is(err, auth.ErrUnknownRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to update roles"})
+		return
+	}
+
+	h.respondWithRoles(c, userID)
+}
+
+// respondWithRoles writes the user's effective roles and permissions
+func (h *RoleHandler) respondWithRoles(c *gin.Context, userID int) {
+	roles, err := h.authorizer.Roles(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to load roles"})
+		return
+	}
+
+	permissions, err := h.authorizer.Permissions(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to load roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, UserRolesResponse{
+		UserID:      userID,
+		Roles:       roles,
+		Permissions: permissions,
+	})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionResponse describes one of the user's sessions
+type SessionResponse struct {
+	ID         string    `json:"id" example:"01912f6e-8c3a-7b5e-9d41-6f0c2a7e3b10"`
+	Device     string    `json:"device" example:"iPhone"`
+	UserAgent  string    `json:"user_agent" example:"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"`
+	IP         string    `json:"ip" example:"203.0.113.7"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current" example:"true"` // the session of the token making the request
+}
+
+// Sessions lists the current user's sessions
+// @Summary List sessions
+// @Description List the devices the current user is signed in on, oldest first. The last seen time is updated on login and refresh.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} SessionResponse "Active sessions"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 503 {object} ErrorResponse "Session store unavailable"
+// @Router /auth/sessions [get]
+func (h *AuthHandler) Sessions(c *gin.Context) {
+	// Get user claims from context (set by auth middleware)
+	claims, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+		return
+	}
+
+	userClaims := claims.(*auth.JWTClaims)
+
+	sessions, err := h.jwtManager.Sessions(c.Request.Context(), userClaims.UserID)
+	if isStoreUnavailable(err) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "sessions unavailable, please retry"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to list sessions"})
+		return
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			ID:         session.ID,
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == userClaims.SessionID,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession signs the current user out of one of their sessions
+// @Summary Sign out a session
+// @Description Sign the current user out on one device, revoking the access and refresh tokens of that session
+// @Tags auth
+// @Security BearerAuth
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]string "Session signed out"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 404 {object} ErrorResponse "Session not found"
+// @Failure 503 {object} ErrorResponse "Revocation or session store unavailable"
+// @Router /auth/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	// Get user claims from context (set by auth middleware)
+	claims, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+		return
+	}
+
+	userClaims := claims.(*auth.JWTClaims)
+
+	err := h.jwtManager.RevokeSession(c.Request.Context(), userClaims.UserID, c.Param("id"), auth.SessionSignedOut)
+	if errors.Is(err, auth.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "session not found"})
+		return
+	}
+	if isStoreUnavailable(err) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "sign out unavailable, please retry"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to sign out session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session signed out"})
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// wellKnownMaxAge is how long clients may cache the well-known documents, in
+// seconds, bounded by the publish window of rotated keys
+const wellKnownMaxAge = int(auth.JWKSMaxAge / time.Second)
+
+// clientAuthMethods are the ways OAuth clients can authenticate to this server
+var clientAuthMethods = []string{"client_secret_basic", "client_secret_post"}
+
+// WellKnownHandler serves the JWKS and discovery documents that let third-party
+// JWT validators use this server as an issuer
+type WellKnownHandler struct {
+	jwtManager    *auth.JWTManager
+	issuer        string
+	publicClients bool
+}
+
+// NewWellKnownHandler creates a new well-known documents handler
+func NewWellKnownHandler(jwtManager *auth.JWTManager, issuer string) *WellKnownHandler {
+	return &WellKnownHandler{
+		jwtManager: jwtManager,
+		issuer:     strings.TrimSuffix(issuer, "/"),
+	}
+}
+
+// SetPublicClients advertises that the revocation endpoint accepts callers
+// without client credentials, matching OAuthHandler.SetPublicClients
+func (h *WellKnownHandler) SetPublicClients(allowed bool) {
+	h.publicClients = allowed
+}
+
+// DiscoveryDocument is OpenID-style provider metadata, not an OpenID Connect
+// compliant one. It names no token endpoint, as logins take a JSON body rather
+// than an RFC 6749 token request, and no response types, as there is no
+// authorization endpoint to serve them.
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	IntrospectionAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	RevocationAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+}
+
+// JWKS serves the public half of every verification key in the keyring.
+// HMAC keys are shared secrets and are never published.
+func (h *WellKnownHandler) JWKS(c *gin.Context) {
+	h.serveCacheable(c, h.jwtManager.Keyring().PublicJWKS())
+}
+
+// OpenIDConfiguration serves the discovery document describing the issuer
+func (h *WellKnownHandler) OpenIDConfiguration(c *gin.Context) {
+	h.serveCacheable(c, DiscoveryDocument{
+		Issuer:                            h.issuer,
+		JWKSURI:                           h.issuer + "/.well-known/jwks.json",
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  h.jwtManager.Keyring().PublicAlgorithms(),
+		IntrospectionEndpoint:             h.issuer + "/api/oauth/introspect",
+		IntrospectionAuthMethodsSupported: clientAuthMethods,
+		RevocationEndpoint:                h.issuer + "/api/oauth/revoke",
+		RevocationAuthMethodsSupported:    h.revocationAuthMethods(),
+	})
+}
+
+// revocationAuthMethods lists "none" next to the client authentication
+// methods only when public clients may revoke tokens
+func (h *WellKnownHandler) revocationAuthMethods() []string {
+	if h.publicClients {
+		return append([]string{"none"}, clientAuthMethods...)
+	}
+	return clientAuthMethods
+}
+
+// serveCacheable writes a JSON document with Cache-Control and ETag headers,
+// answering conditional requests with 304 Not Modified
+func (h *WellKnownHandler) serveCacheable(c *gin.Context, document interface{}) {
+	body, err := json.Marshal(document)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to encode document"})
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:8]))
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", wellKnownMaxAge))
+	c.Header("ETag", etag)
+
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", body)
+}
@@ -2,7 +2,10 @@ package middleware
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
@@ -12,17 +15,33 @@ import (
 // AuthMiddleware is a middleware that validates JWT tokens
 type AuthMiddleware struct {
 	jwtManager *auth.JWTManager
+	authorizer *auth.Authorizer
 }
 
-// NewAuthMiddleware creates a new authentication middleware
-func NewAuthMiddleware(jwtManager *auth.JWTManager) *AuthMiddleware {
+// NewAuthMiddleware creates a new authentication middleware. The authorizer
+// backs RequirePermission and makes RequireRole follow the role hierarchy; it
+// may be nil, in which case RequireRole compares the token's role claim.
+func NewAuthMiddleware(jwtManager *auth.JWTManager, authorizer *auth.Authorizer) *AuthMiddleware {
 	return &AuthMiddleware{
 		jwtManager: jwtManager,
+		authorizer: authorizer,
 	}
 }
 
 // Authenticate middleware for Gin
 func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
+	return m.authenticate("")
+}
+
+// AuthenticateWithPolicy is Authenticate with the availability policy to apply
+// to its routes while the revocation store is failing, e.g. to keep admin
+// routes fail-closed when the rest of the API fails open
+func (m *AuthMiddleware) AuthenticateWithPolicy(policy auth.AvailabilityPolicy) gin.HandlerFunc {
+	return m.authenticate(policy)
+}
+
+// authenticate verifies the bearer token, overriding the availability policy unless it is empty
+func (m *AuthMiddleware) authenticate(policy auth.AvailabilityPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -38,14 +57,39 @@ func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
 
 		tokenString := parts[1]
 
-		claims, err := m.jwtManager.VerifyToken(tokenString)
+		ctx := c.Request.Context()
+		if policy != "" {
+			ctx = auth.WithAvailabilityPolicy(ctx, policy)
+		}
+
+		claims, err := m.jwtManager.VerifyToken(ctx, tokenString)
 		if err != nil {
+			var timeoutErr *auth.StoreTimeoutError
+			if errors.As(err, &timeoutErr) {
+				log.Printf("Token verification failed: %v", err)
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": "token verification timed out"})
+				return
+			}
+			if errors.Is(err, auth.ErrRevocationStoreUnavailable) {
+				log.Printf("Token verification failed: %v", err)
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": "token verification unavailable"})
+				return
+			}
+
 			var message string
 			switch {
+			case errors.Is(err, auth.ErrInvalidIssuer):
+				message = "token issued by another issuer"
+			case errors.Is(err, auth.ErrInvalidAudience):
+				message = "token not intended for this audience"
+			case errors.Is(err, auth.ErrTokenNotYetValid):
+				message = "token not valid yet"
 			case errors.Is(err, auth.ErrInvalidToken):
 				message = "invalid token"
 			case errors.Is(err, auth.ErrTokenExpired):
 				message = "token expired"
+			case errors.Is(err, auth.ErrSessionEvicted):
+				message = "session evicted by a newer login"
 			case errors.Is(err, auth.ErrTokenBlacklisted):
 				message = "token has been revoked"
 			default:
@@ -75,7 +119,53 @@ func (m *AuthMiddleware) RequireRole(role string) gin.HandlerFunc {
 		}
 
 		claims := userClaims.(*auth.JWTClaims)
-		if claims.Role != role {
+		if m.authorizer == nil {
+			if claims.Role != role {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "insufficient permissions"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// Look the roles up rather than trusting the claim, so role changes apply at once
+		roles, err := m.authorizer.Roles(c.Request.Context(), claims.UserID)
+		if err != nil {
+			log.Printf("Role lookup failed: %v", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": "authorization unavailable"})
+			return
+		}
+		if !slices.Contains(roles, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "insufficient permissions"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequirePermission middleware for Gin, passing only users one of whose roles
+// grants the permission. It needs an authorizer.
+func (m *AuthMiddleware) RequirePermission(permission string) gin.HandlerFunc {
+	if m.authorizer == nil {
+		panic("middleware: RequirePermission needs an authorizer")
+	}
+
+	return func(c *gin.Context) {
+		userClaims, exists := c.Get("user")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "user not authenticated"})
+			return
+		}
+
+		claims := userClaims.(*auth.JWTClaims)
+		allowed, err := m.authorizer.HasPermission(c.Request.Context(), claims.UserID, permission)
+		if err != nil {
+			log.Printf("Permission lookup failed: %v", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": "authorization unavailable"})
+			return
+		}
+		if !allowed {
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "insufficient permissions"})
 			return
 		}
@@ -83,3 +173,94 @@ func (m *AuthMiddleware) RequireRole(role string) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// RequireScope middleware for Gin, passing only tokens issued with the scope
+func (m *AuthMiddleware) RequireScope(scope string) gin.HandlerFunc {
+	return m.RequireAllScopes(scope)
+}
+
+// RequireAnyScope middleware for Gin, passing tokens issued with at least one of the scopes
+func (m *AuthMiddleware) RequireAnyScope(scopes ...string) gin.HandlerFunc {
+	return requireScopes(scopes, func(claims *auth.JWTClaims) bool {
+		return slices.ContainsFunc(scopes, claims.HasScope)
+	})
+}
+
+// RequireAllScopes middleware for Gin, passing only tokens issued with every one of the scopes
+func (m *AuthMiddleware) RequireAllScopes(scopes ...string) gin.HandlerFunc {
+	return requireScopes(scopes, func(claims *auth.JWTClaims) bool {
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// requireScopes rejects requests whose token fails allowed, naming the scopes
+// in the WWW-Authenticate header as RFC 6750 describes
+func requireScopes(scopes []string, allowed func(*auth.JWTClaims) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userClaims, exists := c.Get("user")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "user not authenticated"})
+			return
+		}
+
+		claims := userClaims.(*auth.JWTClaims)
+		if !allowed(claims) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, auth.FormatScope(scopes)))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "insufficient scope"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequirePolicy middleware for Gin, evaluating the policy's rules against the
+// token claims and request attributes. It goes after Authenticate; in dry-run
+// mode denials are only logged.
+func (m *AuthMiddleware) RequirePolicy(engine *auth.PolicyEngine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userClaims, exists := c.Get("user")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "user not authenticated"})
+			return
+		}
+
+		claims := userClaims.(*auth.JWTClaims)
+		params := make(map[string]string, len(c.Params))
+		for _, param := range c.Params {
+			params[param.Key] = param.Value
+		}
+
+		decision := engine.Evaluate(auth.PolicyRequest{
+			Claims:   claims,
+			Method:   c.Request.Method,
+			Path:     c.Request.URL.Path,
+			Route:    c.FullPath(),
+			Params:   params,
+			Query:    c.Request.URL.Query(),
+			Header:   c.Request.Header,
+			ClientIP: c.ClientIP(),
+		})
+		if !decision.Allowed {
+			rule := decision.Rule
+			if rule == "" {
+				rule = "default"
+			}
+
+			if engine.DryRun() {
+				log.Printf("Policy dry run: would deny %s %s for user %d by %s", c.Request.Method, c.Request.URL.Path, claims.UserID, rule)
+			} else {
+				log.Printf("Policy denied %s %s for user %d by %s", c.Request.Method, c.Request.URL.Path, claims.UserID, rule)
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "access denied by policy"})
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
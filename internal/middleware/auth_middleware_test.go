@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anhbkpro/jwt-blacklist-go/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestRequirePolicyClientIP(t *testing.T) {
+	// Only the office network may reach the route
+	engine, err := auth.NewPolicyEngine(auth.Policy{
+		Default: auth.EffectDeny,
+		Rules: []auth.PolicyRule{{
+			Name:   "office-only",
+			Effect: auth.EffectAllow,
+			When:   []auth.PolicyCondition{{Attribute: "ip", CIDR: []string{"203.0.113.0/24"}}},
+		}},
+	})
+	require.NoError(t, err)
+
+	newRouter := func(trustedProxies []string) *gin.Engine {
+		r := gin.New()
+		require.NoError(t, r.SetTrustedProxies(trustedProxies))
+
+		m := NewAuthMiddleware(nil, nil)
+		authenticated := func(c *gin.Context) {
+			c.Set("user", &auth.JWTClaims{UserID: 1, Username: "alice", Role: "user"})
+			c.Next()
+		}
+		r.GET("/api/reports", authenticated, m.RequirePolicy(engine), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		return r
+	}
+
+	// A client outside the office claims to be forwarded from inside it
+	request := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/reports", nil)
+		req.RemoteAddr = "198.51.100.7:51234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		return req
+	}
+
+	t.Run("spoofed header is ignored without trusted proxies", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newRouter(nil).ServeHTTP(w, request())
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("header is believed from a trusted proxy", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newRouter([]string{"198.51.100.0/24"}).ServeHTTP(w, request())
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
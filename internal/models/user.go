@@ -37,6 +37,10 @@ var defaultParams = &PasswordParams{
 	KeyLength:   32,
 }
 
+// dummyPasswordHash is a hash with the default parameters that no password
+// is expected to match, verified against when a user doesn't exist
+const dummyPasswordHash = "$argon2id$v=19$m=65536,t=3,p=2$EBrerWi2UZF14qOEvw8kUA$k5JWQVeh6eZ1xaGxXBvBSfvmG0qhv4+GeqCXTNLWwTw"
+
 // HashPassword creates a new password hash using Argon2id
 func HashPassword(password string) (string, error) {
 	p := defaultParams
@@ -75,6 +79,12 @@ func VerifyPassword(password, encodedHash string) (bool, error) {
 	return subtle.ConstantTimeCompare(hash, newHash) == 1, nil
 }
 
+// VerifyDummyPassword hashes the password as VerifyPassword would, so a login
+// for an unknown user takes as long as one with a wrong password
+func VerifyDummyPassword(password string) {
+	_, _ = VerifyPassword(password, dummyPasswordHash)
+}
+
 // Helper function to decode a password hash
 func decodeHash(encodedHash string) (*PasswordParams, []byte, []byte, error) {
 	var params PasswordParams
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_revocation_epochs (
+    user_id INTEGER PRIMARY KEY,
+    revoked_before TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Create index on expires_at for the periodic purge
+CREATE INDEX IF NOT EXISTS idx_user_revocation_epochs_expires_at ON user_revocation_epochs(expires_at);
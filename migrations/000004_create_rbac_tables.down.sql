@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_inherits;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
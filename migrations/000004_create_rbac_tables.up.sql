@@ -0,0 +1,53 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+-- A role grants the permissions of every role it inherits
+CREATE TABLE IF NOT EXISTS role_inherits (
+    role VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    parent VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    PRIMARY KEY (role, parent),
+    CHECK (role <> parent)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role)
+);
+
+-- Create index on role for listing the holders of a role
+CREATE INDEX IF NOT EXISTS idx_user_roles_role ON user_roles(role);
+
+-- Insert the default hierarchy: admin ⊇ editor ⊇ user
+INSERT INTO roles (name)
+VALUES ('user'), ('editor'), ('admin')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission)
+VALUES
+    ('user', 'profile:read'),
+    ('user', 'reports:read'),
+    ('editor', 'reports:write'),
+    ('admin', 'admin:dashboard'),
+    ('admin', 'users:manage')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_inherits (role, parent)
+VALUES
+    ('editor', 'user'),
+    ('admin', 'editor')
+ON CONFLICT DO NOTHING;
+
+-- Carry over the single role of existing users
+INSERT INTO user_roles (user_id, role)
+SELECT u.id, u.role FROM users u JOIN roles r ON r.name = u.role
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,11 @@
+-- Users whose roles were assigned, so an assignment of no roles isn't
+-- mistaken for a user never assigned any, who holds their users.role
+CREATE TABLE IF NOT EXISTS user_role_assignments (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    assigned_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Users carried over to user_roles already have their assignment
+INSERT INTO user_role_assignments (user_id)
+SELECT DISTINCT user_id FROM user_roles
+ON CONFLICT DO NOTHING;